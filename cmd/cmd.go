@@ -9,10 +9,13 @@ import (
 
 	"github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/USA-RedDragon/rtz-server/internal/db"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
 	"github.com/USA-RedDragon/rtz-server/internal/logparser"
 	"github.com/USA-RedDragon/rtz-server/internal/metrics"
 	"github.com/USA-RedDragon/rtz-server/internal/server"
 	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/telemetry"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
 	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 	"github.com/ztrue/shutdown"
@@ -32,31 +35,17 @@ func NewCommand(version, commit string) *cobra.Command {
 		SilenceErrors: true,
 	}
 	config.RegisterFlags(cmd)
+	cmd.AddCommand(newReprocessCommand())
+	cmd.AddCommand(newProxyCommand())
 	return cmd
 }
 
 func run(cmd *cobra.Command, _ []string) error {
 	slog.Info("rtz-server", "version", cmd.Annotations["version"], "commit", cmd.Annotations["commit"])
 
-	cfg, err := config.LoadConfig(cmd)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	switch cfg.LogLevel {
-	case config.LogLevelDebug:
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-	case config.LogLevelInfo:
-		slog.SetLogLoggerLevel(slog.LevelInfo)
-	case config.LogLevelWarn:
-		slog.SetLogLoggerLevel(slog.LevelWarn)
-	case config.LogLevelError:
-		slog.SetLogLoggerLevel(slog.LevelError)
-	}
-
-	err = cfg.Validate()
-	if err != nil {
-		return fmt.Errorf("config validation failed: %w", err)
+		return err
 	}
 
 	storage, err := storage.NewStorage(cfg)
@@ -80,9 +69,34 @@ func run(cmd *cobra.Command, _ []string) error {
 	}
 	slog.Info("Database connection established")
 
+	go func() {
+		err := models.BackfillDriveStats(db)
+		if err != nil {
+			slog.Error("Failed to backfill drive stats", "error", err)
+		}
+	}()
+
 	logQueue := logparser.NewLogQueue(cfg, db, storage, metrics)
+	if cfg.NATS.JetStream {
+		err = logQueue.UseJetStream(nc)
+		if err != nil {
+			return fmt.Errorf("failed to set up log parsing with JetStream: %w", err)
+		}
+	}
 	go logQueue.Start()
 
+	routeFinalizer := logparser.NewRouteFinalizer(db, cfg.RouteFinalizeAfter)
+	go routeFinalizer.Start()
+
+	telemetryPruner := telemetry.NewPruner(db, cfg.Telemetry.Retention, cfg.Telemetry.LogRetention)
+	go telemetryPruner.Start()
+
+	// Only set when the storage driver hands out presigned upload URLs
+	uploadWatcher := uploads.NewWatcher(db, storage, logQueue)
+	if uploadWatcher != nil {
+		go uploadWatcher.Start()
+	}
+
 	slog.Info("Starting HTTP server")
 	server := server.NewServer(cfg, db, nc, logQueue, metrics, storage)
 	err = server.Start()
@@ -100,6 +114,11 @@ func run(cmd *cobra.Command, _ []string) error {
 		})
 
 		errGrp.Go(func() error {
+			if uploadWatcher != nil {
+				uploadWatcher.Stop()
+			}
+			routeFinalizer.Stop()
+			telemetryPruner.Stop()
 			logQueue.Stop()
 			return nil
 		})
@@ -138,3 +157,28 @@ func run(cmd *cobra.Command, _ []string) error {
 
 	return nil
 }
+
+// loadConfig loads and validates the config, applying its log level
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	cfg, err := config.LoadConfig(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	switch cfg.LogLevel {
+	case config.LogLevelDebug:
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	case config.LogLevelInfo:
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	case config.LogLevelWarn:
+		slog.SetLogLoggerLevel(slog.LevelWarn)
+	case config.LogLevelError:
+		slog.SetLogLoggerLevel(slog.LevelError)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	return cfg, nil
+}
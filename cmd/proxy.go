@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/USA-RedDragon/rtz-server/internal/websocket"
+	gorillaWebsocket "github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+const (
+	proxyURLKey      = "url"
+	proxyTokenKey    = "token"
+	proxyDongleIDKey = "dongle-id"
+	proxyPortKey     = "port"
+	proxyListenKey   = "listen"
+
+	// proxyTokenEnv can hold the token instead of the flag, keeping it out of the process list
+	proxyTokenEnv = "RTZ_TOKEN"
+)
+
+func newProxyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Forward a local TCP port to a port on a device",
+		Long: "Listens on a local address and forwards each connection to a port on a device " +
+			"through the server's startLocalProxy support, so SSH can reach devices without a " +
+			"public address: ssh -p 2222 comma@localhost. The token is a user JWT for the " +
+			"device's owner, which can also be given in the " + proxyTokenEnv + " environment variable.",
+		RunE:          runProxy,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().String(proxyURLKey, "", "URL of the server")
+	cmd.Flags().String(proxyTokenKey, "", "User JWT to authenticate with")
+	cmd.Flags().String(proxyDongleIDKey, "", "Dongle ID of the device")
+	cmd.Flags().Int(proxyPortKey, 22, "Port to forward on the device")
+	cmd.Flags().String(proxyListenKey, "127.0.0.1:2222", "Local address to listen on")
+	return cmd
+}
+
+func runProxy(cmd *cobra.Command, _ []string) error {
+	serverURL, err := cmd.Flags().GetString(proxyURLKey)
+	if err != nil {
+		return err
+	}
+	token, err := cmd.Flags().GetString(proxyTokenKey)
+	if err != nil {
+		return err
+	}
+	dongleID, err := cmd.Flags().GetString(proxyDongleIDKey)
+	if err != nil {
+		return err
+	}
+	port, err := cmd.Flags().GetInt(proxyPortKey)
+	if err != nil {
+		return err
+	}
+	listen, err := cmd.Flags().GetString(proxyListenKey)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		token = os.Getenv(proxyTokenEnv)
+	}
+	switch {
+	case serverURL == "":
+		return errors.New("--url is required")
+	case dongleID == "":
+		return errors.New("--dongle-id is required")
+	case token == "":
+		return errors.New("--token or " + proxyTokenEnv + " is required")
+	}
+
+	endpoint, err := proxyEndpoint(serverURL, dongleID, port)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "JWT "+token)
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	slog.Info("Forwarding to device", "listen", listener.Addr().String(), "device", dongleID, "port", port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go forwardProxyConn(ctx, endpoint, header, conn)
+	}
+}
+
+// proxyEndpoint returns the websocket URL of the server's proxy endpoint for a device
+func proxyEndpoint(serverURL string, dongleID string, port int) (string, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(serverURL, "/") + "/v1/devices/" + url.PathEscape(dongleID) + "/proxy")
+	if err != nil {
+		return "", fmt.Errorf("invalid --url: %w", err)
+	}
+	switch endpoint.Scheme {
+	case "https":
+		endpoint.Scheme = "wss"
+	case "http":
+		endpoint.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("invalid --url: unsupported scheme %q", endpoint.Scheme)
+	}
+	endpoint.RawQuery = url.Values{"port": {fmt.Sprint(port)}}.Encode()
+	return endpoint.String(), nil
+}
+
+// forwardProxyConn opens a proxy session for a local connection and copies between them until either closes
+func forwardProxyConn(ctx context.Context, endpoint string, header http.Header, conn net.Conn) {
+	defer conn.Close()
+	ws, resp, err := gorillaWebsocket.DefaultDialer.DialContext(ctx, endpoint, header)
+	if err != nil {
+		if resp != nil {
+			// The server explains why it refused the session in the body
+			var body struct {
+				Error string `json:"error"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+				err = errors.New(body.Error)
+			}
+			_ = resp.Body.Close()
+			slog.Error("Failed to start proxy session", "status", resp.Status, "error", err)
+		} else {
+			slog.Error("Failed to start proxy session", "error", err)
+		}
+		return
+	}
+	slog.Info("Started proxy session", "client", conn.RemoteAddr().String())
+	fromDevice, toDevice := websocket.BridgeTCP(ws, conn)
+	slog.Info("Ended proxy session", "client", conn.RemoteAddr().String(), "bytes_to_device", toDevice, "bytes_from_device", fromDevice)
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/cobra"
+)
+
+const (
+	reprocessDongleIDKey = "dongle-id"
+	reprocessRouteKey    = "route"
+	reprocessAllKey      = "all"
+	reprocessDryRunKey   = "dry-run"
+)
+
+// reprocessProgressInterval is how often progress is reported while logs are parsed
+const reprocessProgressInterval = 5 * time.Second
+
+func newReprocessCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reprocess",
+		Short: "Rebuild routes from their stored logs",
+		Long: "Parses the stored qlogs and rlogs of a route, a device or every device again, " +
+			"replacing everything that was parsed from them before. Unless log parsing is " +
+			"shared through JetStream, stop the server first or use the admin API instead.",
+		RunE:          runReprocess,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	config.RegisterFlags(cmd)
+	cmd.Flags().String(reprocessDongleIDKey, "", "Only reprocess this device's logs")
+	cmd.Flags().String(reprocessRouteKey, "", "Only reprocess this route's logs, requires --dongle-id")
+	cmd.Flags().Bool(reprocessAllKey, false, "Reprocess every device's logs")
+	cmd.Flags().Bool(reprocessDryRunKey, false, "List the logs that would be reprocessed without changing anything")
+	return cmd
+}
+
+func runReprocess(cmd *cobra.Command, _ []string) error {
+	var scope logparser.ReprocessScope
+	var err error
+	scope.DongleID, err = cmd.Flags().GetString(reprocessDongleIDKey)
+	if err != nil {
+		return err
+	}
+	scope.Route, err = cmd.Flags().GetString(reprocessRouteKey)
+	if err != nil {
+		return err
+	}
+	all, err := cmd.Flags().GetBool(reprocessAllKey)
+	if err != nil {
+		return err
+	}
+	dryRun, err := cmd.Flags().GetBool(reprocessDryRunKey)
+	if err != nil {
+		return err
+	}
+	switch {
+	case all && scope.DongleID != "":
+		return errors.New("--all can't be combined with --dongle-id")
+	case !all && scope.DongleID == "":
+		return errors.New("either --dongle-id or --all is required")
+	case scope.Route != "" && scope.DongleID == "":
+		return errors.New("--route requires --dongle-id")
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	storage, err := storage.NewStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+	defer storage.Close()
+
+	db, err := db.MakeDB(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to make database: %w", err)
+	}
+
+	logs, err := logparser.FindStoredLogs(db, storage, scope)
+	if err != nil {
+		return fmt.Errorf("failed to find stored logs: %w", err)
+	}
+	out := cmd.OutOrStdout()
+	if dryRun {
+		for _, log := range logs {
+			fmt.Fprintf(out, "%s/%s\n", log.Device.DongleID, log.Path)
+		}
+		fmt.Fprintf(out, "Would reprocess %d logs\n", len(logs))
+		return nil
+	}
+	if len(logs) == 0 {
+		fmt.Fprintln(out, "No logs to reprocess")
+		return nil
+	}
+
+	logQueue := logparser.NewLogQueue(cfg, db, storage, nil)
+	if cfg.NATS.JetStream {
+		nc, err := nats.Connect(cfg.NATS.URL, nats.Token(cfg.NATS.Token))
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+		defer nc.Close()
+		err = logQueue.UseJetStream(nc)
+		if err != nil {
+			return fmt.Errorf("failed to set up log parsing with JetStream: %w", err)
+		}
+	}
+	go logQueue.Start()
+	defer logQueue.Stop()
+
+	ids, err := logQueue.Reprocess(logs)
+	if err != nil {
+		return fmt.Errorf("failed to queue logs: %w", err)
+	}
+	fmt.Fprintf(out, "Queued %d logs\n", len(ids))
+
+	ticker := time.NewTicker(reprocessProgressInterval)
+	defer ticker.Stop()
+	for {
+		counts, err := models.CountLogJobsByIDs(db, ids)
+		if err != nil {
+			return fmt.Errorf("failed to check progress: %w", err)
+		}
+		done := counts[models.LogJobStateDone]
+		failed := counts[models.LogJobStateFailed]
+		fmt.Fprintf(out, "Reprocessed %d/%d logs, %d failed\n", done+failed, len(ids), failed)
+		if done+failed == int64(len(ids)) {
+			if failed > 0 {
+				return fmt.Errorf("failed to reprocess %d logs", failed)
+			}
+			return nil
+		}
+		<-ticker.C
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/spf13/cobra"
@@ -20,8 +21,12 @@ type Config struct {
 	JWT                JWT          `json:"jwt"`
 	Mapbox             Mapbox       `json:"mapbox"`
 	NATS               NATS         `json:"nats"`
+	Telemetry          Telemetry    `json:"telemetry"`
 	ParallelLogParsers uint         `json:"parallel_log_parsers" yaml:"parallel_log_parsers"`
-	LogLevel           LogLevel     `json:"log_level" yaml:"log_level"`
+	// RouteFinalizeAfter is how long a route can go without new segments
+	// before it's closed without its final segment
+	RouteFinalizeAfter time.Duration `json:"route_finalize_after" yaml:"route_finalize_after"`
+	LogLevel           LogLevel      `json:"log_level" yaml:"log_level"`
 }
 
 type LogLevel string
@@ -37,6 +42,22 @@ type NATS struct {
 	Enabled bool   `json:"enabled"`
 	URL     string `json:"url"`
 	Token   string `json:"token"`
+	// JetStream distributes log parsing across every instance connected to NATS
+	JetStream bool `json:"jetstream"`
+}
+
+// Telemetry is what devices report about themselves through Athena
+type Telemetry struct {
+	// Retention is how long metrics from storeStats are kept
+	Retention time.Duration `json:"retention"`
+	// LogRetention is how long log lines from forwardLogs are kept
+	LogRetention time.Duration `json:"log_retention" yaml:"log_retention"`
+	// Prometheus exports the latest value of device metrics with the server's own metrics
+	Prometheus bool `json:"prometheus"`
+	// PrometheusMetrics are the device metrics Prometheus exports, as
+	// <name>:<field>, or just <name> for its value field. Each is a series per
+	// device, so they're limited to these.
+	PrometheusMetrics []string `json:"prometheus_metrics" yaml:"prometheus_metrics"`
 }
 
 type Sentinel struct {
@@ -204,16 +225,23 @@ var (
 	//nolint:golint,gosec
 	AuthCustomClientSecretKey = "auth.custom.client_secret"
 	//nolint:golint,gosec
-	AuthCustomTokenURLKey = "auth.custom.token_url"
-	AuthCustomUserURLKey  = "auth.custom.user_url"
-	JWTSecretKey          = "jwt.secret"
-	MapboxPublicTokenKey  = "mapbox.public_token"
-	MapboxSecretTokenKey  = "mapbox.secret_token"
-	NATSEnabledKey        = "nats.enabled"
-	NATSURLKey            = "nats.url"
-	NATSTokenKey          = "nats.token"
-	LogLevelKey           = "log_level"
-	ParallelLogParsersKey = "parallel_log_parsers"
+	AuthCustomTokenURLKey    = "auth.custom.token_url"
+	AuthCustomUserURLKey     = "auth.custom.user_url"
+	JWTSecretKey             = "jwt.secret"
+	MapboxPublicTokenKey     = "mapbox.public_token"
+	MapboxSecretTokenKey     = "mapbox.secret_token"
+	NATSEnabledKey           = "nats.enabled"
+	NATSURLKey               = "nats.url"
+	NATSTokenKey             = "nats.token"
+	NATSJetStreamKey         = "nats.jetstream"
+	TelemetryRetentionKey    = "telemetry.retention"
+	TelemetryPrometheusKey   = "telemetry.prometheus"
+	TelemetryLogRetentionKey = "telemetry.log_retention"
+	LogLevelKey              = "log_level"
+	ParallelLogParsersKey    = "parallel_log_parsers"
+	RouteFinalizeAfterKey    = "route_finalize_after"
+
+	TelemetryPrometheusMetricsKey = "telemetry.prometheus_metrics"
 )
 
 const (
@@ -228,11 +256,16 @@ const (
 	DefaultPersistenceDatabaseDatabase                  = "rtz.db"
 	DefaultRegistrationEnabled                          = false
 	DefaultNATSEnabled                                  = false
+	DefaultNATSJetStream                                = false
 	DefaultAuthGitHubEnabled                            = false
 	DefaultAuthGoogleEnabled                            = false
 	DefaultAuthCustomEnabled                            = false
 	DefaultLogLevel                                     = LogLevelInfo
 	DefaultParallelLogParsers                           = 4
+	DefaultRouteFinalizeAfter                           = time.Hour
+	DefaultTelemetryRetention                           = 30 * 24 * time.Hour
+	DefaultTelemetryPrometheus                          = false
+	DefaultTelemetryLogRetention                        = 7 * 24 * time.Hour
 	DefaultPersistenceUploadsDriver                     = UploadsDriverFilesystem
 	DefaultPersistenceUploadsFilesystemOptionsDirectory = "uploads/"
 )
@@ -282,30 +315,41 @@ func RegisterFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool(NATSEnabledKey, DefaultNATSEnabled, "Enable NATS")
 	cmd.Flags().String(NATSURLKey, "", "NATS URL")
 	cmd.Flags().String(NATSTokenKey, "", "NATS token")
+	cmd.Flags().Bool(NATSJetStreamKey, DefaultNATSJetStream, "Distribute log parsing across instances with NATS JetStream")
+	cmd.Flags().Duration(TelemetryRetentionKey, DefaultTelemetryRetention, "How long device metrics are kept")
+	cmd.Flags().Bool(TelemetryPrometheusKey, DefaultTelemetryPrometheus, "Export the latest device metrics with the Prometheus metrics")
+	cmd.Flags().Duration(TelemetryLogRetentionKey, DefaultTelemetryLogRetention, "How long forwarded device logs are kept")
+	cmd.Flags().StringSlice(TelemetryPrometheusMetricsKey, []string{}, "Comma-separated list of the device metrics exported with the Prometheus metrics, as <name>:<field> or <name> for its value field")
 	cmd.Flags().String(LogLevelKey, string(DefaultLogLevel), "Log level")
 	cmd.Flags().Uint(ParallelLogParsersKey, DefaultParallelLogParsers, "Number of parallel log parsers")
+	cmd.Flags().Duration(RouteFinalizeAfterKey, DefaultRouteFinalizeAfter, "How long a route can go without new segments before it's closed without its final segment")
 }
 
 var (
-	ErrJWTSecretRequired          = errors.New("JWT secret is required")
-	ErrBackendURLRequired         = errors.New("Backend URL is required")
-	ErrOTLPEndpointRequired       = errors.New("OTLP endpoint is required when tracing is enabled")
-	ErrMapboxPublicTokenRequired  = errors.New("Mapbox public token is required")
-	ErrMapboxSecretTokenRequired  = errors.New("Mapbox secret token is required")
-	ErrDBHostRequired             = errors.New("Database host is required")
-	ErrDBDatabaseRequired         = errors.New("Database name is required")
-	ErrDatabaseDriverRequired     = errors.New("Database driver is required")
-	ErrNATSURLRequired            = errors.New("NATS URL is required")
-	ErrGitHubOAuthRequired        = errors.New("GitHub OAuth client ID and secret are required")
-	ErrGoogleOAuthRequired        = errors.New("Google OAuth client ID and secret are required")
-	ErrCustomOAuthRequired        = errors.New("Custom OAuth client ID and secret are required")
-	ErrCustomTokenURLRequired     = errors.New("Custom OAuth token URL is required")
-	ErrCustomUserURLRequired      = errors.New("Custom OAuth user URL is required")
-	ErrParallelLogParsersNotZero  = errors.New("Number of parallel log parsers must be greater than zero")
-	ErrInvalidLogLevel            = errors.New("Invalid log level")
-	ErrUploadsFSDirectoryRequired = errors.New("Filesystem uploads directory is required")
-	ErrUploadsS3BucketRequired    = errors.New("S3 bucket is required")
-	ErrUploadsS3RegionRequired    = errors.New("S3 region is required")
+	ErrJWTSecretRequired             = errors.New("JWT secret is required")
+	ErrBackendURLRequired            = errors.New("Backend URL is required")
+	ErrOTLPEndpointRequired          = errors.New("OTLP endpoint is required when tracing is enabled")
+	ErrMapboxPublicTokenRequired     = errors.New("Mapbox public token is required")
+	ErrMapboxSecretTokenRequired     = errors.New("Mapbox secret token is required")
+	ErrDBHostRequired                = errors.New("Database host is required")
+	ErrDBDatabaseRequired            = errors.New("Database name is required")
+	ErrDatabaseDriverRequired        = errors.New("Database driver is required")
+	ErrNATSURLRequired               = errors.New("NATS URL is required")
+	ErrNATSJetStreamRequiresNATS     = errors.New("NATS must be enabled to use JetStream")
+	ErrGitHubOAuthRequired           = errors.New("GitHub OAuth client ID and secret are required")
+	ErrGoogleOAuthRequired           = errors.New("Google OAuth client ID and secret are required")
+	ErrCustomOAuthRequired           = errors.New("Custom OAuth client ID and secret are required")
+	ErrCustomTokenURLRequired        = errors.New("Custom OAuth token URL is required")
+	ErrCustomUserURLRequired         = errors.New("Custom OAuth user URL is required")
+	ErrParallelLogParsersNotZero     = errors.New("Number of parallel log parsers must be greater than zero")
+	ErrRouteFinalizeAfterNotPositive = errors.New("Route finalize delay must be greater than zero")
+	ErrTelemetryRetentionNotPositive = errors.New("Telemetry retention must be greater than zero")
+	ErrLogRetentionNotPositive       = errors.New("Telemetry log retention must be greater than zero")
+	ErrPrometheusMetricsRequired     = errors.New("Telemetry Prometheus metrics must list the device metrics to export")
+	ErrInvalidLogLevel               = errors.New("Invalid log level")
+	ErrUploadsFSDirectoryRequired    = errors.New("Filesystem uploads directory is required")
+	ErrUploadsS3BucketRequired       = errors.New("S3 bucket is required")
+	ErrUploadsS3RegionRequired       = errors.New("S3 region is required")
 )
 
 func (c *Config) Validate() error {
@@ -345,6 +389,9 @@ func (c *Config) Validate() error {
 	if c.NATS.Enabled && c.NATS.URL == "" {
 		return ErrNATSURLRequired
 	}
+	if c.NATS.JetStream && !c.NATS.Enabled {
+		return ErrNATSJetStreamRequiresNATS
+	}
 	if c.Auth.GitHub.Enabled && (c.Auth.GitHub.ClientID == "" || c.Auth.GitHub.ClientSecret == "") {
 		return ErrGitHubOAuthRequired
 	}
@@ -363,6 +410,18 @@ func (c *Config) Validate() error {
 	if c.ParallelLogParsers == 0 {
 		return ErrParallelLogParsersNotZero
 	}
+	if c.RouteFinalizeAfter <= 0 {
+		return ErrRouteFinalizeAfterNotPositive
+	}
+	if c.Telemetry.Retention <= 0 {
+		return ErrTelemetryRetentionNotPositive
+	}
+	if c.Telemetry.LogRetention <= 0 {
+		return ErrLogRetentionNotPositive
+	}
+	if c.Telemetry.Prometheus && len(c.Telemetry.PrometheusMetrics) == 0 {
+		return ErrPrometheusMetricsRequired
+	}
 	switch c.LogLevel {
 	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
 	default:
@@ -447,6 +506,15 @@ func LoadConfig(cmd *cobra.Command) (*Config, error) {
 	if config.ParallelLogParsers == 0 {
 		config.ParallelLogParsers = DefaultParallelLogParsers
 	}
+	if config.RouteFinalizeAfter == 0 {
+		config.RouteFinalizeAfter = DefaultRouteFinalizeAfter
+	}
+	if config.Telemetry.Retention == 0 {
+		config.Telemetry.Retention = DefaultTelemetryRetention
+	}
+	if config.Telemetry.LogRetention == 0 {
+		config.Telemetry.LogRetention = DefaultTelemetryLogRetention
+	}
 	if config.LogLevel == "" {
 		config.LogLevel = DefaultLogLevel
 	}
@@ -759,6 +827,13 @@ func overrideFlags(config *Config, cmd *cobra.Command) error {
 		}
 	}
 
+	if cmd.Flags().Changed(NATSJetStreamKey) {
+		config.NATS.JetStream, err = cmd.Flags().GetBool(NATSJetStreamKey)
+		if err != nil {
+			return fmt.Errorf("failed to get NATS JetStream: %w", err)
+		}
+	}
+
 	if cmd.Flags().Changed(LogLevelKey) {
 		ll, err := cmd.Flags().GetString(LogLevelKey)
 		if err != nil {
@@ -774,5 +849,40 @@ func overrideFlags(config *Config, cmd *cobra.Command) error {
 		}
 	}
 
+	if cmd.Flags().Changed(RouteFinalizeAfterKey) {
+		config.RouteFinalizeAfter, err = cmd.Flags().GetDuration(RouteFinalizeAfterKey)
+		if err != nil {
+			return fmt.Errorf("failed to get route finalize delay: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TelemetryRetentionKey) {
+		config.Telemetry.Retention, err = cmd.Flags().GetDuration(TelemetryRetentionKey)
+		if err != nil {
+			return fmt.Errorf("failed to get telemetry retention: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TelemetryPrometheusKey) {
+		config.Telemetry.Prometheus, err = cmd.Flags().GetBool(TelemetryPrometheusKey)
+		if err != nil {
+			return fmt.Errorf("failed to get telemetry prometheus: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TelemetryLogRetentionKey) {
+		config.Telemetry.LogRetention, err = cmd.Flags().GetDuration(TelemetryLogRetentionKey)
+		if err != nil {
+			return fmt.Errorf("failed to get telemetry log retention: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TelemetryPrometheusMetricsKey) {
+		config.Telemetry.PrometheusMetrics, err = cmd.Flags().GetStringSlice(TelemetryPrometheusMetricsKey)
+		if err != nil {
+			return fmt.Errorf("failed to get telemetry prometheus metrics: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -116,6 +116,35 @@ func TestMissingMapboxTokens(t *testing.T) {
 	}
 }
 
+func TestJetStreamRequiresNATS(t *testing.T) {
+	t.Parallel()
+	cmd := cmd.NewCommand("testing", "deadbeef")
+	cmd.SetContext(context.Background())
+	err := cmd.ParseFlags(append([]string{"--nats.jetstream", "true"}, requiredFlags...))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	testConfig, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := testConfig.Validate(); !errors.Is(err, config.ErrNATSJetStreamRequiresNATS) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = cmd.ParseFlags([]string{"--nats.enabled", "true", "--nats.url", "nats://localhost:4222"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	testConfig, err = config.LoadConfig(cmd)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := testConfig.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 // Parallel tests are not allowed with t.Setenv
 //
 //nolint:golint,paralleltest
@@ -161,6 +190,7 @@ func TestEnvConfig(t *testing.T) {
 	t.Setenv("NATS__ENABLED", "true")
 	t.Setenv("NATS__URL", "nats://localhost:4444")
 	t.Setenv("NATS__TOKEN", "nats")
+	t.Setenv("NATS__JETSTREAM", "true")
 
 	config, err := config.LoadConfig(cmd)
 	if err != nil {
@@ -295,4 +325,7 @@ func TestEnvConfig(t *testing.T) {
 	if config.NATS.Token != "nats" {
 		t.Errorf("unexpected NATS token: %s", config.NATS.Token)
 	}
+	if !config.NATS.JetStream {
+		t.Error("unexpected NATS JetStream")
+	}
 }
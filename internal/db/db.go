@@ -1,8 +1,11 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	configPkg "github.com/USA-RedDragon/rtz-server/internal/config"
@@ -12,6 +15,7 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func getDialect(config *configPkg.Config) gorm.Dialector {
@@ -82,6 +86,11 @@ func MakeDB(config *configPkg.Config) (db *gorm.DB, err error) {
 		}
 	}
 
+	err = migrateLegacyRoutes(db)
+	if err != nil {
+		return db, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
 	err = db.AutoMigrate(
 		&models.Device{},
 		&models.User{},
@@ -89,7 +98,22 @@ func MakeDB(config *configPkg.Config) (db *gorm.DB, err error) {
 		&models.DeviceShare{},
 		&models.BootLog{},
 		&models.CrashLog{},
-		&models.Route{})
+		&models.Vehicle{},
+		&models.Route{},
+		&models.Segment{},
+		&models.TrackPoint{},
+		&models.SegmentEvent{},
+		&models.Thumbnail{},
+		&models.DriveStats{},
+		&models.PartialUpload{},
+		&models.UploadChunk{},
+		&models.PendingUpload{},
+		&models.UploadRequest{},
+		&models.AthenaOfflineCall{},
+		&models.DeviceMetric{},
+		&models.DeviceLog{},
+		&models.ProxySession{},
+		&models.LogJob{})
 	if err != nil {
 		return db, fmt.Errorf("failed to migrate database: %w", err)
 	}
@@ -106,3 +130,135 @@ func MakeDB(config *configPkg.Config) (db *gorm.DB, err error) {
 
 	return
 }
+
+// legacySegmentColumns are the Postgres-only arrays routes kept their segments
+// in before the segments table, all indexed alike
+var legacySegmentColumns = []string{"segment_numbers", "segment_start_times", "segment_end_times"} //nolint:golint,gochecknoglobals
+
+// migrateLegacyRoutes drops the indexes that limited each device to a single
+// route and moves the segment arrays into the segments table before dropping them
+func migrateLegacyRoutes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Route{}) {
+		return nil
+	}
+	for _, index := range []string{"idx_routes_device_id", "idx_routes_route_id"} {
+		if migrator.HasIndex(&models.Route{}, index) {
+			if err := migrator.DropIndex(&models.Route{}, index); err != nil {
+				return err
+			}
+		}
+	}
+	if !migrator.HasColumn(&models.Route{}, legacySegmentColumns[0]) {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := backfillLegacySegments(tx)
+		if err != nil {
+			return fmt.Errorf("failed to backfill segments: %w", err)
+		}
+		// The sqlite migrator drops columns by recreating the table, which the
+		// segments' foreign keys now pointing at it won't allow
+		for _, column := range legacySegmentColumns {
+			if tx.Migrator().HasColumn(&models.Route{}, column) {
+				err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: "routes"}, clause.Column{Name: column}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// backfillLegacySegments creates a segment for each entry of the legacy arrays.
+// The log parser numbered segments from 1 in the order it processed them, so
+// they're shifted down to match the numbers devices upload them under.
+// Segments that already exist are left alone.
+func backfillLegacySegments(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&models.Segment{}); err != nil {
+		return err
+	}
+	rows, err := tx.Table("routes").
+		Select("id, " + strings.Join(legacySegmentColumns, ", ")).
+		Where(legacySegmentColumns[0] + " IS NOT NULL").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var segments []models.Segment
+	for rows.Next() {
+		var routeID uint
+		var numbers, startTimes, endTimes sql.NullString
+		if err := rows.Scan(&routeID, &numbers, &startTimes, &endTimes); err != nil {
+			return err
+		}
+		routeSegments, err := legacySegments(routeID, numbers.String, startTimes.String, endTimes.String)
+		if err != nil {
+			return fmt.Errorf("route %d: %w", routeID, err)
+		}
+		segments = append(segments, routeSegments...)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(segments, 100).Error
+}
+
+func legacySegments(routeID uint, numbers, startTimes, endTimes string) ([]models.Segment, error) {
+	nums, err := parseLegacyArray(numbers)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := parseLegacyArray(startTimes)
+	if err != nil {
+		return nil, err
+	}
+	ends, err := parseLegacyArray(endTimes)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]models.Segment, 0, len(nums))
+	for i, number := range nums {
+		if number < 1 {
+			continue
+		}
+		segment := models.Segment{RouteID: routeID, Number: int(number - 1)}
+		if i < len(starts) {
+			segment.StartTime = starts[i]
+		}
+		if i < len(ends) {
+			segment.EndTime = ends[i]
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// parseLegacyArray parses the text form of a Postgres bigint array, such as {1,2,3}
+func parseLegacyArray(value string) ([]int64, error) {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}")
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	values := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		if part == "NULL" {
+			// Keep the arrays lined up with each other
+			values = append(values, 0)
+			continue
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array %q: %w", value, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,67 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+)
+
+func TestMigrateLegacyRouteSegments(t *testing.T) {
+	t.Parallel()
+	cfg := testutil.Config(t)
+	database := testutil.OpenDB(t, cfg)
+	device := testutil.NewDevice(t, database)
+	route := models.Route{DeviceID: device.ID, RouteID: "0123456789"}
+	if err := database.Create(&route).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A segment the new code already knows about is left alone
+	if err := database.Create(&models.Segment{RouteID: route.ID, Number: 1, StartTime: 5}).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Seed the arrays routes used to keep their segments in, in the text
+	// form Postgres returns them in
+	for _, column := range []string{"segment_numbers", "segment_start_times", "segment_end_times"} {
+		if err := database.Exec("ALTER TABLE routes ADD COLUMN " + column + " TEXT").Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	err := database.Exec("UPDATE routes SET segment_numbers = ?, segment_start_times = ?, segment_end_times = ? WHERE id = ?",
+		"{1,2,3}", "{100,200,NULL}", "{150,250,350}", route.ID).Error
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sqlDB.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	database = testutil.OpenDB(t, cfg)
+	for _, column := range []string{"segment_numbers", "segment_start_times", "segment_end_times"} {
+		if database.Migrator().HasColumn(&models.Route{}, column) {
+			t.Fatalf("expected %s to be dropped", column)
+		}
+	}
+	segments, err := models.FindSegmentsByRouteID(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []models.Segment{
+		{Number: 0, StartTime: 100, EndTime: 150},
+		{Number: 1, StartTime: 5},
+		{Number: 2, StartTime: 0, EndTime: 350},
+	}
+	if len(segments) != len(expected) {
+		t.Fatalf("expected %d segments, got %+v", len(expected), segments)
+	}
+	for i, segment := range segments {
+		if segment.Number != expected[i].Number || segment.StartTime != expected[i].StartTime || segment.EndTime != expected[i].EndTime {
+			t.Fatalf("segment %d: expected %+v, got %+v", i, expected[i], segment)
+		}
+	}
+}
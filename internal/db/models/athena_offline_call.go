@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AthenaOfflineCall is an RPC call made while its device was offline,
+// waiting to be delivered when the device connects to Athena again
+type AthenaOfflineCall struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"index"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// CallID is the JSON-RPC ID the call is sent to the device with
+	CallID         string `json:"id"`
+	Method         string `json:"method"`
+	JSONRPCVersion string `json:"jsonrpc"`
+	// Params is the JSON encoded params of the call
+	Params string `json:"-"`
+	// Expiry is when the call is dropped if it hasn't been delivered
+	Expiry time.Time `json:"expiry" gorm:"index"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u AthenaOfflineCall) TableName() string {
+	return "athena_offline_calls"
+}
+
+func QueueAthenaOfflineCall(db *gorm.DB, call *AthenaOfflineCall) error {
+	return db.Omit(clause.Associations).Create(call).Error
+}
+
+// ListAthenaOfflineCalls returns a device's unexpired calls in the order they were made
+func ListAthenaOfflineCalls(db *gorm.DB, deviceID uint) ([]AthenaOfflineCall, error) {
+	var calls []AthenaOfflineCall
+	err := db.Where("device_id = ? AND expiry > ?", deviceID, time.Now()).Order("id asc").Find(&calls).Error
+	return calls, err
+}
+
+func DeleteAthenaOfflineCall(db *gorm.DB, id uint) error {
+	return db.Delete(&AthenaOfflineCall{}, id).Error
+}
+
+// DeleteExpiredAthenaOfflineCalls drops a device's calls that expired before being delivered
+func DeleteExpiredAthenaOfflineCalls(db *gorm.DB, deviceID uint) error {
+	return db.Where("device_id = ? AND expiry <= ?", deviceID, time.Now()).Delete(&AthenaOfflineCall{}).Error
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceLog is a line of a device's swaglog, forwarded to Athena with forwardLogs
+type DeviceLog struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"uniqueIndex:idx_device_logs_entry_line;index:idx_device_logs_device_time"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Entry is the name of the swaglog file the line was forwarded from, which
+	// the device sends again if it misses the response
+	Entry string `json:"-" gorm:"uniqueIndex:idx_device_logs_entry_line"`
+	// Line is the line number within the entry
+	Line int       `json:"-" gorm:"uniqueIndex:idx_device_logs_entry_line"`
+	Time time.Time `json:"time" gorm:"index:idx_device_logs_device_time;index"`
+	// Level is the Python logging level, such as INFO, and LevelNum its number
+	Level    string `json:"level"`
+	LevelNum int    `json:"levelnum"`
+	Daemon   string `json:"daemon"`
+	Message  string `json:"msg"`
+	// Raw is the line as the device sent it
+	Raw string `json:"-"`
+}
+
+func (u DeviceLog) TableName() string {
+	return "device_logs"
+}
+
+// CreateDeviceLogs saves log lines, skipping the ones already saved from the same entry
+func CreateDeviceLogs(db *gorm.DB, logs []DeviceLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	return db.Omit(clause.Associations).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(logs, 500).Error
+}
+
+// DeviceLogFilter narrows down the log lines FindDeviceLogs returns
+type DeviceLogFilter struct {
+	Start time.Time
+	End   time.Time
+	// MinLevelNum drops lines less severe than it
+	MinLevelNum int
+	// Contains is matched case insensitively against the message
+	Contains string
+	Limit    int
+}
+
+// FindDeviceLogs returns a device's log lines matching filter, oldest first
+func FindDeviceLogs(db *gorm.DB, deviceID uint, filter DeviceLogFilter) ([]DeviceLog, error) {
+	var logs []DeviceLog
+	query := db.Where("device_id = ? AND time >= ? AND time <= ?", deviceID, filter.Start, filter.End)
+	if filter.MinLevelNum > 0 {
+		query = query.Where("level_num >= ?", filter.MinLevelNum)
+	}
+	if filter.Contains != "" {
+		pattern := "%" + escapeLike(strings.ToLower(filter.Contains)) + "%"
+		query = query.Where("LOWER(message) LIKE ? ESCAPE '!'", pattern)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err := query.Order("time asc, id asc").Find(&logs).Error
+	return logs, err
+}
+
+// escapeLike escapes the LIKE wildcards in s with !, which unlike a backslash
+// needs no escaping itself in any of the supported databases' string literals
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// DeleteDeviceLogsBefore drops every device's log lines from before the given time
+func DeleteDeviceLogsBefore(db *gorm.DB, before time.Time) (int64, error) {
+	res := db.Where("time < ?", before).Delete(&DeviceLog{})
+	return res.RowsAffected, res.Error
+}
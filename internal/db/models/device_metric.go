@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceMetric is one field of a metric a device reported with storeStats
+type DeviceMetric struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"index:idx_device_metrics_device_name_time"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Name is the measurement, such as gauge.cpu_temp or sample.modeld_time
+	Name string `json:"name" gorm:"index:idx_device_metrics_device_name_time"`
+	// Field is value for gauges, or one of count, min, max, mean, p5, p50 and p95 for samples
+	Field string    `json:"field"`
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time" gorm:"index:idx_device_metrics_device_name_time;index"`
+	// Tags is the JSON encoded tags the metric was reported with, such as the openpilot version
+	Tags string `json:"-"`
+}
+
+func (u DeviceMetric) TableName() string {
+	return "device_metrics"
+}
+
+func CreateDeviceMetrics(db *gorm.DB, metrics []DeviceMetric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	return db.Omit(clause.Associations).CreateInBatches(metrics, 500).Error
+}
+
+// FindDeviceMetrics returns up to limit of a device's metrics called name
+// from the time range, oldest first. A limit of 0 or less returns them all.
+func FindDeviceMetrics(db *gorm.DB, deviceID uint, name string, start, end time.Time, limit int) ([]DeviceMetric, error) {
+	var metrics []DeviceMetric
+	query := db.Where("device_id = ? AND name = ? AND time >= ? AND time <= ?", deviceID, name, start, end)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Order("time asc, id asc").Find(&metrics).Error
+	return metrics, err
+}
+
+// ListDeviceMetricNames returns the names of the metrics a device has reported
+func ListDeviceMetricNames(db *gorm.DB, deviceID uint) ([]string, error) {
+	var names []string
+	err := db.Model(&DeviceMetric{}).
+		Distinct("name").
+		Where("device_id = ?", deviceID).
+		Order("name asc").
+		Pluck("name", &names).Error
+	return names, err
+}
+
+// DeleteDeviceMetricsBefore drops every device's metrics from before the given time
+func DeleteDeviceMetricsBefore(db *gorm.DB, before time.Time) (int64, error) {
+	res := db.Where("time < ?", before).Delete(&DeviceMetric{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DriveStats rolls up a device's driving for one UTC day, so stats can be
+// summed from a row per day instead of from every segment
+type DriveStats struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"uniqueIndex:idx_drive_stats_device_day"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Day is midnight UTC of the day the stats are for
+	Day time.Time `json:"day" gorm:"uniqueIndex:idx_drive_stats_device_day"`
+	// Routes counts the routes that started during the day
+	Routes int64 `json:"routes"`
+	// Distance is in miles, counted for the day each segment started on, as is the time
+	Distance      float64 `json:"distance"`
+	DriveMillis   int64   `json:"drive_millis"`
+	EngagedMillis int64   `json:"engaged_millis"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u DriveStats) TableName() string {
+	return "drive_stats"
+}
+
+// DriveStatsDays returns the distinct days that wall clock times in nanoseconds
+// fall on, skipping unknown times
+func DriveStatsDays(wallTimesNanos []int64) []time.Time {
+	var days []time.Time
+	seen := map[int64]bool{}
+	for _, wallTimeNanos := range wallTimesNanos {
+		if wallTimeNanos <= 0 {
+			continue
+		}
+		day := time.Unix(0, wallTimeNanos).UTC().Truncate(24 * time.Hour)
+		if !seen[day.Unix()] {
+			seen[day.Unix()] = true
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// RefreshDriveStats recomputes a device's stats for each of days from its routes and segments
+func RefreshDriveStats(db *gorm.DB, deviceID uint, days ...time.Time) error {
+	for _, day := range days {
+		day = day.UTC().Truncate(24 * time.Hour)
+		next := day.Add(24 * time.Hour)
+		var segments struct {
+			Distance      float64
+			DriveNanos    int64
+			EngagedMillis int64
+		}
+		err := db.Model(&Segment{}).
+			Select("COALESCE(SUM(segments.length), 0) AS distance, "+
+				"COALESCE(SUM(CASE WHEN segments.end_time > segments.start_time THEN segments.end_time - segments.start_time ELSE 0 END), 0) AS drive_nanos, "+
+				"COALESCE(SUM(segments.engaged_millis), 0) AS engaged_millis").
+			Joins("JOIN routes ON routes.id = segments.route_id AND routes.deleted_at IS NULL").
+			Where("routes.device_id = ? AND segments.start_time >= ? AND segments.start_time < ?", deviceID, day.UnixNano(), next.UnixNano()).
+			Scan(&segments).Error
+		if err != nil {
+			return err
+		}
+		stats := DriveStats{
+			DeviceID:      deviceID,
+			Day:           day,
+			Distance:      segments.Distance,
+			DriveMillis:   segments.DriveNanos / int64(time.Millisecond),
+			EngagedMillis: segments.EngagedMillis,
+		}
+
+		err = db.Model(&Route{}).
+			Where("device_id = ? AND start_time >= ? AND start_time < ?", deviceID, day, next).
+			Count(&stats.Routes).Error
+		if err != nil {
+			return err
+		}
+
+		err = db.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "device_id"}, {Name: "day"}},
+			DoUpdates: clause.AssignmentColumns([]string{"routes", "distance", "drive_millis", "engaged_millis", "updated_at"}),
+		}).Create(&stats).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackfillDriveStats computes the stats of every device that has driven but
+// has none yet, such as those whose routes were parsed before stats were kept
+func BackfillDriveStats(db *gorm.DB) error {
+	var deviceIDs []uint
+	err := db.Model(&Route{}).
+		Distinct("device_id").
+		Where("start_time > ?", time.Unix(0, 0)).
+		Where("NOT EXISTS (?)", db.Model(&DriveStats{}).Select("1").Where("drive_stats.device_id = routes.device_id")).
+		Pluck("device_id", &deviceIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, deviceID := range deviceIDs {
+		var startTimes []int64
+		err := db.Model(&Segment{}).
+			Joins("JOIN routes ON routes.id = segments.route_id AND routes.deleted_at IS NULL").
+			Where("routes.device_id = ? AND segments.start_time > 0", deviceID).
+			Pluck("segments.start_time", &startTimes).Error
+		if err != nil {
+			return err
+		}
+		err = RefreshDriveStats(db, deviceID, DriveStatsDays(startTimes)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DriveStatsTotal is the sum of a device's stats over a number of days
+type DriveStatsTotal struct {
+	Routes        int64
+	Distance      float64
+	DriveMillis   int64
+	EngagedMillis int64
+}
+
+// SumDriveStats adds up a device's stats for the days from the one since falls on
+func SumDriveStats(db *gorm.DB, deviceID uint, since time.Time) (DriveStatsTotal, error) {
+	var total DriveStatsTotal
+	err := db.Model(&DriveStats{}).
+		Select("COALESCE(SUM(routes), 0) AS routes, COALESCE(SUM(distance), 0) AS distance, "+
+			"COALESCE(SUM(drive_millis), 0) AS drive_millis, COALESCE(SUM(engaged_millis), 0) AS engaged_millis").
+		Where("device_id = ? AND day >= ?", deviceID, since.UTC().Truncate(24*time.Hour)).
+		Scan(&total).Error
+	return total, err
+}
@@ -0,0 +1,286 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/mattn/go-nulltype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LogJobState string
+
+const (
+	LogJobStatePending LogJobState = "pending"
+	LogJobStateRunning LogJobState = "running"
+	LogJobStateFailed  LogJobState = "failed"
+	LogJobStateDone    LogJobState = "done"
+)
+
+// LogJob is an uploaded log waiting to be, or that has been, parsed
+type LogJob struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" binding:"required" gorm:"uniqueIndex:idx_log_jobs_device_path"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Path     string `json:"path" binding:"required" gorm:"uniqueIndex:idx_log_jobs_device_path"`
+	// Motonic, Route and Segment are the parts of the segment directory the log was uploaded to
+	Motonic string      `json:"motonic"`
+	Route   string      `json:"route"`
+	Segment string      `json:"segment"`
+	State   LogJobState `json:"state" gorm:"index"`
+	// Attempts is the number of times parsing has been started
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	// RunAfter holds a job back until its retry backoff has passed
+	RunAfter   time.Time         `json:"run_after" gorm:"index"`
+	StartedAt  nulltype.NullTime `json:"started_at"`
+	FinishedAt nulltype.NullTime `json:"finished_at"`
+	// HeartbeatAt is refreshed while the job runs, so jobs left running by an
+	// instance that died can be told apart from ones still being parsed
+	HeartbeatAt nulltype.NullTime `json:"heartbeat_at" gorm:"index"`
+	// Requeue is set when the log is uploaded again while the job is running.
+	// The job goes back to pending once the run finishes instead of being
+	// taken from the instance running it.
+	Requeue bool `json:"requeue" gorm:"not null;default:false"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u LogJob) TableName() string {
+	return "log_jobs"
+}
+
+// EnqueueLogJob queues a log for parsing. A log that's uploaded again is
+// queued again from scratch, unless its job is running, in which case it's
+// marked to be requeued when the run finishes.
+func EnqueueLogJob(db *gorm.DB, job *LogJob) error {
+	for {
+		now := time.Now()
+		job.UpdatedAt = now
+		// A running job is left to its instance
+		res := db.Model(&LogJob{}).
+			Where("device_id = ? AND path = ? AND state = ?", job.DeviceID, job.Path, LogJobStateRunning).
+			Updates(map[string]any{
+				"motonic":    job.Motonic,
+				"route":      job.Route,
+				"segment":    job.Segment,
+				"requeue":    true,
+				"updated_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			job.State = LogJobStateRunning
+			job.Requeue = true
+			return findLogJobID(db, job)
+		}
+
+		job.State = LogJobStatePending
+		job.Attempts = 0
+		job.LastError = ""
+		job.RunAfter = now
+		job.Requeue = false
+		res = db.Model(&LogJob{}).
+			Where("device_id = ? AND path = ? AND state <> ?", job.DeviceID, job.Path, LogJobStateRunning).
+			Updates(map[string]any{
+				"motonic":     job.Motonic,
+				"route":       job.Route,
+				"segment":     job.Segment,
+				"state":       job.State,
+				"attempts":    job.Attempts,
+				"last_error":  job.LastError,
+				"run_after":   job.RunAfter,
+				"started_at":  nil,
+				"finished_at": nil,
+				"requeue":     false,
+				"updated_at":  now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			return findLogJobID(db, job)
+		}
+
+		res = db.Clauses(clause.OnConflict{DoNothing: true}).Create(job)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			return findLogJobID(db, job)
+		}
+		// The job was created or claimed in between, look at it again
+	}
+}
+
+// findLogJobID fills in the ID of a job by its device and path. Not every
+// database returns the ID of a row that wasn't inserted.
+func findLogJobID(db *gorm.DB, job *LogJob) error {
+	var stored LogJob
+	err := db.Select("id").Where("device_id = ? AND path = ?", job.DeviceID, job.Path).First(&stored).Error
+	job.ID = stored.ID
+	return err
+}
+
+// ListRunnableLogJobs returns pending jobs that are due, skipping devices
+// that already have a job running
+func ListRunnableLogJobs(db *gorm.DB, limit int) ([]LogJob, error) {
+	var jobs []LogJob
+	running := db.Model(&LogJob{}).Select("device_id").Where("state = ?", LogJobStateRunning)
+	err := db.Preload("Device").
+		Where("state = ? AND run_after <= ?", LogJobStatePending, time.Now()).
+		Where("device_id NOT IN (?)", running).
+		Order("run_after asc").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// ClaimLogJob marks a job as running, provided it's still in the state it was
+// read in. It reports false if the job was claimed by someone else first.
+func ClaimLogJob(db *gorm.DB, job *LogJob) (bool, error) {
+	now := time.Now()
+	res := db.Model(&LogJob{}).
+		Where("id = ? AND state = ?", job.ID, job.State).
+		Updates(map[string]any{
+			"state":        LogJobStateRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"started_at":   now,
+			"heartbeat_at": now,
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return false, nil
+	}
+	job.State = LogJobStateRunning
+	job.Attempts++
+	job.StartedAt = nulltype.NullTimeOf(now)
+	job.HeartbeatAt = nulltype.NullTimeOf(now)
+	return true, nil
+}
+
+// HeartbeatLogJob notes that a running job is still being parsed
+func HeartbeatLogJob(db *gorm.DB, id uint) error {
+	return db.Model(&LogJob{}).Where("id = ? AND state = ?", id, LogJobStateRunning).Update("heartbeat_at", time.Now()).Error
+}
+
+// CompleteLogJob marks a running job done. A job requeued by a new upload
+// while it was running goes back to pending so the new file gets parsed too.
+func CompleteLogJob(db *gorm.DB, id uint) error {
+	return finishLogJob(db, id, map[string]any{
+		"state":       LogJobStateDone,
+		"last_error":  "",
+		"finished_at": time.Now(),
+	})
+}
+
+// FailLogJob records a failed attempt, either scheduling a retry after
+// backoff or, once retry is false, giving up on the job. A job requeued by
+// a new upload while it was running starts over instead.
+func FailLogJob(db *gorm.DB, id uint, jobErr error, retry bool, backoff time.Duration) error {
+	updates := map[string]any{
+		"last_error": jobErr.Error(),
+	}
+	if retry {
+		updates["state"] = LogJobStatePending
+		updates["run_after"] = time.Now().Add(backoff)
+	} else {
+		updates["state"] = LogJobStateFailed
+		updates["finished_at"] = time.Now()
+	}
+	return finishLogJob(db, id, updates)
+}
+
+// finishLogJob applies updates to a running job that wasn't requeued, or
+// puts a requeued one back to pending with a fresh set of attempts
+func finishLogJob(db *gorm.DB, id uint, updates map[string]any) error {
+	res := db.Model(&LogJob{}).Where("id = ? AND state = ? AND requeue = ?", id, LogJobStateRunning, false).Updates(updates)
+	if res.Error != nil || res.RowsAffected == 1 {
+		return res.Error
+	}
+	// Requeue is only ever set on a running job, so it was set in between
+	return db.Model(&LogJob{}).Where("id = ? AND state = ? AND requeue = ?", id, LogJobStateRunning, true).Updates(map[string]any{
+		"state":       LogJobStatePending,
+		"attempts":    0,
+		"last_error":  "",
+		"run_after":   time.Now(),
+		"finished_at": nil,
+		"requeue":     false,
+	}).Error
+}
+
+// RecoverLogJobs puts running jobs that haven't had a heartbeat since before
+// back in the queue. Their instance stopped without finishing them.
+func RecoverLogJobs(db *gorm.DB, before time.Time) (int64, error) {
+	res := db.Model(&LogJob{}).
+		Where("state = ?", LogJobStateRunning).
+		Where("heartbeat_at < ? OR heartbeat_at IS NULL", before).
+		Updates(map[string]any{
+			"state":     LogJobStatePending,
+			"run_after": time.Now(),
+			// The job is parsed again from the start anyway
+			"requeue": false,
+		})
+	return res.RowsAffected, res.Error
+}
+
+// RetryLogJob queues a failed job again with a fresh set of attempts
+func RetryLogJob(db *gorm.DB, id uint) (bool, error) {
+	res := db.Model(&LogJob{}).
+		Where("id = ? AND state = ?", id, LogJobStateFailed).
+		Updates(map[string]any{
+			"state":       LogJobStatePending,
+			"attempts":    0,
+			"run_after":   time.Now(),
+			"finished_at": nil,
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func FindLogJobByID(db *gorm.DB, id uint) (LogJob, error) {
+	var job LogJob
+	err := db.Preload("Device").First(&job, id).Error
+	return job, err
+}
+
+// ListLogJobs returns the most recently updated jobs, optionally only those in state
+func ListLogJobs(db *gorm.DB, state LogJobState, limit int) ([]LogJob, error) {
+	var jobs []LogJob
+	query := db.Preload("Device").Order("updated_at desc").Limit(limit)
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+func CountLogJobsByState(db *gorm.DB, state LogJobState) (int64, error) {
+	var count int64
+	err := db.Model(&LogJob{}).Where("state = ?", state).Count(&count).Error
+	return count, err
+}
+
+// CountLogJobsByIDs counts the jobs with the given IDs in each state
+func CountLogJobsByIDs(db *gorm.DB, ids []uint) (map[LogJobState]int64, error) {
+	counts := map[LogJobState]int64{}
+	// Keep the IN lists within every database's limit on bound parameters
+	for batch := range slices.Chunk(ids, 500) {
+		var rows []struct {
+			State LogJobState
+			Count int64
+		}
+		err := db.Model(&LogJob{}).Select("state, COUNT(*) AS count").Where("id IN ?", batch).Group("state").Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.State] += row.Count
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/mattn/go-nulltype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProxySession is the audit record of a user forwarding a port on a device
+// through startLocalProxy
+type ProxySession struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"index"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserID   uint   `json:"user_id" gorm:"index"`
+	User     User   `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Port is the port forwarded on the device
+	Port       int    `json:"port"`
+	ClientAddr string `json:"client_addr"`
+	// ConnectedAt is when the device connected back, unset if it never did
+	ConnectedAt nulltype.NullTime `json:"connected_at"`
+	EndedAt     nulltype.NullTime `json:"ended_at"`
+	// BytesToDevice and BytesFromDevice are how much was forwarded each way
+	BytesToDevice   int64  `json:"bytes_to_device"`
+	BytesFromDevice int64  `json:"bytes_from_device"`
+	Error           string `json:"error"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (u ProxySession) TableName() string {
+	return "proxy_sessions"
+}
+
+func CreateProxySession(db *gorm.DB, session *ProxySession) error {
+	return db.Omit(clause.Associations).Create(session).Error
+}
+
+func UpdateProxySession(db *gorm.DB, session *ProxySession) error {
+	return db.Omit(clause.Associations).Save(session).Error
+}
+
+// ListProxySessions returns a device's proxy sessions, newest first
+func ListProxySessions(db *gorm.DB, deviceID uint, limit int) ([]ProxySession, error) {
+	var sessions []ProxySession
+	err := db.Where("device_id = ?", deviceID).Order("id desc").Limit(limit).Find(&sessions).Error
+	return sessions, err
+}
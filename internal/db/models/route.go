@@ -1,19 +1,21 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/mattn/go-nulltype"
 	"gorm.io/gorm"
 )
 
 type Route struct {
 	ID                      uint      `json:"-" gorm:"primaryKey" binding:"required"`
-	DeviceID                uint      `json:"device_id" binding:"required" gorm:"uniqueIndex,OnUpdate:CASCADE,OnDelete:SET NULL"`
+	DeviceID                uint      `json:"device_id" binding:"required" gorm:"uniqueIndex:idx_routes_device_route"`
 	FirstClockWallTimeNanos uint64    `json:"-" binding:"required" gorm:"type:numeric"`
 	FirstClockLogMonoTime   uint64    `json:"-" binding:"required" gorm:"type:numeric"`
 	AllSegmentsProcessed    bool      `json:"-"`
-	RouteID                 string    `json:"id" binding:"required" gorm:"uniqueIndex"`
+	RouteID                 string    `json:"id" binding:"required" gorm:"uniqueIndex:idx_routes_device_route;size:64"`
 	EndLat                  float64   `json:"end_lat"`
 	EndLng                  float64   `json:"end_lng"`
 	EndTime                 time.Time `json:"end_time"`
@@ -32,9 +34,13 @@ type Route struct {
 	StartTime               time.Time `json:"start_time"`
 	URL                     string    `json:"url"`
 	Version                 string    `json:"version" binding:"required"`
-	SegmentStartTimes       []int64   `json:"segment_start_times" gorm:"type:bigint[]"`
-	SegmentEndTimes         []int64   `json:"segment_end_times" gorm:"type:bigint[]"`
-	SegmentNumbers          []int64   `json:"segment_numbers" gorm:"type:bigint[]"`
+	// FinalizedAt is set when the route was closed by the finalizer because
+	// its final segment never arrived
+	FinalizedAt nulltype.NullTime `json:"-"`
+	// VehicleID is the car the route was driven in, once its carParams have been parsed
+	VehicleID *uint     `json:"-" gorm:"index"`
+	Vehicle   *Vehicle  `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Segments  []Segment `json:"-"`
 
 	CreatedAt time.Time      `json:"create_time"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -48,6 +54,64 @@ func (u Route) GetWallTimeFromBootTime(bootTime uint64) uint64 {
 	return u.FirstClockWallTimeNanos + bootTime - u.FirstClockLogMonoTime
 }
 
+// Summarize recomputes the route's stats from its segments, so they don't
+// depend on the order segments were processed in or how often
+func (u *Route) Summarize(segments []Segment) {
+	u.Length = 0
+	u.StartLat, u.StartLng = 0, 0
+	u.EndLat, u.EndLng = 0, 0
+	u.StartTime, u.EndTime = time.Time{}, time.Time{}
+	u.AllSegmentsProcessed = false
+
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return a.Number - b.Number
+	})
+
+	processed := 0
+	endOfRoute := -1
+	var start, end int64
+	for _, segment := range segments {
+		if !segment.LogProcessed() {
+			continue
+		}
+		processed++
+		u.Length += segment.Length
+		if u.StartLat == 0 && u.StartLng == 0 {
+			u.StartLat, u.StartLng = segment.StartLat, segment.StartLng
+		}
+		if segment.EndLat != 0 || segment.EndLng != 0 {
+			u.EndLat, u.EndLng = segment.EndLat, segment.EndLng
+		}
+		if segment.StartTime > 0 && (start == 0 || segment.StartTime < start) {
+			start = segment.StartTime
+		}
+		end = max(end, segment.EndTime)
+		if segment.EndOfRoute {
+			endOfRoute = segment.Number
+		}
+	}
+
+	if start > 0 {
+		u.StartTime = time.Unix(0, start)
+	}
+	// The route's end is only known once its last segment has been processed,
+	// or once the finalizer has given up on the last segment arriving
+	switch {
+	case endOfRoute >= 0:
+		// The last segment arrived after all, so the route is no longer finalized
+		u.FinalizedAt = nulltype.NullTime{}
+		u.EndTime = time.Unix(0, end)
+		u.AllSegmentsProcessed = processed == endOfRoute+1
+	case u.FinalizedAt.Valid() && processed > 0:
+		if end > 0 {
+			u.EndTime = time.Unix(0, end)
+		}
+		u.AllSegmentsProcessed = processed == len(segments) && processed == segments[len(segments)-1].Number+1
+	default:
+		u.EndLat, u.EndLng = 0, 0
+	}
+}
+
 func FindRoutesByDeviceID(db *gorm.DB, deviceID uint) ([]Route, error) {
 	var routes []Route
 	err := db.Where(&Route{DeviceID: deviceID}).Find(&routes).Error
@@ -60,14 +124,136 @@ func FindRouteForSegment(db *gorm.DB, deviceID uint, routeInfo v1dot4.RouteInfo)
 	return route, err
 }
 
-func CountRoutesSince(db *gorm.DB, deviceID uint, since time.Time) (int64, error) {
-	var count int64
-	err := db.Model(&Route{}).Where("device_id = ? AND start_time > ?", deviceID, since).Count(&count).Error
-	return count, err
+func FindRouteByDeviceIDAndID(db *gorm.DB, deviceID uint, id uint) (Route, error) {
+	var route Route
+	err := db.Where("device_id = ? AND id = ?", deviceID, id).First(&route).Error
+	return route, err
+}
+
+func FindRouteByDeviceIDAndRouteID(db *gorm.DB, deviceID uint, routeID string) (Route, error) {
+	var route Route
+	err := db.Order("init_log_mono_time desc").Where("device_id = ? AND route_id = ?", deviceID, routeID).First(&route).Error
+	return route, err
 }
 
 func FindRoutesByDeviceIDAndTimeRange(db *gorm.DB, deviceID uint, start, end time.Time, limit int) ([]Route, error) {
 	var routes []Route
-	err := db.Where("device_id = ? AND start_time >= ? AND end_time <= ?", deviceID, start, end).Limit(limit).Find(&routes).Error
+	err := db.Preload("Segments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("number asc")
+	}).Preload("Vehicle").Where("device_id = ? AND start_time >= ? AND end_time <= ?", deviceID, start, end).Limit(limit).Find(&routes).Error
 	return routes, err
 }
+
+// ResetRoute forgets everything parsed from a route's logs so they can be parsed
+// again from scratch. What was uploaded and how the route is shared are kept.
+func ResetRoute(db *gorm.DB, routeID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var route Route
+		err := tx.First(&route, routeID).Error
+		if err != nil {
+			return err
+		}
+		segments, err := FindSegmentsByRouteID(tx, routeID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Route{}).Where("id = ?", routeID).Updates(map[string]any{
+			"first_clock_wall_time_nanos": 0,
+			"first_clock_log_mono_time":   0,
+			"all_segments_processed":      false,
+			"end_lat":                     0,
+			"end_lng":                     0,
+			"end_time":                    time.Time{},
+			"git_branch":                  "",
+			"git_commit":                  "",
+			"git_dirty":                   false,
+			"git_remote":                  "",
+			"init_log_mono_time":          0,
+			"length":                      0,
+			"platform":                    "",
+			"radar":                       false,
+			"start_lat":                   0,
+			"start_lng":                   0,
+			"start_time":                  time.Time{},
+			"version":                     "",
+			"vehicle_id":                  nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		segmentIDs := tx.Model(&Segment{}).Select("id").Where("route_id = ?", routeID)
+		for _, derived := range []any{&TrackPoint{}, &SegmentEvent{}, &Thumbnail{}} {
+			err = tx.Where("segment_id IN (?)", segmentIDs).Delete(derived).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&Segment{}).Where("route_id = ?", routeID).Updates(map[string]any{
+			"start_time":        0,
+			"end_time":          0,
+			"length":            0,
+			"start_lat":         0,
+			"start_lng":         0,
+			"end_lat":           0,
+			"end_lng":           0,
+			"end_of_route":      false,
+			"position_source":   "",
+			"engaged_millis":    0,
+			"qlog_processed_at": nil,
+			"rlog_processed_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// The route no longer counts towards the days it was driven on until it's parsed again
+		return RefreshDriveStats(tx, route.DeviceID, routeDays(route, segments)...)
+	})
+}
+
+// routeDays returns the days a route's stats are counted on
+func routeDays(route Route, segments []Segment) []time.Time {
+	wallTimes := make([]int64, 0, len(segments)+1)
+	if route.StartTime.After(time.Unix(0, 0)) {
+		wallTimes = append(wallTimes, route.StartTime.UnixNano())
+	}
+	for _, segment := range segments {
+		wallTimes = append(wallTimes, segment.StartTime)
+	}
+	return DriveStatsDays(wallTimes)
+}
+
+// FindStaleRoutes returns the open routes that have had a segment processed
+// but no segment uploaded or processed since before
+func FindStaleRoutes(db *gorm.DB, before time.Time) ([]Route, error) {
+	var routes []Route
+	err := db.Where("finalized_at IS NULL").
+		Where("EXISTS (?)", db.Model(&Segment{}).Select("1").Where("segments.route_id = routes.id AND (qlog_processed_at IS NOT NULL OR rlog_processed_at IS NOT NULL)")).
+		Where("NOT EXISTS (?)", db.Model(&Segment{}).Select("1").Where("segments.route_id = routes.id AND (end_of_route = ? OR updated_at >= ?)", true, before)).
+		Find(&routes).Error
+	return routes, err
+}
+
+// FinalizeRoute closes a route whose final segment never arrived, ending it
+// with the latest segment that did
+func FinalizeRoute(db *gorm.DB, route *Route) error {
+	segments, err := FindSegmentsByRouteID(db, route.ID)
+	if err != nil {
+		return err
+	}
+	days := routeDays(*route, segments)
+	route.FinalizedAt = nulltype.NullTimeOf(time.Now())
+	route.Summarize(segments)
+	// Only the summary is written, so nothing the log parser saved in the meantime is lost
+	err = db.Model(route).
+		Select("finalized_at", "all_segments_processed", "length", "start_lat", "start_lng", "end_lat", "end_lng", "start_time", "end_time").
+		Updates(route).Error
+	if err != nil {
+		return err
+	}
+	// The route's start may have moved to another day
+	return RefreshDriveStats(db, route.DeviceID, append(days, routeDays(*route, nil)...)...)
+}
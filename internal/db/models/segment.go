@@ -0,0 +1,202 @@
+package models
+
+import (
+	"time"
+
+	"github.com/mattn/go-nulltype"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SegmentFile is one of the files a device uploads for each segment
+type SegmentFile string
+
+const (
+	SegmentFileQLog    SegmentFile = "qlog"
+	SegmentFileRLog    SegmentFile = "rlog"
+	SegmentFileQCamera SegmentFile = "qcamera"
+	SegmentFileFCamera SegmentFile = "fcamera"
+	SegmentFileDCamera SegmentFile = "dcamera"
+	SegmentFileECamera SegmentFile = "ecamera"
+)
+
+// Parsed reports whether the file is a log the log parser processes
+func (f SegmentFile) Parsed() bool {
+	return f == SegmentFileQLog || f == SegmentFileRLog
+}
+
+// Segment is one segment of a route, tracking which of its files have
+// been uploaded and processed. Cameras need no processing, so they count
+// as processed as soon as they're uploaded.
+type Segment struct {
+	ID      uint `json:"-" gorm:"primaryKey"`
+	RouteID uint `json:"-" binding:"required" gorm:"uniqueIndex:idx_segments_route_number;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Number  int  `json:"number" binding:"required" gorm:"uniqueIndex:idx_segments_route_number"`
+	// The rest of the segment's stats are known once one of its logs has been processed.
+	// StartTime and EndTime are wall clock times in nanoseconds and Length is in miles.
+	StartTime  int64   `json:"start_time"`
+	EndTime    int64   `json:"end_time"`
+	Length     float64 `json:"length"`
+	StartLat   float64 `json:"start_lat"`
+	StartLng   float64 `json:"start_lng"`
+	EndLat     float64 `json:"end_lat"`
+	EndLng     float64 `json:"end_lng"`
+	EndOfRoute bool    `json:"end_of_route"`
+	// PositionSource is the log event the segment's positions and length were read from
+	PositionSource string `json:"position_source"`
+	// EngagedMillis is how long openpilot was engaged during the segment
+	EngagedMillis int64 `json:"engaged_millis"`
+
+	QLogSize          int64             `json:"qlog_size" gorm:"column:qlog_size"`
+	QLogUploadedAt    nulltype.NullTime `json:"qlog_uploaded_at" gorm:"column:qlog_uploaded_at"`
+	QLogProcessedAt   nulltype.NullTime `json:"qlog_processed_at" gorm:"column:qlog_processed_at"`
+	RLogSize          int64             `json:"rlog_size" gorm:"column:rlog_size"`
+	RLogUploadedAt    nulltype.NullTime `json:"rlog_uploaded_at" gorm:"column:rlog_uploaded_at"`
+	RLogProcessedAt   nulltype.NullTime `json:"rlog_processed_at" gorm:"column:rlog_processed_at"`
+	QCameraSize       int64             `json:"qcamera_size" gorm:"column:qcamera_size"`
+	QCameraUploadedAt nulltype.NullTime `json:"qcamera_uploaded_at" gorm:"column:qcamera_uploaded_at"`
+	FCameraSize       int64             `json:"fcamera_size" gorm:"column:fcamera_size"`
+	FCameraUploadedAt nulltype.NullTime `json:"fcamera_uploaded_at" gorm:"column:fcamera_uploaded_at"`
+	DCameraSize       int64             `json:"dcamera_size" gorm:"column:dcamera_size"`
+	DCameraUploadedAt nulltype.NullTime `json:"dcamera_uploaded_at" gorm:"column:dcamera_uploaded_at"`
+	ECameraSize       int64             `json:"ecamera_size" gorm:"column:ecamera_size"`
+	ECameraUploadedAt nulltype.NullTime `json:"ecamera_uploaded_at" gorm:"column:ecamera_uploaded_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u Segment) TableName() string {
+	return "segments"
+}
+
+// Uploaded reports whether file has been uploaded for this segment
+func (u Segment) Uploaded(file SegmentFile) bool {
+	switch file {
+	case SegmentFileQLog:
+		return u.QLogUploadedAt.Valid()
+	case SegmentFileRLog:
+		return u.RLogUploadedAt.Valid()
+	case SegmentFileQCamera:
+		return u.QCameraUploadedAt.Valid()
+	case SegmentFileFCamera:
+		return u.FCameraUploadedAt.Valid()
+	case SegmentFileDCamera:
+		return u.DCameraUploadedAt.Valid()
+	case SegmentFileECamera:
+		return u.ECameraUploadedAt.Valid()
+	}
+	return false
+}
+
+// LogProcessed reports whether either of the segment's logs has been parsed
+func (u Segment) LogProcessed() bool {
+	return u.QLogProcessedAt.Valid() || u.RLogProcessedAt.Valid()
+}
+
+// Processed reports whether file has been uploaded and, for logs, parsed
+func (u Segment) Processed(file SegmentFile) bool {
+	switch file {
+	case SegmentFileQLog:
+		return u.QLogProcessedAt.Valid()
+	case SegmentFileRLog:
+		return u.RLogProcessedAt.Valid()
+	}
+	return u.Uploaded(file)
+}
+
+// findOrCreateRoute returns a device's route, creating an empty one to be
+// filled in by the log parser if it doesn't exist yet
+func findOrCreateRoute(db *gorm.DB, deviceID uint, routeID string) (Route, error) {
+	route := Route{
+		DeviceID: deviceID,
+		RouteID:  routeID,
+	}
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&route).Error
+	if err != nil {
+		return route, err
+	}
+	err = db.Where("device_id = ? AND route_id = ?", deviceID, routeID).First(&route).Error
+	return route, err
+}
+
+// findOrCreateSegment returns a segment of a route, creating it if it doesn't exist yet
+func findOrCreateSegment(db *gorm.DB, routeID uint, number int) (Segment, error) {
+	segment := Segment{
+		RouteID: routeID,
+		Number:  number,
+	}
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&segment).Error
+	if err != nil {
+		return segment, err
+	}
+	err = db.Where("route_id = ? AND number = ?", routeID, number).First(&segment).Error
+	return segment, err
+}
+
+// RecordSegmentUpload notes that a file of a segment has been uploaded,
+// creating the route and segment if this is the first we've heard of them
+func RecordSegmentUpload(db *gorm.DB, deviceID uint, routeID string, number int, file SegmentFile, size int64) error {
+	route, err := findOrCreateRoute(db, deviceID, routeID)
+	if err != nil {
+		return err
+	}
+	segment, err := findOrCreateSegment(db, route.ID, number)
+	if err != nil {
+		return err
+	}
+
+	column := string(file)
+	updates := map[string]any{
+		column + "_size":        size,
+		column + "_uploaded_at": time.Now(),
+	}
+	if file.Parsed() {
+		// A re-uploaded log has to be parsed again
+		updates[column+"_processed_at"] = nil
+	}
+	return db.Model(&segment).Updates(updates).Error
+}
+
+// RecordSegmentProcessed notes that a log of a segment has been parsed, replacing
+// whatever was learned from parsing it before. The segment is identified by the
+// RouteID and Number of stats. rlogs are a superset of qlogs, so once a segment's
+// rlog has been parsed, its qlog is only marked processed and false is returned.
+func RecordSegmentProcessed(db *gorm.DB, stats Segment, file SegmentFile) (bool, error) {
+	segment, err := findOrCreateSegment(db, stats.RouteID, stats.Number)
+	if err != nil {
+		return false, err
+	}
+	if file == SegmentFileQLog && segment.RLogProcessedAt.Valid() {
+		return false, db.Model(&segment).Update("qlog_processed_at", time.Now()).Error
+	}
+	return true, db.Model(&segment).Updates(map[string]any{
+		"start_time":                   stats.StartTime,
+		"end_time":                     stats.EndTime,
+		"length":                       stats.Length,
+		"start_lat":                    stats.StartLat,
+		"start_lng":                    stats.StartLng,
+		"end_lat":                      stats.EndLat,
+		"end_lng":                      stats.EndLng,
+		"end_of_route":                 stats.EndOfRoute,
+		"position_source":              stats.PositionSource,
+		string(file) + "_processed_at": time.Now(),
+	}).Error
+}
+
+// SetSegmentEngagedMillis records how long openpilot was engaged during a segment
+func SetSegmentEngagedMillis(db *gorm.DB, segmentID uint, engagedMillis int64) error {
+	return db.Model(&Segment{}).Where("id = ?", segmentID).Update("engaged_millis", engagedMillis).Error
+}
+
+func FindSegmentByRouteIDAndNumber(db *gorm.DB, routeID uint, number int) (Segment, error) {
+	var segment Segment
+	err := db.Where("route_id = ? AND number = ?", routeID, number).First(&segment).Error
+	return segment, err
+}
+
+func FindSegmentsByRouteID(db *gorm.DB, routeID uint) ([]Segment, error) {
+	var segments []Segment
+	err := db.Where("route_id = ?", routeID).Order("number asc").Find(&segments).Error
+	return segments, err
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SegmentEventType string
+
+const (
+	// SegmentEventTypeState is a change of openpilot's state or alert status
+	SegmentEventTypeState     SegmentEventType = "state"
+	SegmentEventTypeEngage    SegmentEventType = "engage"
+	SegmentEventTypeDisengage SegmentEventType = "disengage"
+	// SegmentEventTypeAlert is an alert being shown to the driver
+	SegmentEventTypeAlert SegmentEventType = "alert"
+	// SegmentEventTypeUserFlag is the driver bookmarking a moment of the drive
+	SegmentEventTypeUserFlag SegmentEventType = "user_flag"
+)
+
+// SegmentEvent is an entry in the timeline of a segment
+type SegmentEvent struct {
+	ID        uint             `json:"-" gorm:"primaryKey"`
+	SegmentID uint             `json:"-" gorm:"index"`
+	Segment   Segment          `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Type      SegmentEventType `json:"type"`
+	// LogMonoTime is when the event was logged, in nanoseconds since the device booted
+	LogMonoTime uint64 `json:"log_mono_time"`
+	// OffsetMillis is the time since the start of the segment
+	OffsetMillis int64 `json:"offset_millis"`
+
+	// openpilot's state at the time of the event
+	State       string `json:"state"`
+	Enabled     bool   `json:"enabled"`
+	AlertStatus string `json:"alert_status"`
+	AlertSize   string `json:"alert_size"`
+	AlertType   string `json:"alert_type"`
+	AlertText1  string `json:"alert_text1" gorm:"column:alert_text1"`
+	AlertText2  string `json:"alert_text2" gorm:"column:alert_text2"`
+}
+
+func (u SegmentEvent) TableName() string {
+	return "segment_events"
+}
+
+// ReplaceSegmentEvents stores the timeline of a segment in place of any it had before
+func ReplaceSegmentEvents(db *gorm.DB, segmentID uint, events []SegmentEvent) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("segment_id = ?", segmentID).Delete(&SegmentEvent{}).Error
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		for i := range events {
+			events[i].ID = 0
+			events[i].SegmentID = segmentID
+		}
+		return tx.Omit(clause.Associations).CreateInBatches(events, 100).Error
+	})
+}
+
+func FindSegmentEventsBySegmentID(db *gorm.DB, segmentID uint) ([]SegmentEvent, error) {
+	var events []SegmentEvent
+	err := db.Where("segment_id = ?", segmentID).Order("log_mono_time asc, id asc").Find(&events).Error
+	return events, err
+}
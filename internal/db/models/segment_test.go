@@ -0,0 +1,172 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"gorm.io/gorm"
+)
+
+func makeDB(t *testing.T) (*gorm.DB, models.Device) {
+	t.Helper()
+	database := testutil.NewDB(t)
+	return database, testutil.NewDevice(t, database)
+}
+
+func TestRecordSegmentUpload(t *testing.T) {
+	t.Parallel()
+	database, device := makeDB(t)
+
+	// The first upload of a route creates it and its segment
+	if err := models.RecordSegmentUpload(database, device.ID, "0123456789", 2, models.SegmentFileQCamera, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := models.RecordSegmentUpload(database, device.ID, "0123456789", 2, models.SegmentFileQLog, 20); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	routes, err := models.FindRoutesByDeviceID(database, device.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 1 || routes[0].RouteID != "0123456789" {
+		t.Fatalf("expected a single route, got %+v", routes)
+	}
+	segment, err := models.FindSegmentByRouteIDAndNumber(database, routes[0].ID, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if segment.QCameraSize != 10 || segment.QLogSize != 20 {
+		t.Fatalf("expected the file sizes to be recorded, got %+v", segment)
+	}
+
+	// Cameras need no processing, logs do
+	if !segment.Processed(models.SegmentFileQCamera) {
+		t.Fatalf("expected an uploaded camera to count as processed")
+	}
+	if !segment.Uploaded(models.SegmentFileQLog) || segment.Processed(models.SegmentFileQLog) {
+		t.Fatalf("expected the qlog to be uploaded but not processed")
+	}
+	if segment.Uploaded(models.SegmentFileRLog) {
+		t.Fatalf("expected the rlog not to be uploaded")
+	}
+
+	// A re-uploaded log has to be parsed again
+	if _, err := models.RecordSegmentProcessed(database, models.Segment{RouteID: routes[0].ID, Number: 2}, models.SegmentFileQLog); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := models.RecordSegmentUpload(database, device.ID, "0123456789", 2, models.SegmentFileQLog, 30); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	segment, err = models.FindSegmentByRouteIDAndNumber(database, routes[0].ID, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if segment.QLogSize != 30 || segment.Processed(models.SegmentFileQLog) {
+		t.Fatalf("expected the re-uploaded qlog to need processing, got %+v", segment)
+	}
+
+	segments, err := models.FindSegmentsByRouteID(database, routes[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("expected uploads of the same segment to share a row, got %d", len(segments))
+	}
+}
+
+func TestRecordSegmentProcessedPrefersRLogs(t *testing.T) {
+	t.Parallel()
+	database, device := makeDB(t)
+	route := models.Route{DeviceID: device.ID, RouteID: "0123456789"}
+	if err := database.Create(&route).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	process := func(file models.SegmentFile, length float64) bool {
+		t.Helper()
+		replaced, err := models.RecordSegmentProcessed(database, models.Segment{
+			RouteID:   route.ID,
+			Number:    0,
+			StartTime: 100,
+			EndTime:   200,
+			Length:    length,
+		}, file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return replaced
+	}
+	expectLength := func(length float64) models.Segment {
+		t.Helper()
+		segment, err := models.FindSegmentByRouteIDAndNumber(database, route.ID, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if segment.Length != length {
+			t.Fatalf("expected a length of %v, got %v", length, segment.Length)
+		}
+		return segment
+	}
+
+	// A qlog's stats are replaced each time it's processed, not added to
+	if !process(models.SegmentFileQLog, 1) || !process(models.SegmentFileQLog, 1) {
+		t.Fatalf("expected the qlog's stats to be recorded")
+	}
+	expectLength(1)
+
+	// The rlog replaces what the qlog gave
+	if !process(models.SegmentFileRLog, 2) {
+		t.Fatalf("expected the rlog's stats to be recorded")
+	}
+	expectLength(2)
+
+	// A qlog processed after the rlog is only marked processed
+	if process(models.SegmentFileQLog, 3) {
+		t.Fatalf("expected the qlog not to replace the rlog's stats")
+	}
+	segment := expectLength(2)
+	if !segment.Processed(models.SegmentFileQLog) || !segment.Processed(models.SegmentFileRLog) {
+		t.Fatalf("expected both logs to be marked processed")
+	}
+}
+
+func TestRouteSummarizeIsOrderIndependent(t *testing.T) {
+	t.Parallel()
+	database, device := makeDB(t)
+	route := models.Route{DeviceID: device.ID, RouteID: "0123456789"}
+	if err := database.Create(&route).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Segments processed out of order, with the first processed twice
+	for _, stats := range []models.Segment{
+		{Number: 2, StartTime: 300, EndTime: 400, Length: 3, StartLat: 3, StartLng: 3, EndLat: 4, EndLng: 4, EndOfRoute: true},
+		{Number: 0, StartTime: 100, EndTime: 200, Length: 1, StartLat: 1, StartLng: 1, EndLat: 2, EndLng: 2},
+		{Number: 1, StartTime: 200, EndTime: 300, Length: 2, StartLat: 2, StartLng: 2, EndLat: 3, EndLng: 3},
+		{Number: 0, StartTime: 100, EndTime: 200, Length: 1, StartLat: 1, StartLng: 1, EndLat: 2, EndLng: 2},
+	} {
+		stats.RouteID = route.ID
+		if _, err := models.RecordSegmentProcessed(database, stats, models.SegmentFileQLog); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	segments, err := models.FindSegmentsByRouteID(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	route.Summarize(segments)
+	if route.Length != 6 {
+		t.Fatalf("expected each segment's length to count once, got %v", route.Length)
+	}
+	if route.StartLat != 1 || route.EndLat != 4 {
+		t.Fatalf("expected the route to run from the first segment to the last, got %v to %v", route.StartLat, route.EndLat)
+	}
+	if route.StartTime.UnixNano() != 100 || route.EndTime.UnixNano() != 400 {
+		t.Fatalf("unexpected route times %v to %v", route.StartTime.UnixNano(), route.EndTime.UnixNano())
+	}
+	if !route.AllSegmentsProcessed {
+		t.Fatalf("expected all segments to be processed")
+	}
+}
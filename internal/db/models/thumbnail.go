@@ -0,0 +1,59 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Thumbnail is a preview image openpilot logged during a segment
+type Thumbnail struct {
+	ID        uint    `json:"-" gorm:"primaryKey"`
+	SegmentID uint    `json:"-" gorm:"uniqueIndex:idx_thumbnails_segment_index"`
+	Segment   Segment `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Index orders the thumbnails of a segment, starting from 0
+	Index       int    `json:"index" gorm:"column:thumbnail_index;uniqueIndex:idx_thumbnails_segment_index"`
+	LogMonoTime uint64 `json:"log_mono_time"`
+	// Path is where the JPEG is stored, relative to the root of the uploads storage
+	Path string `json:"-"`
+	Size int64  `json:"size"`
+}
+
+func (u Thumbnail) TableName() string {
+	return "thumbnails"
+}
+
+// SegmentThumbnail is a thumbnail along with the number of the segment it's from
+type SegmentThumbnail struct {
+	Thumbnail
+	Number int `json:"segment"`
+}
+
+// ReplaceSegmentThumbnails stores the thumbnails of a segment in place of any it had before
+func ReplaceSegmentThumbnails(db *gorm.DB, segmentID uint, thumbnails []Thumbnail) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("segment_id = ?", segmentID).Delete(&Thumbnail{}).Error
+		if err != nil {
+			return err
+		}
+		if len(thumbnails) == 0 {
+			return nil
+		}
+		for i := range thumbnails {
+			thumbnails[i].ID = 0
+			thumbnails[i].SegmentID = segmentID
+		}
+		return tx.Omit(clause.Associations).Create(thumbnails).Error
+	})
+}
+
+// FindThumbnailsByRouteID returns the thumbnails of every segment of a route in order
+func FindThumbnailsByRouteID(db *gorm.DB, routeID uint) ([]SegmentThumbnail, error) {
+	var thumbnails []SegmentThumbnail
+	err := db.Model(&Thumbnail{}).
+		Select("thumbnails.*, segments.number").
+		Joins("JOIN segments ON segments.id = thumbnails.segment_id").
+		Where("segments.route_id = ?", routeID).
+		Order("segments.number asc, thumbnails.thumbnail_index asc").
+		Find(&thumbnails).Error
+	return thumbnails, err
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrackPoint is a position a device passed through during a segment
+type TrackPoint struct {
+	ID        uint    `json:"-" gorm:"primaryKey"`
+	SegmentID uint    `json:"-" gorm:"index"`
+	Segment   Segment `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Time is the wall clock time in nanoseconds, or 0 if it's unknown
+	Time      int64   `json:"time"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Speed is in meters per second
+	Speed float64 `json:"speed"`
+	// Distance is how far the device had travelled since the start of the segment, in meters
+	Distance float64 `json:"distance"`
+}
+
+func (u TrackPoint) TableName() string {
+	return "track_points"
+}
+
+// SegmentTrackPoint is a track point along with the number of the segment it's from
+type SegmentTrackPoint struct {
+	TrackPoint
+	Number int
+}
+
+// ReplaceSegmentTrack stores the track of a segment in place of any it had before
+func ReplaceSegmentTrack(db *gorm.DB, segmentID uint, points []TrackPoint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("segment_id = ?", segmentID).Delete(&TrackPoint{}).Error
+		if err != nil {
+			return err
+		}
+		if len(points) == 0 {
+			return nil
+		}
+		for i := range points {
+			points[i].ID = 0
+			points[i].SegmentID = segmentID
+		}
+		return tx.Omit(clause.Associations).CreateInBatches(points, 100).Error
+	})
+}
+
+// FindTrackPointsByRouteID returns the tracks of every segment of a route in order
+func FindTrackPointsByRouteID(db *gorm.DB, routeID uint) ([]SegmentTrackPoint, error) {
+	var points []SegmentTrackPoint
+	err := db.Model(&TrackPoint{}).
+		Select("track_points.*, segments.number").
+		Joins("JOIN segments ON segments.id = track_points.segment_id").
+		Where("segments.route_id = ?", routeID).
+		Order("segments.number asc, track_points.id asc").
+		Find(&points).Error
+	return points, err
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PartialUpload tracks a file that a device is uploading in chunks and that
+// has not been fully received yet.
+type PartialUpload struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" binding:"required" gorm:"uniqueIndex:idx_partial_uploads_device_path"`
+	Path     string `json:"path" binding:"required" gorm:"uniqueIndex:idx_partial_uploads_device_path"`
+	// Received is the number of contiguous bytes received so far
+	Received int64 `json:"received"`
+	// Size is the total size of the file, or -1 if the device hasn't told us yet
+	Size   int64         `json:"size" gorm:"default:-1"`
+	Chunks []UploadChunk `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u PartialUpload) TableName() string {
+	return "partial_uploads"
+}
+
+// Complete reports whether every byte of the file has been received
+func (u PartialUpload) Complete() bool {
+	return u.Size >= 0 && u.Received == u.Size
+}
+
+// UploadChunk is a single stored piece of a PartialUpload
+type UploadChunk struct {
+	ID              uint  `json:"-" gorm:"primaryKey"`
+	PartialUploadID uint  `json:"-" binding:"required" gorm:"index"`
+	Offset          int64 `json:"offset"`
+	Size            int64 `json:"size"`
+	// Name is the chunk's file under the upload's partial directory. Every
+	// attempt at an offset writes its own file so retries can't clobber
+	// the chunk that was recorded.
+	Name string `json:"-"`
+}
+
+func (u UploadChunk) TableName() string {
+	return "upload_chunks"
+}
+
+func FindPartialUpload(db *gorm.DB, deviceID uint, path string) (PartialUpload, error) {
+	var upload PartialUpload
+	err := db.Preload("Chunks", func(db *gorm.DB) *gorm.DB {
+		return db.Order("upload_chunks.offset asc")
+	}).Where("device_id = ? AND path = ?", deviceID, path).First(&upload).Error
+	return upload, err
+}
+
+// AdvancePartialUpload records a newly stored chunk. The update only applies if
+// nothing else has written to the upload since it was read, so two racing
+// requests can't both claim the same range.
+func AdvancePartialUpload(db *gorm.DB, upload *PartialUpload, chunk UploadChunk) (bool, error) {
+	advanced := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&PartialUpload{}).
+			Where("id = ? AND received = ?", upload.ID, upload.Received).
+			Updates(map[string]any{
+				"received":   upload.Received + chunk.Size,
+				"size":       upload.Size,
+				"updated_at": time.Now(),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		chunk.PartialUploadID = upload.ID
+		if err := tx.Create(&chunk).Error; err != nil {
+			return err
+		}
+		advanced = true
+		upload.Received += chunk.Size
+		upload.Chunks = append(upload.Chunks, chunk)
+		return nil
+	})
+	return advanced, err
+}
+
+func DeletePartialUpload(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("partial_upload_id = ?", id).Delete(&UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&PartialUpload{}, id).Error
+	})
+}
+
+// PendingUpload is a file a device was given a presigned URL for and that
+// hasn't been seen in storage yet.
+type PendingUpload struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" binding:"required" gorm:"uniqueIndex:idx_pending_uploads_device_path"`
+	Device   Device `json:"-"`
+	Path     string `json:"path" binding:"required" gorm:"uniqueIndex:idx_pending_uploads_device_path"`
+	// IssuedAt is when the latest URL was handed out, anything stored
+	// before then is from an earlier upload of the same path
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u PendingUpload) TableName() string {
+	return "pending_uploads"
+}
+
+// UpsertPendingUpload starts tracking a presigned upload, replacing any
+// earlier URL issued for the same path
+func UpsertPendingUpload(db *gorm.DB, deviceID uint, path string, issuedAt, expiresAt time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var upload PendingUpload
+		err := tx.Where("device_id = ? AND path = ?", deviceID, path).
+			Attrs(PendingUpload{DeviceID: deviceID, Path: path}).
+			FirstOrInit(&upload).Error
+		if err != nil {
+			return err
+		}
+		upload.IssuedAt = issuedAt
+		upload.ExpiresAt = expiresAt
+		return tx.Save(&upload).Error
+	})
+}
+
+func ListPendingUploads(db *gorm.DB) ([]PendingUpload, error) {
+	var uploads []PendingUpload
+	err := db.Preload("Device").Order("issued_at asc").Find(&uploads).Error
+	return uploads, err
+}
+
+func DeletePendingUpload(db *gorm.DB, id uint) error {
+	return db.Delete(&PendingUpload{}, id).Error
+}
+
+// ClaimPendingUpload deletes a pending upload as long as its URL hasn't been
+// reissued since it was read. Only the caller that gets true back should
+// process the upload, so instances sharing the database don't all process it.
+func ClaimPendingUpload(db *gorm.DB, upload PendingUpload) (bool, error) {
+	res := db.Where("id = ? AND issued_at = ?", upload.ID, upload.IssuedAt).Delete(&PendingUpload{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
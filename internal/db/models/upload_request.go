@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UploadRequestState string
+
+const (
+	// UploadRequestStatePending requests haven't been sent to the device yet
+	UploadRequestStatePending UploadRequestState = "pending"
+	// UploadRequestStateQueued requests are in the device's upload queue
+	UploadRequestStateQueued    UploadRequestState = "queued"
+	UploadRequestStateUploaded  UploadRequestState = "uploaded"
+	UploadRequestStateFailed    UploadRequestState = "failed"
+	UploadRequestStateCancelled UploadRequestState = "cancelled"
+	// UploadRequestStateExpired requests weren't uploaded before their URL expired
+	UploadRequestStateExpired UploadRequestState = "expired"
+)
+
+// UploadRequest is a file a user asked a device to upload, sent to it with
+// uploadFilesToUrls once it's online
+type UploadRequest struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	DeviceID uint   `json:"-" gorm:"index:idx_upload_requests_device_path"`
+	Device   Device `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	RouteID  uint   `json:"-" gorm:"index"`
+	Route    Route  `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Path is relative to the device's upload directory, such as <route>--<segment>/fcamera.hevc
+	Path          string             `json:"path" gorm:"index:idx_upload_requests_device_path"`
+	Segment       int                `json:"segment"`
+	AllowCellular bool               `json:"allow_cellular"`
+	State         UploadRequestState `json:"state" gorm:"index"`
+	// UploadID is the ID of the file in the device's upload queue
+	UploadID string  `json:"upload_id"`
+	Progress float64 `json:"progress"`
+	// ExpiresAt is when the upload URL sent to the device expires, unset until it's sent
+	ExpiresAt time.Time `json:"expires_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u UploadRequest) TableName() string {
+	return "upload_requests"
+}
+
+// Active reports whether the request is still waiting to be uploaded
+func (u UploadRequest) Active() bool {
+	return u.State == UploadRequestStatePending || u.State == UploadRequestStateQueued
+}
+
+// CreateUploadRequests saves new pending requests, except for the files that
+// already have an active request. It returns the active requests for every file.
+func CreateUploadRequests(db *gorm.DB, requests []UploadRequest) ([]UploadRequest, error) {
+	var created []UploadRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, request := range requests {
+			var existing UploadRequest
+			err := tx.Where("device_id = ? AND path = ? AND state IN ?", request.DeviceID, request.Path,
+				[]UploadRequestState{UploadRequestStatePending, UploadRequestStateQueued}).
+				First(&existing).Error
+			if err == nil {
+				created = append(created, existing)
+				continue
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			request.State = UploadRequestStatePending
+			if err := tx.Omit(clause.Associations).Create(&request).Error; err != nil {
+				return err
+			}
+			created = append(created, request)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// ListUploadRequests returns the requests for a route's files, oldest first
+func ListUploadRequests(db *gorm.DB, routeID uint) ([]UploadRequest, error) {
+	var requests []UploadRequest
+	err := db.Where("route_id = ?", routeID).Order("id asc").Find(&requests).Error
+	return requests, err
+}
+
+// ListUploadRequestsInState returns a device's requests in state, oldest first
+func ListUploadRequestsInState(db *gorm.DB, deviceID uint, state UploadRequestState) ([]UploadRequest, error) {
+	var requests []UploadRequest
+	err := db.Where("device_id = ? AND state = ?", deviceID, state).Order("id asc").Find(&requests).Error
+	return requests, err
+}
+
+func FindUploadRequestByID(db *gorm.DB, id uint) (UploadRequest, error) {
+	var request UploadRequest
+	err := db.First(&request, id).Error
+	return request, err
+}
+
+// UpdateUploadRequest saves the state and progress of a request, provided it's
+// still in state from. It reports false if something else changed the request
+// first, such as its upload completing while the device was being called.
+func UpdateUploadRequest(db *gorm.DB, request *UploadRequest, from UploadRequestState) (bool, error) {
+	res := db.Model(&UploadRequest{}).
+		Where("id = ? AND state = ?", request.ID, from).
+		Updates(map[string]any{
+			"state":      request.State,
+			"upload_id":  request.UploadID,
+			"progress":   request.Progress,
+			"expires_at": request.ExpiresAt,
+			"updated_at": time.Now(),
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+// CompleteUploadRequests marks the active requests for a file uploaded
+func CompleteUploadRequests(db *gorm.DB, deviceID uint, path string) error {
+	return db.Model(&UploadRequest{}).
+		Where("device_id = ? AND path = ? AND state IN ?", deviceID, path,
+			[]UploadRequestState{UploadRequestStatePending, UploadRequestStateQueued}).
+		Updates(map[string]any{
+			"state":      UploadRequestStateUploaded,
+			"progress":   1,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// ExpireUploadRequests marks a device's queued requests whose URL has expired
+func ExpireUploadRequests(db *gorm.DB, deviceID uint) error {
+	return db.Model(&UploadRequest{}).
+		Where("device_id = ? AND state = ? AND expires_at < ?", deviceID, UploadRequestStateQueued, time.Now()).
+		Updates(map[string]any{
+			"state":      UploadRequestStateExpired,
+			"updated_at": time.Now(),
+		}).Error
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Vehicle is a car a device has driven, as identified by openpilot's carParams
+type Vehicle struct {
+	ID uint `json:"-" gorm:"primaryKey"`
+	// Identity is the VIN, or when openpilot couldn't read it, the fingerprint
+	// prefixed with the dongle ID of the device that drove the car
+	Identity              string   `json:"-" gorm:"uniqueIndex;size:128"`
+	VIN                   string   `json:"vin" gorm:"column:vin"`
+	Brand                 string   `json:"brand"`
+	Fingerprint           string   `json:"fingerprint"`
+	OpenpilotLongitudinal bool     `json:"openpilot_longitudinal"`
+	Radar                 bool     `json:"radar"`
+	Devices               []Device `json:"-" gorm:"many2many:device_vehicles"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u Vehicle) TableName() string {
+	return "vehicles"
+}
+
+// KnownVIN reports whether vin was read from the car. openpilot reports
+// a VIN of all zeros when it couldn't read one.
+func KnownVIN(vin string) bool {
+	return strings.Trim(vin, "0") != ""
+}
+
+// FindOrCreateVehicle returns the vehicle described by vehicle, updating what's
+// known about it, and records that device has driven it
+func FindOrCreateVehicle(db *gorm.DB, device Device, vehicle Vehicle) (Vehicle, error) {
+	vehicle.ID = 0
+	if KnownVIN(vehicle.VIN) {
+		vehicle.Identity = vehicle.VIN
+	} else {
+		vehicle.VIN = ""
+		vehicle.Identity = device.DongleID + "|" + vehicle.Fingerprint
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "identity"}},
+			DoUpdates: clause.AssignmentColumns([]string{"brand", "fingerprint", "openpilot_longitudinal", "radar", "updated_at"}),
+		}).Create(&vehicle).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("identity = ?", vehicle.Identity).First(&vehicle).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&vehicle).Omit("Devices.*").Association("Devices").Append(&device)
+	})
+	return vehicle, err
+}
+
+// VehicleMileage is how far a device has driven a vehicle
+type VehicleMileage struct {
+	Vehicle
+	// Miles is the length of the device's routes in the vehicle
+	Miles  float64 `json:"miles"`
+	Routes int64   `json:"routes"`
+}
+
+// FindVehicleMileageByDeviceID returns every vehicle a device has driven, most driven first
+func FindVehicleMileageByDeviceID(db *gorm.DB, deviceID uint) ([]VehicleMileage, error) {
+	var vehicles []VehicleMileage
+	err := db.Model(&Vehicle{}).
+		Select("vehicles.*, COALESCE(SUM(routes.length), 0) AS miles, COUNT(routes.id) AS routes").
+		Joins("JOIN device_vehicles ON device_vehicles.vehicle_id = vehicles.id AND device_vehicles.device_id = ?", deviceID).
+		Joins("LEFT JOIN routes ON routes.vehicle_id = vehicles.id AND routes.device_id = ? AND routes.deleted_at IS NULL", deviceID).
+		Group("vehicles.id").
+		Order("miles desc").
+		Find(&vehicles).Error
+	return vehicles, err
+}
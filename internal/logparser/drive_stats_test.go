@@ -0,0 +1,64 @@
+package logparser_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+func TestDriveStats(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	const second = uint64(1_000_000_000)
+	segment := func(meters float64) []event {
+		return []event{
+			{1, initData("0.9.7")},
+			{second, clocks(1_700_000_001_000_000_000)},
+			{second, liveLocationKalman(6378137, 0, 0, 10, 20)},
+			{second, selfdriveState(disabled)},
+			{31 * second, selfdriveState(enabled)},
+			{91 * second, selfdriveState(enabled)},
+			{151 * second, selfdriveState(disabled)},
+			{181 * second, liveLocationKalman(6378137, 0, meters, 11, 21)},
+		}
+	}
+	q.upload(t, "qlog", encodeLog(t, segment(1000)...))
+	q.upload(t, "rlog", encodeLog(t, segment(2000)...))
+
+	for _, since := range []time.Time{{}, time.Unix(1_700_000_000, 0).Add(-7 * 24 * time.Hour)} {
+		stats, err := models.SumDriveStats(q.db, q.device.ID, since)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The rlog replaces what was counted for the qlog
+		if stats.Routes != 1 || math.Abs(stats.Distance-q.segment(t).Length) > 1e-9 {
+			t.Errorf("expected 1 route of %f miles, got %d of %f", q.segment(t).Length, stats.Routes, stats.Distance)
+		}
+		if stats.DriveMillis/1000 != 180 || stats.EngagedMillis != 120_000 {
+			t.Errorf("expected 180s driven with 120s engaged, got %dms and %dms", stats.DriveMillis, stats.EngagedMillis)
+		}
+	}
+
+	stats, err := models.SumDriveStats(q.db, q.device.ID, time.Unix(1_700_000_000, 0).Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Routes != 0 || stats.Distance != 0 {
+		t.Errorf("expected nothing driven after the route's day, got %+v", stats)
+	}
+
+	// A reset route no longer counts until it's parsed again
+	if err := models.ResetRoute(q.db, q.segment(t).RouteID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats, err = models.SumDriveStats(q.db, q.device.ID, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Routes != 0 || stats.Distance != 0 || stats.DriveMillis != 0 || stats.EngagedMillis != 0 {
+		t.Errorf("expected the reset route's stats to be removed, got %+v", stats)
+	}
+}
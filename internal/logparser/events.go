@@ -0,0 +1,175 @@
+package logparser
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// openpilotState is what the timeline follows of openpilot's state
+type openpilotState struct {
+	state       string
+	enabled     bool
+	alertStatus string
+	alertSize   string
+	alertType   string
+	alertText1  string
+	alertText2  string
+}
+
+func (s openpilotState) event(eventType models.SegmentEventType, logMonoTime uint64) models.SegmentEvent {
+	return models.SegmentEvent{
+		Type:        eventType,
+		LogMonoTime: logMonoTime,
+		State:       s.state,
+		Enabled:     s.enabled,
+		AlertStatus: s.alertStatus,
+		AlertSize:   s.alertSize,
+		AlertType:   s.alertType,
+		AlertText1:  s.alertText1,
+		AlertText2:  s.alertText2,
+	}
+}
+
+// timeline turns openpilot's state, which is logged many times a second, into its transitions
+type timeline struct {
+	events []models.SegmentEvent
+	last   *openpilotState
+	// lastTime is when last was logged
+	lastTime uint64
+	// engaged is how long openpilot has been enabled, in nanoseconds
+	engaged uint64
+}
+
+func (t *timeline) observe(logMonoTime uint64, state openpilotState) {
+	last := t.last
+	if last != nil && last.enabled && logMonoTime > t.lastTime {
+		t.engaged += logMonoTime - t.lastTime
+	}
+	t.last = &state
+	t.lastTime = logMonoTime
+	if last == nil || last.state != state.state || last.enabled != state.enabled || last.alertStatus != state.alertStatus {
+		t.events = append(t.events, state.event(models.SegmentEventTypeState, logMonoTime))
+	}
+	if last != nil && last.enabled != state.enabled {
+		if state.enabled {
+			t.events = append(t.events, state.event(models.SegmentEventTypeEngage, logMonoTime))
+		} else {
+			t.events = append(t.events, state.event(models.SegmentEventTypeDisengage, logMonoTime))
+		}
+	}
+	if state.alertType != "" && (last == nil || last.alertType != state.alertType) {
+		t.events = append(t.events, state.event(models.SegmentEventTypeAlert, logMonoTime))
+	}
+}
+
+// eventsExtractor builds the timeline of engagement, alerts and user flags of a segment.
+// openpilot's state moved from controlsState to selfdriveState in 0.9.8, which is
+// preferred when a log has both.
+type eventsExtractor struct {
+	selfdrive timeline
+	controls  timeline
+	userFlags []uint64
+}
+
+func (e *eventsExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{
+		cereal.Event_Which_selfdriveState,
+		cereal.Event_Which_controlsState,
+		cereal.Event_Which_userFlag,
+	}
+}
+
+func (e *eventsExtractor) Handle(event cereal.Event) error {
+	//nolint:golint,exhaustive
+	switch event.Which() {
+	case cereal.Event_Which_selfdriveState:
+		selfdriveState, err := event.SelfdriveState()
+		if err != nil {
+			return err
+		}
+		state := openpilotState{
+			state:       selfdriveState.State().String(),
+			enabled:     selfdriveState.Enabled(),
+			alertStatus: selfdriveState.AlertStatus().String(),
+			alertSize:   selfdriveState.AlertSize().String(),
+		}
+		if state.alertType, err = selfdriveState.AlertType(); err != nil {
+			return err
+		}
+		if state.alertText1, err = selfdriveState.AlertText1(); err != nil {
+			return err
+		}
+		if state.alertText2, err = selfdriveState.AlertText2(); err != nil {
+			return err
+		}
+		e.selfdrive.observe(event.LogMonoTime(), state)
+	case cereal.Event_Which_controlsState:
+		controlsState, err := event.ControlsState()
+		if err != nil {
+			return err
+		}
+		state := openpilotState{
+			state:       controlsState.StateDEPRECATED().String(),
+			enabled:     controlsState.EnabledDEPRECATED(),
+			alertStatus: controlsState.AlertStatusDEPRECATED().String(),
+			alertSize:   controlsState.AlertSizeDEPRECATED().String(),
+		}
+		if state.alertType, err = controlsState.AlertTypeDEPRECATED(); err != nil {
+			return err
+		}
+		if state.alertText1, err = controlsState.AlertText1DEPRECATED(); err != nil {
+			return err
+		}
+		if state.alertText2, err = controlsState.AlertText2DEPRECATED(); err != nil {
+			return err
+		}
+		e.controls.observe(event.LogMonoTime(), state)
+	case cereal.Event_Which_userFlag:
+		e.userFlags = append(e.userFlags, event.LogMonoTime())
+	}
+	return nil
+}
+
+// state returns the timeline of openpilot's state the log is read from
+func (e *eventsExtractor) state() *timeline {
+	if e.selfdrive.last != nil {
+		return &e.selfdrive
+	}
+	return &e.controls
+}
+
+func (e *eventsExtractor) timeline() []models.SegmentEvent {
+	events := slices.Clone(e.state().events)
+	for _, logMonoTime := range e.userFlags {
+		events = append(events, models.SegmentEvent{
+			Type:        models.SegmentEventTypeUserFlag,
+			LogMonoTime: logMonoTime,
+		})
+	}
+	slices.SortStableFunc(events, func(a, b models.SegmentEvent) int {
+		return cmp.Compare(a.LogMonoTime, b.LogMonoTime)
+	})
+	return events
+}
+
+func (e *eventsExtractor) Persist(db *gorm.DB, segment SegmentContext) error {
+	stored, err := models.FindSegmentByRouteIDAndNumber(db, segment.Route.ID, segment.Number)
+	if err != nil {
+		return err
+	}
+	events := e.timeline()
+	for i := range events {
+		if events[i].LogMonoTime > segment.Data.InitLogMonoTime {
+			events[i].OffsetMillis = int64((events[i].LogMonoTime - segment.Data.InitLogMonoTime) / 1e6)
+		}
+	}
+	err = models.ReplaceSegmentEvents(db, stored.ID, events)
+	if err != nil {
+		return err
+	}
+	return models.SetSegmentEngagedMillis(db, stored.ID, int64(e.state().engaged/1e6))
+}
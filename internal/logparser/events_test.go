@@ -0,0 +1,117 @@
+package logparser_test
+
+import (
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+type openpilotState struct {
+	state     cereal.SelfdriveState_OpenpilotState
+	enabled   bool
+	status    cereal.SelfdriveState_AlertStatus
+	alertType string
+}
+
+func selfdriveState(s openpilotState) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		selfdriveState, err := ev.NewSelfdriveState()
+		if err != nil {
+			return err
+		}
+		selfdriveState.SetState(s.state)
+		selfdriveState.SetEnabled(s.enabled)
+		selfdriveState.SetAlertStatus(s.status)
+		return selfdriveState.SetAlertType(s.alertType)
+	}
+}
+
+func controlsState(s openpilotState) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		controlsState, err := ev.NewControlsState()
+		if err != nil {
+			return err
+		}
+		controlsState.SetStateDEPRECATED(s.state)
+		controlsState.SetEnabledDEPRECATED(s.enabled)
+		controlsState.SetAlertStatusDEPRECATED(s.status)
+		return controlsState.SetAlertTypeDEPRECATED(s.alertType)
+	}
+}
+
+//nolint:golint,gochecknoglobals
+var (
+	disabled = openpilotState{state: cereal.SelfdriveState_OpenpilotState_disabled}
+	enabled  = openpilotState{state: cereal.SelfdriveState_OpenpilotState_enabled, enabled: true}
+	alerted  = openpilotState{
+		state:     cereal.SelfdriveState_OpenpilotState_enabled,
+		enabled:   true,
+		status:    cereal.SelfdriveState_AlertStatus_userPrompt,
+		alertType: "steerSaturated/permanent",
+	}
+)
+
+func TestSegmentEvents(t *testing.T) {
+	t.Parallel()
+
+	want := []struct {
+		eventType models.SegmentEventType
+		offset    int64
+	}{
+		{models.SegmentEventTypeState, 100},
+		{models.SegmentEventTypeState, 1000},
+		{models.SegmentEventTypeEngage, 1000},
+		{models.SegmentEventTypeUserFlag, 1500},
+		{models.SegmentEventTypeState, 2000},
+		{models.SegmentEventTypeAlert, 2000},
+		{models.SegmentEventTypeState, 3000},
+		{models.SegmentEventTypeState, 4000},
+		{models.SegmentEventTypeDisengage, 4000},
+	}
+
+	tests := []struct {
+		name  string
+		state func(openpilotState) func(cereal.Event) error
+	}{
+		{"selfdriveState", selfdriveState},
+		// openpilot before 0.9.8
+		{"controlsState", controlsState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			q := newTestQueue(t)
+
+			const ms = 1_000_000
+			q.upload(t, "qlog", encodeLog(t,
+				event{0, initData("0.9.7")},
+				event{100 * ms, tt.state(disabled)},
+				event{500 * ms, tt.state(disabled)},
+				event{1000 * ms, tt.state(enabled)},
+				event{1500 * ms, userFlag()},
+				event{2000 * ms, tt.state(alerted)},
+				event{2500 * ms, tt.state(alerted)},
+				event{3000 * ms, tt.state(enabled)},
+				event{4000 * ms, tt.state(disabled)},
+			))
+
+			events, err := models.FindSegmentEventsBySegmentID(q.db, q.segment(t).ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(events) != len(want) {
+				t.Fatalf("expected %d events, got %d: %+v", len(want), len(events), events)
+			}
+			for i, event := range events {
+				if event.Type != want[i].eventType || event.OffsetMillis != want[i].offset {
+					t.Errorf("expected event %d to be %s at %dms, got %s at %dms", i, want[i].eventType, want[i].offset, event.Type, event.OffsetMillis)
+				}
+			}
+			alert := events[5]
+			if alert.AlertType != alerted.alertType || alert.AlertStatus != "userPrompt" || !alert.Enabled {
+				t.Errorf("unexpected alert %+v", alert)
+			}
+		})
+	}
+}
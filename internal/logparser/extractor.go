@@ -0,0 +1,68 @@
+package logparser
+
+import (
+	"sync"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Extractor derives data from the events of a single segment's log. A new
+// extractor is made for every log that's decoded, so it can accumulate state.
+type Extractor interface {
+	// Events lists the event types Handle should be called with
+	Events() []cereal.Event_Which
+	// Handle is called with each event of the subscribed types, in log order
+	Handle(event cereal.Event) error
+}
+
+// Persister is implemented by extractors that store their results once the
+// route the segment belongs to is known
+type Persister interface {
+	Persist(db *gorm.DB, segment SegmentContext) error
+}
+
+// SegmentContext identifies the segment a log was decoded for
+type SegmentContext struct {
+	Device models.Device
+	Route  models.Route
+	Number int
+	// File is the log the events were read from
+	File models.SegmentFile
+	Data SegmentData
+	// Storage is the uploads storage, where Dir is the segment's directory
+	Storage storage.Storage
+	Dir     string
+}
+
+//nolint:golint,gochecknoglobals
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]func() Extractor{
+		"events":     func() Extractor { return &eventsExtractor{} },
+		"thumbnails": func() Extractor { return &thumbnailExtractor{} },
+		"track":      func() Extractor { return &trackExtractor{} },
+		"vehicle":    func() Extractor { return &vehicleExtractor{} },
+	}
+)
+
+// RegisterExtractor adds an extractor to every log decoded by the queue from
+// then on. Registering a name again replaces the extractor.
+func RegisterExtractor(name string, newExtractor func() Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[name] = newExtractor
+}
+
+// newExtractors makes a fresh instance of every registered extractor
+func newExtractors() map[string]Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	instances := make(map[string]Extractor, len(extractors))
+	for name, newExtractor := range extractors {
+		instances[name] = newExtractor()
+	}
+	return instances
+}
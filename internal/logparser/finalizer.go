@@ -0,0 +1,70 @@
+package logparser
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// FinalizeInterval is how often routes are checked for having gone stale
+const FinalizeInterval = time.Minute
+
+// RouteFinalizer closes routes whose final segment, the one with the
+// endOfRoute sentinel, is never uploaded. Without it they'd have no end
+// and be left out of every time range query.
+type RouteFinalizer struct {
+	db *gorm.DB
+	// after is how long a route has to go without new segments to be finalized
+	after     time.Duration
+	interval  time.Duration
+	stopChan  chan any
+	closeChan chan any
+}
+
+func NewRouteFinalizer(db *gorm.DB, after time.Duration) *RouteFinalizer {
+	return &RouteFinalizer{
+		db:        db,
+		after:     after,
+		interval:  FinalizeInterval,
+		stopChan:  make(chan any),
+		closeChan: make(chan any),
+	}
+}
+
+func (f *RouteFinalizer) Start() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopChan:
+			f.closeChan <- struct{}{}
+			return
+		case <-ticker.C:
+			f.Check()
+		}
+	}
+}
+
+func (f *RouteFinalizer) Stop() {
+	close(f.stopChan)
+	<-f.closeChan
+}
+
+// Check finalizes the routes that have gone stale
+func (f *RouteFinalizer) Check() {
+	routes, err := models.FindStaleRoutes(f.db, time.Now().Add(-f.after))
+	if err != nil {
+		slog.Error("Failed to find stale routes", "error", err)
+		return
+	}
+	for _, route := range routes {
+		err := models.FinalizeRoute(f.db, &route)
+		if err != nil {
+			slog.Error("Failed to finalize route", "route", route.RouteID, "error", err)
+			continue
+		}
+		slog.Info("Finalized route without its final segment", "route", route.RouteID, "end_time", route.EndTime)
+	}
+}
@@ -0,0 +1,75 @@
+package logparser_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+)
+
+func TestRouteFinalizer(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+	finalizer := logparser.NewRouteFinalizer(q.db, time.Hour)
+
+	const second = uint64(1_000_000_000)
+	q.upload(t, "qlog", encodeLog(t,
+		event{1, initData("0.9.7")},
+		event{second, clocks(1_700_000_001_000_000_000)},
+		event{second, liveLocationKalman(6378137, 0, 0, 10, 20)},
+		event{60 * second, liveLocationKalman(6378137, 0, 1000, 11, 21)},
+	))
+	route := func() models.Route {
+		t.Helper()
+		route, err := models.FindRouteByDeviceIDAndRouteID(q.db, q.device.ID, "abcdef0123")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return route
+	}
+
+	// Segments that were just uploaded may still be followed by more
+	finalizer.Check()
+	if open := route(); open.FinalizedAt.Valid() || !open.EndTime.IsZero() {
+		t.Fatalf("expected the route to be left open, got end time %v", open.EndTime)
+	}
+
+	if err := q.db.Model(&models.Segment{}).Where("route_id = ?", route().ID).UpdateColumn("updated_at", time.Now().Add(-2*time.Hour)).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	finalizer.Check()
+	finalized := route()
+	if !finalized.FinalizedAt.Valid() {
+		t.Fatal("expected the stale route to be finalized")
+	}
+	if want := time.Unix(1_700_000_060, 0); !finalized.EndTime.Equal(want) {
+		t.Errorf("expected the route to end with its last position at %v, got %v", want, finalized.EndTime)
+	}
+	if math.Abs(finalized.EndLat-11) > 1e-9 || math.Abs(finalized.EndLng-21) > 1e-9 {
+		t.Errorf("expected the route to end at 11,21, got %f,%f", finalized.EndLat, finalized.EndLng)
+	}
+	if !finalized.AllSegmentsProcessed {
+		t.Error("expected every segment of the finalized route to be processed")
+	}
+
+	// The final segment turning up reopens the route and ends it properly
+	q.uploadSegment(t, "abcdef0123", 1, "qlog", encodeLog(t,
+		event{1, initData("0.9.7")},
+		event{61 * second, liveLocationKalman(6378137, 0, 0, 12, 22)},
+		event{120 * second, liveLocationKalman(6378137, 0, 1000, 13, 23)},
+		event{121 * second, sentinel(cereal.Sentinel_SentinelType_endOfRoute)},
+	))
+	ended := route()
+	if ended.FinalizedAt.Valid() {
+		t.Error("expected the route to no longer be finalized")
+	}
+	if want := time.Unix(1_700_000_121, 0); !ended.EndTime.Equal(want) {
+		t.Errorf("expected the route to end with its final segment at %v, got %v", want, ended.EndTime)
+	}
+	if math.Abs(ended.EndLat-13) > 1e-9 || math.Abs(ended.EndLng-23) > 1e-9 {
+		t.Errorf("expected the route to end at 13,23, got %f,%f", ended.EndLat, ended.EndLng)
+	}
+}
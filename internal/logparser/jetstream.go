@@ -0,0 +1,290 @@
+package logparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// JetStreamStream holds one message per queued log job
+	JetStreamStream = "LOG_PARSER"
+	// JetStreamConsumer is the durable consumer every instance pulls jobs from
+	JetStreamConsumer = "log-parsers"
+	// JetStreamLocks is the key-value bucket that holds a lock per dongle
+	// while one of its logs is being parsed
+	JetStreamLocks = "log_parser_locks"
+
+	jetStreamSubjectPrefix = "logparser.jobs."
+	// jetStreamLockTTL is how long a lock outlives an instance that dies mid-job
+	jetStreamLockTTL = time.Minute
+	// jetStreamAckWait is how long a message can go without a heartbeat before
+	// it's handed to another instance
+	jetStreamAckWait = 2 * time.Minute
+	// jetStreamHeartbeat is how often a running job extends its lock and message
+	jetStreamHeartbeat = 20 * time.Second
+	// jetStreamLockedDelay is how long to wait before trying a job again when
+	// another log of the same dongle is being parsed
+	jetStreamLockedDelay = 5 * time.Second
+	jetStreamTimeout     = 10 * time.Second
+)
+
+type jetStreamQueue struct {
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+	locks    jetstream.KeyValue
+	owner    string
+	// lockedDelay is jetStreamLockedDelay, shortened by tests
+	lockedDelay time.Duration
+}
+
+// UseJetStream shares the queue with every instance connected to NATS. Jobs
+// are still tracked in the database, but are handed out through a JetStream
+// work queue and a dongle's logs are parsed one at a time across the cluster.
+// It must be called before Start.
+func (q *LogQueue) UseJetStream(nc *nats.Conn) error {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jetStreamTimeout)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      JetStreamStream,
+		Subjects:  []string{jetStreamSubjectPrefix + ">"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create log parser stream: %w", err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:    JetStreamConsumer,
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    jetStreamAckWait,
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create log parser consumer: %w", err)
+	}
+
+	locks, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: JetStreamLocks,
+		TTL:    jetStreamLockTTL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create log parser locks: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	q.jetStream = &jetStreamQueue{
+		js:       js,
+		consumer: consumer,
+		locks:    locks,
+		owner:    fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+
+		lockedDelay: jetStreamLockedDelay,
+	}
+	return nil
+}
+
+// publish queues a job's message. The message ID changes whenever the job is
+// queued again, but not when several instances republish the same pending job.
+func (q *LogQueue) publish(job models.LogJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), jetStreamTimeout)
+	defer cancel()
+	_, err := q.jetStream.js.Publish(ctx,
+		jetStreamSubjectPrefix+strconv.FormatUint(uint64(job.DeviceID), 10),
+		[]byte(strconv.FormatUint(uint64(job.ID), 10)),
+		jetstream.WithMsgID(fmt.Sprintf("%d-%d", job.ID, job.UpdatedAt.UnixNano())))
+	return err
+}
+
+// consume pulls jobs from JetStream until the queue is stopped
+func (q *LogQueue) consume() {
+	// Jobs queued while JetStream was unavailable or disabled
+	pending, err := models.ListLogJobs(q.db, models.LogJobStatePending, -1)
+	if err != nil {
+		slog.Error("Error listing pending log jobs", "err", err)
+	}
+	for _, job := range pending {
+		err := q.publish(job)
+		if err != nil {
+			slog.Error("Error publishing log job", "job", job.ID, "err", err)
+		}
+	}
+
+	for {
+		select {
+		case <-q.stopChan:
+			return
+		default:
+		}
+
+		q.updateMetrics()
+		free := int(q.config.ParallelLogParsers) - int(q.activeJobsCount.Value())
+		if free <= 0 {
+			select {
+			case <-q.stopChan:
+				return
+			case <-q.wakeChan:
+			}
+			continue
+		}
+
+		batch, err := q.jetStream.consumer.Fetch(free, jetstream.FetchMaxWait(PollInterval))
+		if err != nil {
+			slog.Error("Error fetching log jobs", "err", err)
+			select {
+			case <-q.stopChan:
+				return
+			case <-time.After(PollInterval):
+			}
+			continue
+		}
+		for msg := range batch.Messages() {
+			q.handleMessage(msg)
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			slog.Error("Error fetching log jobs", "err", err)
+		}
+	}
+}
+
+// handleMessage starts the job a message refers to once its dongle's lock is
+// free. Whatever happens, the message is acked, nacked or terminated.
+func (q *LogQueue) handleMessage(msg jetstream.Msg) {
+	id, err := strconv.ParseUint(string(msg.Data()), 10, 0)
+	if err != nil {
+		slog.Error("Invalid log job message", "data", string(msg.Data()))
+		_ = msg.Term()
+		return
+	}
+	job, err := models.FindLogJobByID(q.db, uint(id))
+	if err != nil {
+		slog.Error("Error finding log job", "job", id, "err", err)
+		_ = msg.NakWithDelay(q.jetStream.lockedDelay)
+		return
+	}
+
+	switch job.State {
+	case models.LogJobStateDone, models.LogJobStateFailed:
+		// A duplicate of a message that's already been handled
+		_ = msg.Ack()
+		return
+	case models.LogJobStatePending:
+		if wait := time.Until(job.RunAfter); wait > 0 {
+			_ = msg.NakWithDelay(wait)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jetStreamTimeout)
+	defer cancel()
+	lockKey := job.Device.DongleID
+	revision, err := q.jetStream.locks.Create(ctx, lockKey, []byte(q.jetStream.owner))
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			slog.Error("Error locking dongle for log parsing", "dongle_id", lockKey, "err", err)
+		}
+		_ = msg.NakWithDelay(q.jetStream.lockedDelay)
+		return
+	}
+
+	// Running jobs hold their dongle's lock, so a running job we could lock
+	// for was left behind by an instance that died
+	claimed, err := models.ClaimLogJob(q.db, &job)
+	if err != nil || !claimed {
+		if err != nil {
+			slog.Error("Error claiming log job", "job", job.ID, "err", err)
+			_ = msg.NakWithDelay(q.jetStream.lockedDelay)
+		} else {
+			_ = msg.Ack()
+		}
+		q.unlock(lockKey, revision)
+		return
+	}
+
+	work := newWork(job)
+	q.activeJobs.Store(work.dongleID, work)
+	q.activeJobsCount.Inc()
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		done := make(chan any)
+		revisions := make(chan uint64, 1)
+		go func() {
+			revisions <- q.heartbeat(msg, lockKey, revision, done)
+		}()
+
+		retry, backoff := q.run(work)
+		close(done)
+		if retry {
+			_ = msg.NakWithDelay(backoff)
+		} else {
+			_ = msg.Ack()
+		}
+		q.unlock(lockKey, <-revisions)
+
+		q.activeJobs.Delete(work.dongleID)
+		q.activeJobsCount.Dec()
+		// A parser is free, so more work can be fetched
+		q.wake()
+	}()
+}
+
+// heartbeat keeps a running job's lock and message from expiring until done
+// is closed. It returns the lock's last revision.
+func (q *LogQueue) heartbeat(msg jetstream.Msg, lockKey string, revision uint64, done chan any) uint64 {
+	ticker := time.NewTicker(jetStreamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return revision
+		case <-ticker.C:
+			if err := msg.InProgress(); err != nil {
+				slog.Warn("Error extending log job message", "err", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), jetStreamTimeout)
+			next, err := q.jetStream.locks.Update(ctx, lockKey, []byte(q.jetStream.owner), revision)
+			cancel()
+			if err != nil {
+				slog.Warn("Error extending dongle lock", "dongle_id", lockKey, "err", err)
+				continue
+			}
+			revision = next
+		}
+	}
+}
+
+// unlock releases a dongle's lock, provided it's still at the revision we
+// last wrote. A lock that expired may have been taken by another instance
+// since, which has to keep it.
+func (q *LogQueue) unlock(lockKey string, revision uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), jetStreamTimeout)
+	defer cancel()
+	err := q.jetStream.locks.Delete(ctx, lockKey, jetstream.LastRevision(revision))
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
+		slog.Warn("Dongle lock was lost while parsing", "dongle_id", lockKey)
+		return
+	}
+	if err != nil {
+		slog.Error("Error unlocking dongle for log parsing", "dongle_id", lockKey, "err", err)
+	}
+}
@@ -0,0 +1,182 @@
+package logparser
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"gorm.io/gorm"
+)
+
+func newJetStreamServer(t *testing.T) *server.Server {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server didn't start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// concurrency tracks how many logs of each dongle are parsed at once
+type concurrency struct {
+	mu      sync.Mutex
+	running map[string]int
+	max     map[string]int
+}
+
+func (c *concurrency) process(_ *gorm.DB, _ storage.Storage, work work) error {
+	c.mu.Lock()
+	c.running[work.dongleID]++
+	c.max[work.dongleID] = max(c.max[work.dongleID], c.running[work.dongleID])
+	c.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running[work.dongleID]--
+	c.mu.Unlock()
+	return nil
+}
+
+func TestJetStreamSerializesDongles(t *testing.T) {
+	t.Parallel()
+	srv := newJetStreamServer(t)
+	database := testutil.NewDB(t)
+
+	devices := []models.Device{
+		{DongleID: "0000000000000001", PublicKey: "key1", Serial: "1"},
+		{DongleID: "0000000000000002", PublicKey: "key2", Serial: "2"},
+	}
+	for i := range devices {
+		if err := database.Create(&devices[i]).Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tracker := &concurrency{running: map[string]int{}, max: map[string]int{}}
+	cfg := &config.Config{ParallelLogParsers: 4}
+
+	// Two instances sharing a database and NATS
+	var queues []*LogQueue
+	for range 2 {
+		nc, err := nats.Connect(srv.ClientURL())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(nc.Close)
+		q := NewLogQueue(cfg, database, nil, nil)
+		q.process = tracker.process
+		if err := q.UseJetStream(nc); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		q.jetStream.lockedDelay = 10 * time.Millisecond
+		queues = append(queues, q)
+	}
+
+	const segments = 5
+	for _, device := range devices {
+		for segment := range segments {
+			routeInfo := v1dot4.RouteInfo{Motonic: "00000001", Route: "abcdef0123", Segment: strconv.Itoa(segment)}
+			path := "00000001--abcdef0123--" + routeInfo.Segment + "/qlog.zst"
+			if err := queues[segment%2].AddLog(device, path, routeInfo); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	for _, q := range queues {
+		go q.Start()
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		done, err := models.CountLogJobsByState(database, models.LogJobStateDone)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if done == int64(len(devices)*segments) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d jobs to be done, got %d", len(devices)*segments, done)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, q := range queues {
+		q.Stop()
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	for _, device := range devices {
+		if tracker.max[device.DongleID] != 1 {
+			t.Errorf("expected logs of %s to be parsed one at a time, got %d at once", device.DongleID, tracker.max[device.DongleID])
+		}
+	}
+}
+
+func TestUnlockKeepsAnotherInstancesLock(t *testing.T) {
+	t.Parallel()
+	srv := newJetStreamServer(t)
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	q := NewLogQueue(&config.Config{ParallelLogParsers: 1}, testutil.NewDB(t), nil, nil)
+	if err := q.UseJetStream(nc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	locks := q.jetStream.locks
+
+	const lockKey = "0000000000000001"
+	ctx := context.Background()
+	revision, err := locks.Create(ctx, lockKey, []byte(q.jetStream.owner))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The lock expires while its heartbeats fail and another instance takes it
+	if err := locks.Delete(ctx, lockKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	taken, err := locks.Create(ctx, lockKey, []byte("other"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	q.unlock(lockKey, revision)
+	entry, err := locks.Get(ctx, lockKey)
+	if err != nil {
+		t.Fatalf("expected the other instance to keep its lock, got %v", err)
+	}
+	if string(entry.Value()) != "other" {
+		t.Fatalf("expected the lock to be held by the other instance, got %q", entry.Value())
+	}
+
+	// The instance holding the lock can release it
+	q.unlock(lockKey, taken)
+	if _, err := locks.Get(ctx, lockKey); !errors.Is(err, jetstream.ErrKeyNotFound) {
+		t.Fatalf("expected the lock to be released, got %v", err)
+	}
+}
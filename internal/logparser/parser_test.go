@@ -0,0 +1,131 @@
+package logparser_test
+
+import (
+	"bytes"
+	"testing"
+
+	"capnproto.org/go/capnp/v3"
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+)
+
+type event struct {
+	logMonoTime uint64
+	fill        func(cereal.Event) error
+}
+
+// encodeLog serializes events into an uncompressed log as openpilot writes them
+func encodeLog(t *testing.T, events ...event) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := capnp.NewEncoder(&buf)
+	for _, e := range events {
+		msg, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ev, err := cereal.NewRootEvent(seg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ev.SetLogMonoTime(e.logMonoTime)
+		ev.SetValid(true)
+		if err := e.fill(ev); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := encoder.Encode(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return &buf
+}
+
+func initData(version string) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		initData, err := ev.NewInitData()
+		if err != nil {
+			return err
+		}
+		return initData.SetVersion(version)
+	}
+}
+
+func clocks(wallTimeNanos uint64) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		clocks, err := ev.NewClocks()
+		if err != nil {
+			return err
+		}
+		clocks.SetWallTimeNanos(wallTimeNanos)
+		return nil
+	}
+}
+
+func sentinel(sentinelType cereal.Sentinel_SentinelType) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		sentinel, err := ev.NewSentinel()
+		if err != nil {
+			return err
+		}
+		sentinel.SetType(sentinelType)
+		return nil
+	}
+}
+
+func userFlag() func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		_, err := ev.NewUserFlag()
+		return err
+	}
+}
+
+// countingExtractor counts the user flags in a log
+type countingExtractor struct {
+	times []uint64
+}
+
+func (e *countingExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{cereal.Event_Which_userFlag}
+}
+
+func (e *countingExtractor) Handle(event cereal.Event) error {
+	e.times = append(e.times, event.LogMonoTime())
+	return nil
+}
+
+func TestDecodeSegmentData(t *testing.T) {
+	t.Parallel()
+	log := encodeLog(t,
+		event{100, initData("0.9.7")},
+		event{200, clocks(1_700_000_000_000_000_000)},
+		event{300, userFlag()},
+		event{400, sentinel(cereal.Sentinel_SentinelType_endOfRoute)},
+		event{500, userFlag()},
+	)
+
+	extractor := &countingExtractor{}
+	data, err := logparser.DecodeSegmentData(log, extractor)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if data.Version != "0.9.7" {
+		t.Errorf("expected version %q, got %q", "0.9.7", data.Version)
+	}
+	if data.InitLogMonoTime != 100 {
+		t.Errorf("expected init log mono time 100, got %d", data.InitLogMonoTime)
+	}
+	if data.FirstClockWallTimeNanos != 1_700_000_000_000_000_000 || data.FirstClockLogMonoTime != 200 {
+		t.Errorf("unexpected first clock: %d at %d", data.FirstClockWallTimeNanos, data.FirstClockLogMonoTime)
+	}
+	if !data.EndOfRoute {
+		t.Error("expected end of route")
+	}
+	if data.EndLogMonoTime != 500 {
+		t.Errorf("expected end log mono time 500, got %d", data.EndLogMonoTime)
+	}
+
+	if len(extractor.times) != 2 || extractor.times[0] != 300 || extractor.times[1] != 500 {
+		t.Errorf("expected the extractor to see user flags at 300 and 500, got %v", extractor.times)
+	}
+}
@@ -0,0 +1,239 @@
+package logparser
+
+import (
+	"math"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+)
+
+// PositionSource names the event a segment's positions were read from
+type PositionSource string
+
+const (
+	// PositionSourceLiveLocationKalman is the fused localizer of openpilot 0.9.x and earlier
+	PositionSourceLiveLocationKalman PositionSource = "liveLocationKalman"
+	// PositionSourceGPSLocationExternal is the u-blox GPS of devices that have one
+	PositionSourceGPSLocationExternal PositionSource = "gpsLocationExternal"
+	// PositionSourceGPSLocation is the Qualcomm GPS
+	PositionSourceGPSLocation PositionSource = "gpsLocation"
+	// PositionSourceLivePose is the localizer that replaced liveLocationKalman.
+	// It only knows the device's velocity, so it gives a distance but no positions.
+	PositionSourceLivePose PositionSource = "livePose"
+)
+
+const (
+	// WGS84 ellipsoid
+	wgs84A  = 6378137.0
+	wgs84E2 = 6.69437999014e-3
+
+	// maxLivePoseGap is the longest gap between livePose events that's
+	// integrated over, so a dropped stretch of log isn't counted as driving
+	maxLivePoseGap = uint64(time.Second)
+)
+
+type Position struct {
+	LogMonoTime uint64
+	X           float64 // ECEF X coordinate in meters
+	Y           float64 // ECEF Y coordinate in meters
+	Z           float64 // ECEF Z coordinate in meters
+	Latitude    float64 // Geodetic latitude in degrees
+	Longitude   float64 // Geodetic longitude in degrees
+	Speed       float64 // Speed in meters per second
+	Distance    float64 // Distance travelled since the start of the segment in meters
+	Valid       bool
+}
+
+// calculateECEFDistance calculates the Euclidean distance between two ECEF positions in meters
+func calculateECEFDistance(pos1, pos2 Position) float64 {
+	dx := pos2.X - pos1.X
+	dy := pos2.Y - pos1.Y
+	dz := pos2.Z - pos1.Z
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// geodeticToECEF converts a GPS fix in degrees and meters to ECEF coordinates
+func geodeticToECEF(lat, lon, alt float64) (x, y, z float64) {
+	phi := lat * math.Pi / 180
+	lambda := lon * math.Pi / 180
+	sinPhi := math.Sin(phi)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinPhi*sinPhi)
+	x = (n + alt) * math.Cos(phi) * math.Cos(lambda)
+	y = (n + alt) * math.Cos(phi) * math.Sin(lambda)
+	z = (n*(1-wgs84E2) + alt) * sinPhi
+	return x, y, z
+}
+
+// track is a sequence of positions and the distance along them
+type track struct {
+	positions []Position
+	distance  float64
+}
+
+func (t *track) add(position Position) {
+	if len(t.positions) > 0 {
+		t.distance += calculateECEFDistance(t.positions[len(t.positions)-1], position)
+	}
+	position.Distance = t.distance
+	t.positions = append(t.positions, position)
+}
+
+// choosePositions fills in the segment's positions from the best source the
+// log contains. Older openpilot logs liveLocationKalman, newer ones only have
+// the raw GPS and livePose.
+func (s *SegmentData) choosePositions(kalman *kalmanExtractor, gpsExternal, gps *gpsExtractor, livePose *livePoseExtractor) {
+	candidates := []struct {
+		source PositionSource
+		track  track
+	}{
+		{PositionSourceLiveLocationKalman, kalman.track},
+		{PositionSourceGPSLocationExternal, gpsExternal.track},
+		{PositionSourceGPSLocation, gps.track},
+	}
+	for _, candidate := range candidates {
+		if len(candidate.track.positions) > 0 {
+			s.Positions = candidate.track.positions
+			s.TotalDistance = candidate.track.distance
+			s.PositionSource = candidate.source
+			return
+		}
+	}
+	if livePose.seen {
+		s.TotalDistance = livePose.distance
+		s.PositionSource = PositionSourceLivePose
+	}
+}
+
+// kalmanExtractor tracks the device's position and distance travelled from liveLocationKalman
+type kalmanExtractor struct {
+	track track
+}
+
+func (e *kalmanExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{cereal.Event_Which_liveLocationKalman}
+}
+
+func (e *kalmanExtractor) Handle(event cereal.Event) error {
+	liveLocation, err := event.LiveLocationKalman()
+	if err != nil {
+		return err
+	}
+
+	positionECEF, err := liveLocation.PositionECEF()
+	if err != nil || !positionECEF.Valid() {
+		return nil
+	}
+	values, err := positionECEF.Value()
+	if err != nil || values.Len() < 3 {
+		return nil
+	}
+	positionGeodetic, err := liveLocation.PositionGeodetic()
+	var lat, lon float64
+	if err == nil && positionGeodetic.Valid() {
+		geoValues, err := positionGeodetic.Value()
+		if err == nil && geoValues.Len() >= 2 {
+			lat = geoValues.At(0) * 180.0 / math.Pi // Convert radians to degrees
+			lon = geoValues.At(1) * 180.0 / math.Pi // Convert radians to degrees
+		}
+	}
+
+	var speed float64
+	velocity, err := liveLocation.VelocityCalibrated()
+	if err == nil && velocity.Valid() {
+		velocityValues, err := velocity.Value()
+		if err == nil {
+			for i := 0; i < velocityValues.Len(); i++ {
+				speed += velocityValues.At(i) * velocityValues.At(i)
+			}
+			speed = math.Sqrt(speed)
+		}
+	}
+
+	e.track.add(Position{
+		LogMonoTime: event.LogMonoTime(),
+		X:           values.At(0),
+		Y:           values.At(1),
+		Z:           values.At(2),
+		Latitude:    lat,
+		Longitude:   lon,
+		Speed:       speed,
+		Valid:       true,
+	})
+	return nil
+}
+
+// gpsExtractor tracks the device's position and distance travelled from one of the GPS events
+type gpsExtractor struct {
+	which cereal.Event_Which
+	track track
+}
+
+func (e *gpsExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{e.which}
+}
+
+func (e *gpsExtractor) Handle(event cereal.Event) error {
+	var gps cereal.GpsLocationData
+	var err error
+	if e.which == cereal.Event_Which_gpsLocationExternal {
+		gps, err = event.GpsLocationExternal()
+	} else {
+		gps, err = event.GpsLocation()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Older openpilot only sets bit 0 of flags when there's a fix
+	if !gps.HasFix() && gps.Flags()&1 == 0 {
+		return nil
+	}
+	if gps.Latitude() == 0 && gps.Longitude() == 0 {
+		return nil
+	}
+
+	x, y, z := geodeticToECEF(gps.Latitude(), gps.Longitude(), gps.Altitude())
+	e.track.add(Position{
+		LogMonoTime: event.LogMonoTime(),
+		X:           x,
+		Y:           y,
+		Z:           z,
+		Latitude:    gps.Latitude(),
+		Longitude:   gps.Longitude(),
+		Speed:       float64(gps.Speed()),
+		Valid:       true,
+	})
+	return nil
+}
+
+// livePoseExtractor integrates the device's speed from livePose into a distance
+type livePoseExtractor struct {
+	seen         bool
+	distance     float64
+	lastMonoTime uint64
+}
+
+func (e *livePoseExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{cereal.Event_Which_livePose}
+}
+
+func (e *livePoseExtractor) Handle(event cereal.Event) error {
+	livePose, err := event.LivePose()
+	if err != nil {
+		return err
+	}
+	velocity, err := livePose.VelocityDevice()
+	if err != nil || !velocity.Valid() {
+		return nil
+	}
+	e.seen = true
+
+	now := event.LogMonoTime()
+	if e.lastMonoTime != 0 && now > e.lastMonoTime && now-e.lastMonoTime <= maxLivePoseGap {
+		vx, vy, vz := float64(velocity.X()), float64(velocity.Y()), float64(velocity.Z())
+		speed := math.Sqrt(vx*vx + vy*vy + vz*vz)
+		e.distance += speed * time.Duration(now-e.lastMonoTime).Seconds()
+	}
+	e.lastMonoTime = now
+	return nil
+}
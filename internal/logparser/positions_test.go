@@ -0,0 +1,206 @@
+package logparser_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+)
+
+func liveLocationKalman(x, y, z, lat, lon float64) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		kalman, err := ev.NewLiveLocationKalman()
+		if err != nil {
+			return err
+		}
+		ecef, err := kalman.NewPositionECEF()
+		if err != nil {
+			return err
+		}
+		ecef.SetValid(true)
+		values, err := ecef.NewValue(3)
+		if err != nil {
+			return err
+		}
+		values.Set(0, x)
+		values.Set(1, y)
+		values.Set(2, z)
+		geodetic, err := kalman.NewPositionGeodetic()
+		if err != nil {
+			return err
+		}
+		geodetic.SetValid(true)
+		values, err = geodetic.NewValue(3)
+		if err != nil {
+			return err
+		}
+		values.Set(0, lat*math.Pi/180)
+		values.Set(1, lon*math.Pi/180)
+		return nil
+	}
+}
+
+type gpsFix struct {
+	external bool
+	lat, lon float64
+	hasFix   bool
+	flags    uint16
+}
+
+func gpsLocation(fix gpsFix) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		var gps cereal.GpsLocationData
+		var err error
+		if fix.external {
+			gps, err = ev.NewGpsLocationExternal()
+		} else {
+			gps, err = ev.NewGpsLocation()
+		}
+		if err != nil {
+			return err
+		}
+		gps.SetLatitude(fix.lat)
+		gps.SetLongitude(fix.lon)
+		gps.SetHasFix(fix.hasFix)
+		gps.SetFlags(fix.flags)
+		return nil
+	}
+}
+
+func livePose(speed float32) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		pose, err := ev.NewLivePose()
+		if err != nil {
+			return err
+		}
+		velocity, err := pose.NewVelocityDevice()
+		if err != nil {
+			return err
+		}
+		velocity.SetX(speed)
+		velocity.SetValid(true)
+		return nil
+	}
+}
+
+func TestPositionSources(t *testing.T) {
+	t.Parallel()
+
+	// 0.001 degrees of latitude at the equator is about 110.6 meters
+	const step = 0.001
+	const stepMeters = 110.6
+
+	tests := []struct {
+		name           string
+		events         []event
+		source         logparser.PositionSource
+		positions      int
+		distanceMeters float64
+		endLat         float64
+	}{
+		{
+			// openpilot 0.9 logs liveLocationKalman alongside the raw GPS
+			name: "liveLocationKalman",
+			events: []event{
+				{100, liveLocationKalman(6378137, 0, 0, 0, 0)},
+				{150, gpsLocation(gpsFix{external: true, lat: 1, lon: 1, hasFix: true})},
+				{200, liveLocationKalman(6378137, 0, 100, step, 0)},
+				{300, liveLocationKalman(6378137, 0, 200, 2*step, 0)},
+			},
+			source:         logparser.PositionSourceLiveLocationKalman,
+			positions:      3,
+			distanceMeters: 200,
+			endLat:         2 * step,
+		},
+		{
+			// Devices with a u-blox GPS on openpilot 0.10 and later
+			name: "gpsLocationExternal",
+			events: []event{
+				{100, gpsLocation(gpsFix{external: true, lat: 0, lon: 0, hasFix: false})},
+				{200, gpsLocation(gpsFix{external: true, lat: step, lon: 0, hasFix: true})},
+				{250, gpsLocation(gpsFix{lat: 1, lon: 1, hasFix: true})},
+				{250, livePose(100)},
+				{300, gpsLocation(gpsFix{external: true, lat: 2 * step, lon: 0, hasFix: true})},
+				{350, livePose(100)},
+				{400, gpsLocation(gpsFix{external: true, lat: 3 * step, lon: 0, hasFix: true})},
+			},
+			source:         logparser.PositionSourceGPSLocationExternal,
+			positions:      3,
+			distanceMeters: 2 * stepMeters,
+			endLat:         3 * step,
+		},
+		{
+			// The comma 3X only has the Qualcomm GPS
+			name: "gpsLocation",
+			events: []event{
+				{100, gpsLocation(gpsFix{lat: step, lon: step, hasFix: false})},
+				{200, gpsLocation(gpsFix{lat: 0, lon: step, hasFix: true})},
+				{250, livePose(10)},
+				{300, gpsLocation(gpsFix{lat: step, lon: step, hasFix: true})},
+			},
+			source:         logparser.PositionSourceGPSLocation,
+			positions:      2,
+			distanceMeters: stepMeters,
+			endLat:         step,
+		},
+		{
+			// Before hasFix, a fix was bit 0 of flags
+			name: "gpsLocation flags",
+			events: []event{
+				{100, gpsLocation(gpsFix{lat: step, lon: step, flags: 0})},
+				{200, gpsLocation(gpsFix{lat: 0, lon: step, flags: 1})},
+				{300, gpsLocation(gpsFix{lat: step, lon: step, flags: 1})},
+			},
+			source:         logparser.PositionSourceGPSLocation,
+			positions:      2,
+			distanceMeters: stepMeters,
+			endLat:         step,
+		},
+		{
+			// Newer openpilot without a GPS fix only knows how fast it went
+			name: "livePose",
+			events: []event{
+				{1_000_000_000, livePose(10)},
+				{1_500_000_000, livePose(10)},
+				{2_000_000_000, livePose(20)},
+				// Gaps longer than a second aren't counted
+				{5_000_000_000, livePose(20)},
+				{5_250_000_000, livePose(20)},
+			},
+			source:         logparser.PositionSourceLivePose,
+			distanceMeters: 10*0.5 + 20*0.5 + 20*0.25,
+		},
+		{
+			name: "none",
+			events: []event{
+				{100, initData("0.9.7")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := logparser.DecodeSegmentData(encodeLog(t, tt.events...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data.PositionSource != tt.source {
+				t.Errorf("expected position source %q, got %q", tt.source, data.PositionSource)
+			}
+			if len(data.Positions) != tt.positions {
+				t.Errorf("expected %d positions, got %d", tt.positions, len(data.Positions))
+			}
+			if math.Abs(data.TotalDistance-tt.distanceMeters) > 1 {
+				t.Errorf("expected a distance of %.1fm, got %.1fm", tt.distanceMeters, data.TotalDistance)
+			}
+			if len(data.Positions) > 0 {
+				last := data.Positions[len(data.Positions)-1]
+				if math.Abs(last.Latitude-tt.endLat) > 1e-9 {
+					t.Errorf("expected to end at latitude %f, got %f", tt.endLat, last.Latitude)
+				}
+			}
+		})
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"maps"
 	"math"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/USA-RedDragon/rtz-server/internal/config"
@@ -20,76 +25,280 @@ import (
 	"gorm.io/gorm"
 )
 
-const QueueDepth = 100
+const (
+	// PollInterval is how often the job table is checked for work that wasn't signalled, like retries
+	PollInterval = 5 * time.Second
+	// MaxAttempts is how many times a log is parsed before its job is marked failed
+	MaxAttempts = 5
+	// RetryBackoff is the delay before the first retry, doubling with each attempt after
+	RetryBackoff = 30 * time.Second
+	// MaxRetryBackoff caps the delay between retries
+	MaxRetryBackoff = 30 * time.Minute
+	// JobHeartbeat is how often a running job's heartbeat is refreshed
+	JobHeartbeat = 30 * time.Second
+	// JobLease is how long a running job can go without a heartbeat before
+	// it's assumed its instance died and it's queued again
+	JobLease = 2 * time.Minute
+)
 
+// LogQueue parses uploaded logs from jobs persisted in the database, so
+// queued work survives restarts. Only one log per device is parsed at a time.
 type LogQueue struct {
 	config          *config.Config
 	db              *gorm.DB
 	storage         storage.Storage
-	queue           chan work
+	wakeChan        chan any
+	stopChan        chan any
 	closeChan       chan any
 	metrics         *metrics.Metrics
 	activeJobsCount *xsync.Counter
 	activeJobs      *xsync.MapOf[string, *work]
+	wg              sync.WaitGroup
+	// jetStream is set when parsing is shared between instances
+	jetStream *jetStreamQueue
+	// process is processLog, swapped out by tests
+	process func(db *gorm.DB, storage storage.Storage, work work) error
 }
 
 type work struct {
+	jobID     uint
+	attempts  int
 	path      string
 	dongleID  string
 	routeInfo v1dot4.RouteInfo
 }
 
+func newWork(job models.LogJob) *work {
+	return &work{
+		jobID:    job.ID,
+		attempts: job.Attempts,
+		path:     job.Path,
+		dongleID: job.Device.DongleID,
+		routeInfo: v1dot4.RouteInfo{
+			Motonic: job.Motonic,
+			Route:   job.Route,
+			Segment: job.Segment,
+		},
+	}
+}
+
 func NewLogQueue(config *config.Config, db *gorm.DB, storage storage.Storage, metrics *metrics.Metrics) *LogQueue {
-	return &LogQueue{
+	q := &LogQueue{
 		config:          config,
 		db:              db,
-		queue:           make(chan work, QueueDepth),
+		wakeChan:        make(chan any, 1),
+		stopChan:        make(chan any),
 		closeChan:       make(chan any),
 		metrics:         metrics,
 		activeJobsCount: xsync.NewCounter(),
 		activeJobs:      xsync.NewMapOf[string, *work](),
 		storage:         storage,
 	}
+	q.process = q.processLog
+	return q
 }
 
 func (q *LogQueue) Start() {
-	for work := range q.queue {
-		_, ok := q.activeJobs.Load(work.dongleID)
-		if ok {
+	if q.jetStream != nil {
+		q.consume()
+	} else {
+		q.poll()
+	}
+	q.wg.Wait()
+	q.closeChan <- struct{}{}
+}
+
+// poll runs jobs straight from the database, for when parsing isn't shared over JetStream
+func (q *LogQueue) poll() {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		q.recoverJobs()
+		q.schedule()
+		select {
+		case <-q.stopChan:
+			return
+		case <-q.wakeChan:
+		case <-ticker.C:
+		}
+	}
+}
+
+// recoverJobs queues jobs again whose lease ran out. Jobs other instances are
+// still running keep their heartbeat fresh and are left alone.
+func (q *LogQueue) recoverJobs() {
+	recovered, err := models.RecoverLogJobs(q.db, time.Now().Add(-JobLease))
+	if err != nil {
+		slog.Error("Error recovering log jobs", "err", err)
+	} else if recovered > 0 {
+		slog.Info("Recovered interrupted log jobs", "count", recovered)
+	}
+}
+
+func (q *LogQueue) Stop() {
+	close(q.stopChan)
+	<-q.closeChan
+}
+
+// AddLog persists a job to parse a log and wakes the queue to pick it up
+func (q *LogQueue) AddLog(device models.Device, path string, routeInfo v1dot4.RouteInfo) error {
+	_, err := q.addLog(device, path, routeInfo)
+	return err
+}
+
+func (q *LogQueue) addLog(device models.Device, path string, routeInfo v1dot4.RouteInfo) (uint, error) {
+	job := models.LogJob{
+		DeviceID: device.ID,
+		Path:     path,
+		Motonic:  routeInfo.Motonic,
+		Route:    routeInfo.Route,
+		Segment:  routeInfo.Segment,
+	}
+	err := models.EnqueueLogJob(q.db, &job)
+	if err != nil {
+		return 0, err
+	}
+	q.dispatch(job)
+	return job.ID, nil
+}
+
+// Retry queues a failed job again. It reports false if the job hadn't failed.
+func (q *LogQueue) Retry(id uint) (bool, error) {
+	retried, err := models.RetryLogJob(q.db, id)
+	if err != nil || !retried {
+		return retried, err
+	}
+	job, err := models.FindLogJobByID(q.db, id)
+	if err != nil {
+		return true, err
+	}
+	q.dispatch(job)
+	return true, nil
+}
+
+// dispatch lets the parsers know a job is ready to run
+func (q *LogQueue) dispatch(job models.LogJob) {
+	if q.jetStream != nil {
+		// Jobs that fail to publish stay pending and are published again on startup
+		err := q.publish(job)
+		if err != nil {
+			slog.Error("Error publishing log job", "job", job.ID, "err", err)
+		}
+		return
+	}
+	q.wake()
+}
+
+func (q *LogQueue) wake() {
+	select {
+	case q.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// schedule starts as many runnable jobs as there are free parsers
+func (q *LogQueue) schedule() {
+	defer q.updateMetrics()
+
+	free := int(q.config.ParallelLogParsers) - int(q.activeJobsCount.Value())
+	if free <= 0 {
+		return
+	}
+	jobs, err := models.ListRunnableLogJobs(q.db, free)
+	if err != nil {
+		slog.Error("Error listing log jobs", "err", err)
+		return
+	}
+	for _, job := range jobs {
+		if _, ok := q.activeJobs.Load(job.Device.DongleID); ok {
 			// If we already have a job for this dongle, we can't start another one
-			q.queue <- work
+			continue
+		}
+		claimed, err := models.ClaimLogJob(q.db, &job)
+		if err != nil {
+			slog.Error("Error claiming log job", "job", job.ID, "err", err)
+			continue
+		}
+		if !claimed {
 			continue
 		}
 
-		if uint(q.activeJobsCount.Value()) < q.config.ParallelLogParsers {
-			q.activeJobsCount.Inc()
-			go func() {
-				q.activeJobs.Store(work.dongleID, &work)
-				err := q.processLog(q.db, q.storage, work)
-				if err != nil {
-					slog.Error("Error processing log", "log", work.path, "err", err)
-				}
-				q.activeJobs.Delete(work.dongleID)
-				q.activeJobsCount.Dec()
-			}()
-		} else {
-			q.queue <- work
+		work := newWork(job)
+		q.activeJobs.Store(work.dongleID, work)
+		q.activeJobsCount.Inc()
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			_, _ = q.run(work)
+			q.activeJobs.Delete(work.dongleID)
+			q.activeJobsCount.Dec()
+			// A parser is free, so more work can start
+			q.wake()
+		}()
+	}
+}
+
+// run parses a claimed job's log and records the outcome. When it fails it
+// reports whether the job should be retried and after how long.
+func (q *LogQueue) run(work *work) (bool, time.Duration) {
+	done := make(chan any)
+	go q.heartbeatJob(work.jobID, done)
+	err := q.process(q.db, q.storage, *work)
+	close(done)
+	if err == nil {
+		err = models.CompleteLogJob(q.db, work.jobID)
+		if err != nil {
+			slog.Error("Error completing log job", "job", work.jobID, "err", err)
 		}
-		if q.metrics != nil {
-			q.metrics.SetLogParserActiveJobs(float64(q.activeJobsCount.Value()))
-			q.metrics.SetLogParserQueueSize(float64(len(q.queue)))
+		return false, 0
+	}
+
+	slog.Error("Error processing log", "log", work.path, "attempt", work.attempts, "err", err)
+	retry := work.attempts < MaxAttempts
+	backoff := retryBackoff(work.attempts)
+	err = models.FailLogJob(q.db, work.jobID, err, retry, backoff)
+	if err != nil {
+		slog.Error("Error failing log job", "job", work.jobID, "err", err)
+	}
+	return retry, backoff
+}
+
+// heartbeatJob keeps a running job's lease from expiring until done is closed
+func (q *LogQueue) heartbeatJob(id uint, done chan any) {
+	ticker := time.NewTicker(JobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := models.HeartbeatLogJob(q.db, id); err != nil {
+				slog.Warn("Error refreshing log job heartbeat", "job", id, "err", err)
+			}
 		}
 	}
-	q.closeChan <- struct{}{}
 }
 
-func (q *LogQueue) Stop() {
-	close(q.queue)
-	<-q.closeChan
+// retryBackoff returns how long to wait before retrying after the given number of attempts
+func retryBackoff(attempts int) time.Duration {
+	backoff := RetryBackoff
+	for i := 1; i < attempts && backoff < MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, MaxRetryBackoff)
 }
 
-func (q *LogQueue) AddLog(path string, dongleID string, routeInfo v1dot4.RouteInfo) {
-	q.queue <- work{path: path, dongleID: dongleID, routeInfo: routeInfo}
+func (q *LogQueue) updateMetrics() {
+	if q.metrics == nil {
+		return
+	}
+	q.metrics.SetLogParserActiveJobs(float64(q.activeJobsCount.Value()))
+	pending, err := models.CountLogJobsByState(q.db, models.LogJobStatePending)
+	if err != nil {
+		slog.Error("Error counting pending log jobs", "err", err)
+		return
+	}
+	q.metrics.SetLogParserQueueSize(float64(pending))
 }
 
 func (q *LogQueue) processLog(db *gorm.DB, storage storage.Storage, work work) error {
@@ -127,6 +336,9 @@ func (q *LogQueue) processLog(db *gorm.DB, storage storage.Storage, work work) e
 		}
 	case ".bz2":
 		decompressedReader = bzip2.NewReader(bufReader)
+	case "":
+		// Uncompressed log
+		decompressedReader = bufReader
 	default:
 		if q.metrics != nil {
 			q.metrics.IncrementLogParserErrors(work.dongleID, "unsupported_file_extension")
@@ -135,7 +347,12 @@ func (q *LogQueue) processLog(db *gorm.DB, storage storage.Storage, work work) e
 		return errors.New("unsupported file extension")
 	}
 
-	segmentData, err := DecodeSegmentData(decompressedReader)
+	extractors := newExtractors()
+	extra := make([]Extractor, 0, len(extractors))
+	for _, extractor := range extractors {
+		extra = append(extra, extractor)
+	}
+	segmentData, err := DecodeSegmentData(decompressedReader, extra...)
 	if err != nil {
 		if q.metrics != nil {
 			q.metrics.IncrementLogParserErrors(work.dongleID, "decode_segment_data")
@@ -144,21 +361,22 @@ func (q *LogQueue) processLog(db *gorm.DB, storage storage.Storage, work work) e
 		return err
 	}
 
+	segmentNumber, err := strconv.Atoi(work.routeInfo.Segment)
+	if err != nil {
+		if q.metrics != nil {
+			q.metrics.IncrementLogParserErrors(work.dongleID, "parse_segment_number")
+		}
+		slog.Error("Error parsing segment number", "segment", work.routeInfo.Segment, "err", err)
+		return err
+	}
+
 	// We need to associate a segment with a route...
 	route, err := models.FindRouteForSegment(db, device.ID, work.routeInfo)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			route = models.Route{
-				DeviceID:        device.ID,
-				RouteID:         work.routeInfo.Route,
-				GitBranch:       segmentData.GitBranch,
-				GitRemote:       segmentData.GitRemote,
-				GitDirty:        segmentData.GitDirty,
-				GitCommit:       segmentData.GitCommit,
-				InitLogMonoTime: segmentData.InitLogMonoTime,
-				Platform:        segmentData.CarModel,
-				Radar:           true,
-				Version:         segmentData.Version,
+				DeviceID: device.ID,
+				RouteID:  work.routeInfo.Route,
 			}
 		} else {
 			if q.metrics != nil {
@@ -169,48 +387,139 @@ func (q *LogQueue) processLog(db *gorm.DB, storage storage.Storage, work work) e
 		}
 	}
 
+	// Routes are created empty when their first file is uploaded, and the
+	// first segment's init time is the route's
+	if route.InitLogMonoTime == 0 || segmentNumber == 0 {
+		route.GitBranch = segmentData.GitBranch
+		route.GitRemote = segmentData.GitRemote
+		route.GitDirty = segmentData.GitDirty
+		route.GitCommit = segmentData.GitCommit
+		route.InitLogMonoTime = segmentData.InitLogMonoTime
+		route.Platform = segmentData.CarModel
+		route.Version = segmentData.Version
+	}
+
 	if route.FirstClockLogMonoTime == 0 && segmentData.FirstClockLogMonoTime != 0 {
 		route.FirstClockLogMonoTime = segmentData.FirstClockLogMonoTime
 	}
 	if route.FirstClockWallTimeNanos == 0 && segmentData.FirstClockWallTimeNanos != 0 {
 		route.FirstClockWallTimeNanos = segmentData.FirstClockWallTimeNanos
 	}
-	if route.StartTime.IsZero() && segmentData.InitLogMonoTime != 0 {
-		wallTime := route.GetWallTimeFromBootTime(segmentData.InitLogMonoTime)
-		if wallTime > 0 && wallTime <= math.MaxInt64 {
-			route.StartTime = time.Unix(0, int64(wallTime))
+
+	// The route needs an ID before its segments can reference it
+	if route.ID == 0 {
+		err = db.Create(&route).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	stats := models.Segment{
+		RouteID:    route.ID,
+		Number:     segmentNumber,
+		StartTime:  wallTime(route, segmentData.InitLogMonoTime),
+		EndTime:    wallTime(route, segmentData.EndLogMonoTime),
+		EndOfRoute: segmentData.EndOfRoute,
+		// Convert from meters to miles (1 meter = 0.000621371 miles)
+		Length:         segmentData.TotalDistance * 0.000621371,
+		PositionSource: string(segmentData.PositionSource),
+	}
+	if len(segmentData.Positions) > 0 {
+		firstPos := segmentData.Positions[0]
+		lastPos := segmentData.Positions[len(segmentData.Positions)-1]
+		stats.StartLat, stats.StartLng = firstPos.Latitude, firstPos.Longitude
+		stats.EndLat, stats.EndLng = lastPos.Latitude, lastPos.Longitude
+	}
+
+	file := models.SegmentFileQLog
+	if strings.HasPrefix(filepath.Base(work.path), "rlog") {
+		file = models.SegmentFileRLog
+	}
+	// The days the segment and route were counted for before, whose stats may change
+	var statsDays []int64
+	if !route.StartTime.IsZero() {
+		statsDays = append(statsDays, route.StartTime.UnixNano())
+	}
+	previous, err := models.FindSegmentByRouteIDAndNumber(db, route.ID, segmentNumber)
+	if err == nil {
+		statsDays = append(statsDays, previous.StartTime)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	applied, err := models.RecordSegmentProcessed(db, stats, file)
+	if err != nil {
+		if q.metrics != nil {
+			q.metrics.IncrementLogParserErrors(work.dongleID, "record_segment")
 		}
+		slog.Error("Error recording processed segment", "err", err)
+		return err
 	}
 
-	if route.StartLat == 0 && route.StartLng == 0 && len(segmentData.KalmanPositions) > 0 {
-		route.StartLat = segmentData.KalmanPositions[0].Latitude
-		route.StartLng = segmentData.KalmanPositions[0].Longitude
+	segments, err := models.FindSegmentsByRouteID(db, route.ID)
+	if err != nil {
+		return err
 	}
+	route.Summarize(segments)
 
-	route.SegmentStartTimes = append(route.SegmentStartTimes, int64(route.GetWallTimeFromBootTime(segmentData.InitLogMonoTime)))
-	route.SegmentEndTimes = append(route.SegmentEndTimes, int64(route.GetWallTimeFromBootTime(segmentData.EndLogMonoTime)))
-	nextSegmentNum := len(route.SegmentNumbers) + 1
-	if nextSegmentNum >= 0 {
-		route.SegmentNumbers = append(route.SegmentNumbers, int64(nextSegmentNum))
+	err = db.Save(&route).Error
+	if err != nil {
+		return err
 	}
 
-	// Accumulate distance from this segment using Kalman-filtered positions
-	// This provides accurate distance tracking using IMU-fused GPS data
-	// Convert from meters to miles (1 meter = 0.000621371 miles)
-	if segmentData.TotalDistance > 0 {
-		route.Length += segmentData.TotalDistance * 0.000621371
+	// An rlog has every event a qlog has, so what was extracted from one
+	// isn't replaced with what's in the segment's qlog
+	if !applied {
+		slog.Debug("Segment's rlog has already been parsed, skipping qlog", "dongle_id", work.dongleID, "path", work.path)
+		return nil
 	}
 
-	if segmentData.EndOfRoute {
-		if len(segmentData.KalmanPositions) > 0 {
-			lastPos := segmentData.KalmanPositions[len(segmentData.KalmanPositions)-1]
-			route.EndLat = lastPos.Latitude
-			route.EndLng = lastPos.Longitude
+	segment := SegmentContext{
+		Device:  device,
+		Route:   route,
+		Number:  segmentNumber,
+		File:    file,
+		Data:    segmentData,
+		Storage: storage,
+		Dir:     filepath.Join(work.dongleID, filepath.Dir(work.path)),
+	}
+	for _, name := range slices.Sorted(maps.Keys(extractors)) {
+		persister, ok := extractors[name].(Persister)
+		if !ok {
+			continue
 		}
-		route.EndTime = time.Unix(0, int64(route.GetWallTimeFromBootTime(segmentData.EndLogMonoTime)))
-		route.AllSegmentsProcessed = true
-		// TODO: URL
+		err = persister.Persist(db, segment)
+		if err != nil {
+			if q.metrics != nil {
+				q.metrics.IncrementLogParserErrors(work.dongleID, "persist_"+name)
+			}
+			slog.Error("Error persisting extracted data", "extractor", name, "err", err)
+			return err
+		}
+	}
+
+	if !route.StartTime.IsZero() {
+		statsDays = append(statsDays, route.StartTime.UnixNano())
+	}
+	statsDays = append(statsDays, stats.StartTime)
+	err = models.RefreshDriveStats(db, device.ID, models.DriveStatsDays(statsDays)...)
+	if err != nil {
+		if q.metrics != nil {
+			q.metrics.IncrementLogParserErrors(work.dongleID, "refresh_drive_stats")
+		}
+		slog.Error("Error refreshing drive stats", "err", err)
+		return err
 	}
+	return nil
+}
 
-	return db.Save(&route).Error
+// wallTime converts a log's monotonic time to nanoseconds since the epoch, or 0 if it's unknown
+func wallTime(route models.Route, logMonoTime uint64) int64 {
+	if logMonoTime == 0 {
+		return 0
+	}
+	wallTime := route.GetWallTimeFromBootTime(logMonoTime)
+	if wallTime == 0 || wallTime > math.MaxInt64 {
+		return 0
+	}
+	return int64(wallTime)
 }
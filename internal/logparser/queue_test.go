@@ -0,0 +1,215 @@
+package logparser_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"gorm.io/gorm"
+)
+
+type testQueue struct {
+	db      *gorm.DB
+	queue   *logparser.LogQueue
+	device  models.Device
+	uploads string
+}
+
+// newTestQueue starts a log queue backed by sqlite and the filesystem
+func newTestQueue(t *testing.T) *testQueue {
+	t.Helper()
+	cfg := testutil.Config(t)
+	cfg.ParallelLogParsers = 1
+	database := testutil.OpenDB(t, cfg)
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	device := testutil.NewDevice(t, database)
+
+	queue := logparser.NewLogQueue(cfg, database, store, nil)
+	go queue.Start()
+	t.Cleanup(queue.Stop)
+
+	return &testQueue{
+		db:      database,
+		queue:   queue,
+		device:  device,
+		uploads: filepath.Join(cfg.Persistence.Uploads.FilesystemOptions.Directory, device.DongleID),
+	}
+}
+
+// upload stores a log for segment 0 of a route and waits for it to be parsed
+func (q *testQueue) upload(t *testing.T, name string, log *bytes.Buffer) {
+	t.Helper()
+	q.uploadRoute(t, "abcdef0123", name, log)
+}
+
+// uploadRoute stores a log for segment 0 of route and waits for it to be parsed
+func (q *testQueue) uploadRoute(t *testing.T, route string, name string, log *bytes.Buffer) {
+	t.Helper()
+	q.uploadSegment(t, route, 0, name, log)
+}
+
+// uploadSegment stores a log for a segment of route and waits for it to be parsed
+func (q *testQueue) uploadSegment(t *testing.T, route string, segment int, name string, log *bytes.Buffer) {
+	t.Helper()
+	routeInfo := v1dot4.RouteInfo{Motonic: "00000001", Route: route, Segment: strconv.Itoa(segment)}
+	path := "00000001--" + route + "--" + routeInfo.Segment + "/" + name
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(q.uploads, path)), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(q.uploads, path), log.Bytes(), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file := models.SegmentFileQLog
+	if name == "rlog" {
+		file = models.SegmentFileRLog
+	}
+	if err := models.RecordSegmentUpload(q.db, q.device.ID, routeInfo.Route, segment, file, int64(log.Len())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.queue.AddLog(q.device, path, routeInfo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		jobs, err := models.ListLogJobs(q.db, "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, job := range jobs {
+			if job.Path != path {
+				continue
+			}
+			switch job.State {
+			case models.LogJobStateDone:
+				return
+			case models.LogJobStateFailed:
+				t.Fatalf("failed to parse %s: %s", name, job.LastError)
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be parsed", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (q *testQueue) segment(t *testing.T) models.Segment {
+	t.Helper()
+	route, err := models.FindRouteByDeviceIDAndRouteID(q.db, q.device.ID, "abcdef0123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	segments, err := models.FindSegmentsByRouteID(q.db, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segments))
+	}
+	return segments[0]
+}
+
+// drive encodes an uncompressed log that travels meters north along the X axis
+func drive(t *testing.T, meters float64) *bytes.Buffer {
+	t.Helper()
+	return encodeLog(t,
+		event{100, initData("0.9.7")},
+		event{200, liveLocationKalman(6378137, 0, 0, 0, 0)},
+		event{300, liveLocationKalman(6378137, 0, meters, 0.001, 0)},
+	)
+}
+
+func TestRLogSupersedesQLog(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	q.upload(t, "qlog", drive(t, 1000))
+	segment := q.segment(t)
+	if !segment.Processed(models.SegmentFileQLog) {
+		t.Error("expected the qlog to be processed")
+	}
+	qlogLength := segment.Length
+	if qlogLength == 0 {
+		t.Fatal("expected the uncompressed qlog to give the segment a length")
+	}
+
+	q.upload(t, "rlog", drive(t, 2000))
+	segment = q.segment(t)
+	if !segment.Processed(models.SegmentFileRLog) {
+		t.Error("expected the rlog to be processed")
+	}
+	if segment.Length != 2*qlogLength {
+		t.Errorf("expected the rlog's length %f, got %f", 2*qlogLength, segment.Length)
+	}
+
+	// Parsing the qlog again doesn't replace what came from the rlog
+	q.upload(t, "qlog", drive(t, 1000))
+	segment = q.segment(t)
+	if !segment.Processed(models.SegmentFileQLog) {
+		t.Error("expected the qlog to be processed")
+	}
+	if segment.Length != 2*qlogLength {
+		t.Errorf("expected the rlog's length %f to be kept, got %f", 2*qlogLength, segment.Length)
+	}
+}
+
+func TestTrackIsStored(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	// Two minutes of positions, ten a second
+	events := []event{
+		{1, initData("0.9.7")},
+		{2, clocks(1_700_000_000_000_000_000)},
+	}
+	const rate = uint64(100_000_000)
+	for i := range uint64(1200) {
+		lat := float64(i+1) * 0.00001
+		events = append(events, event{1_000_000_000 + i*rate, liveLocationKalman(6378137, 0, float64(i), lat, 0)})
+	}
+	q.upload(t, "qlog", encodeLog(t, events...))
+
+	route, err := models.FindRouteByDeviceIDAndRouteID(q.db, q.device.ID, "abcdef0123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	points, err := models.FindTrackPointsByRouteID(q.db, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// One a second, plus the last position
+	if len(points) != 121 {
+		t.Fatalf("expected 121 track points, got %d", len(points))
+	}
+	first, last := points[0], points[len(points)-1]
+	if first.Time != 1_700_000_000_000_000_000-2+1_000_000_000 {
+		t.Errorf("unexpected first point time %d", first.Time)
+	}
+	if last.Distance != 1199 || last.Latitude != 1200*0.00001 {
+		t.Errorf("expected the last point to be the last position, got %+v", last.TrackPoint)
+	}
+
+	// Parsing the log again replaces the track
+	q.upload(t, "qlog", encodeLog(t, events[:12]...))
+	points, err = models.FindTrackPointsByRouteID(q.db, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(points) != 2 {
+		t.Errorf("expected 2 track points, got %d", len(points))
+	}
+}
@@ -0,0 +1,141 @@
+package logparser
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ReprocessScope picks the logs to parse again. Every device's logs are
+// picked when DongleID is empty, and every route's when Route is.
+type ReprocessScope struct {
+	DongleID string
+	// Route is named by its route ID or in full as <counter>--<route id>
+	Route string
+}
+
+// StoredLog is an uploaded log found in storage
+type StoredLog struct {
+	Device models.Device
+	// Path is relative to the device's upload directory
+	Path      string
+	RouteInfo v1dot4.RouteInfo
+}
+
+// FindStoredLogs lists the qlogs and rlogs in storage within scope, ordered by route and segment
+func FindStoredLogs(db *gorm.DB, store storage.Storage, scope ReprocessScope) ([]StoredLog, error) {
+	var devices []models.Device
+	if scope.DongleID != "" {
+		device, err := models.FindDeviceByDongleID(db, scope.DongleID)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	} else {
+		var err error
+		devices, err = models.ListDevices(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var logs []StoredLog
+	for _, device := range devices {
+		deviceLogs, err := findDeviceLogs(store, device, scope.Route)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, deviceLogs...)
+	}
+	return logs, nil
+}
+
+func findDeviceLogs(store storage.Storage, device models.Device, route string) ([]StoredLog, error) {
+	base, err := store.Sub(device.DongleID)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing has been uploaded by the device
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer base.Close()
+
+	segments, err := routefiles.List(base, route)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(segments, func(a, b routefiles.Segment) int {
+		routeA, _, _ := routefiles.ParseSegmentDir(a.Dir)
+		routeB, _, _ := routefiles.ParseSegmentDir(b.Dir)
+		return strings.Compare(routeA, routeB)
+	})
+
+	var logs []StoredLog
+	for _, segment := range segments {
+		segmentRoute, _, _ := routefiles.ParseSegmentDir(segment.Dir)
+		motonic, routeID, ok := strings.Cut(segmentRoute, "--")
+		if !ok {
+			motonic, routeID = "", segmentRoute
+		}
+		for _, kind := range []routefiles.Kind{routefiles.KindQLog, routefiles.KindLog} {
+			name, ok := segment.Files[kind]
+			if !ok {
+				continue
+			}
+			logs = append(logs, StoredLog{
+				Device: device,
+				Path:   path.Join(segment.Dir, name),
+				RouteInfo: v1dot4.RouteInfo{
+					Motonic: motonic,
+					Route:   routeID,
+					Segment: strconv.Itoa(segment.Number),
+				},
+			})
+		}
+	}
+	return logs, nil
+}
+
+// Reprocess forgets what was parsed for the routes of logs and queues the
+// logs to be parsed again. It returns the IDs of the queued jobs.
+func (q *LogQueue) Reprocess(logs []StoredLog) ([]uint, error) {
+	type routeKey struct {
+		deviceID uint
+		route    string
+	}
+	reset := map[routeKey]bool{}
+	for _, log := range logs {
+		key := routeKey{log.Device.ID, log.RouteInfo.Route}
+		if reset[key] {
+			continue
+		}
+		route, err := models.FindRouteByDeviceIDAndRouteID(q.db, log.Device.ID, log.RouteInfo.Route)
+		if err == nil {
+			err = models.ResetRoute(q.db, route.ID)
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		reset[key] = true
+	}
+
+	ids := make([]uint, 0, len(logs))
+	for _, log := range logs {
+		id, err := q.addLog(log.Device, log.Path, log.RouteInfo)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
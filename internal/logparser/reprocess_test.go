@@ -0,0 +1,91 @@
+package logparser_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+func TestReprocess(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	q.upload(t, "qlog", drive(t, 1000))
+	q.upload(t, "rlog", drive(t, 2000))
+	before := q.segment(t)
+
+	// Pretend the logs were parsed by an older, broken parser
+	if err := q.db.Model(&models.Segment{}).Where("id = ?", before.ID).Updates(map[string]any{"length": 0, "position_source": ""}).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store, err := storage.NewStorage(&config.Config{
+		Persistence: config.Persistence{
+			Uploads: config.Uploads{
+				Driver:            config.UploadsDriverFilesystem,
+				FilesystemOptions: config.FilesystemOptions{Directory: filepath.Dir(q.uploads)},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	other, err := logparser.FindStoredLogs(q.db, store, logparser.ReprocessScope{DongleID: q.device.DongleID, Route: "0123abcdef"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(other) != 0 {
+		t.Errorf("expected no logs for another route, got %d", len(other))
+	}
+
+	logs, err := logparser.FindStoredLogs(q.db, store, logparser.ReprocessScope{Route: "abcdef0123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("expected the qlog and rlog, got %d logs", len(logs))
+	}
+	if logs[0].Path != "00000001--abcdef0123--0/qlog" || logs[0].RouteInfo.Motonic != "00000001" || logs[0].RouteInfo.Route != "abcdef0123" || logs[0].RouteInfo.Segment != "0" {
+		t.Errorf("unexpected log %+v", logs[0])
+	}
+
+	ids, err := q.queue.Reprocess(logs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		counts, err := models.CountLogJobsByIDs(q.db, ids)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if counts[models.LogJobStateDone] == int64(len(ids)) {
+			break
+		}
+		if counts[models.LogJobStateFailed] > 0 {
+			t.Fatalf("failed to reprocess logs: %v", counts)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for logs to be reprocessed: %v", counts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	after := q.segment(t)
+	if after.ID != before.ID {
+		t.Errorf("expected segment to be kept, got a new one")
+	}
+	if after.Length != before.Length || after.PositionSource != before.PositionSource {
+		t.Errorf("expected the rlog's length %f from %s, got %f from %s", before.Length, before.PositionSource, after.Length, after.PositionSource)
+	}
+	if !after.RLogProcessedAt.Valid() || !after.QLogProcessedAt.Valid() {
+		t.Errorf("expected both logs to be processed again")
+	}
+}
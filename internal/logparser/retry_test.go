@@ -0,0 +1,212 @@
+package logparser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"gorm.io/gorm"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	t.Parallel()
+	for attempts, expected := range map[int]time.Duration{
+		1:  RetryBackoff,
+		2:  2 * RetryBackoff,
+		3:  4 * RetryBackoff,
+		50: MaxRetryBackoff,
+	} {
+		if backoff := retryBackoff(attempts); backoff != expected {
+			t.Fatalf("attempt %d: expected a backoff of %v, got %v", attempts, expected, backoff)
+		}
+	}
+}
+
+func TestFailedJobsAreRetriedWithBackoff(t *testing.T) {
+	t.Parallel()
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+	q := NewLogQueue(&config.Config{ParallelLogParsers: 1}, database, nil, nil)
+	q.process = func(*gorm.DB, storage.Storage, work) error {
+		return errors.New("corrupt log")
+	}
+	id, err := q.addLog(device, "00000001--0123456789--0/qlog.zst", v1dot4.RouteInfo{Motonic: "00000001", Route: "0123456789", Segment: "0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		job, err := models.FindLogJobByID(database, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Claim the job directly rather than waiting out its backoff
+		claimed, err := models.ClaimLogJob(database, &job)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !claimed {
+			t.Fatalf("attempt %d: expected to claim the job", attempt)
+		}
+		before := time.Now()
+		retry, backoff := q.run(newWork(job))
+
+		job, err = models.FindLogJobByID(database, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if job.Attempts != attempt || job.LastError != "corrupt log" {
+			t.Fatalf("attempt %d: unexpected job %+v", attempt, job)
+		}
+		if attempt < MaxAttempts {
+			if !retry || backoff != retryBackoff(attempt) {
+				t.Fatalf("attempt %d: expected a retry after %v, got %v after %v", attempt, retryBackoff(attempt), retry, backoff)
+			}
+			if job.State != models.LogJobStatePending || job.RunAfter.Before(before.Add(backoff)) {
+				t.Fatalf("attempt %d: expected the job to wait out its backoff, got %s until %v", attempt, job.State, job.RunAfter)
+			}
+			runnable, err := models.ListRunnableLogJobs(database, 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(runnable) != 0 {
+				t.Fatalf("attempt %d: expected the job not to run before its backoff", attempt)
+			}
+			continue
+		}
+		if retry || job.State != models.LogJobStateFailed {
+			t.Fatalf("expected the job to fail after %d attempts, got %s", MaxAttempts, job.State)
+		}
+	}
+
+	// A failed job can be retried by hand with a fresh set of attempts
+	retried, err := q.Retry(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job, err := models.FindLogJobByID(database, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !retried || job.State != models.LogJobStatePending || job.Attempts != 0 {
+		t.Fatalf("expected the job to be queued again, got %s with %d attempts", job.State, job.Attempts)
+	}
+}
+
+func TestRecoverLogJobsOnlyTakesExpiredLeases(t *testing.T) {
+	t.Parallel()
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+
+	var jobs []models.LogJob
+	for _, path := range []string{"00000001--0123456789--0/qlog.zst", "00000001--0123456789--1/qlog.zst"} {
+		job := models.LogJob{DeviceID: device.ID, Path: path}
+		if err := models.EnqueueLogJob(database, &job); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claimed, err := models.ClaimLogJob(database, &job)
+		if err != nil || !claimed {
+			t.Fatalf("expected to claim the job, got %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	// The first job's instance stopped sending heartbeats
+	err := database.Model(&models.LogJob{}).Where("id = ?", jobs[0].ID).Update("heartbeat_at", time.Now().Add(-2*JobLease)).Error
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := models.HeartbeatLogJob(database, jobs[1].ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recovered, err := models.RecoverLogJobs(database, time.Now().Add(-JobLease))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recovered != 1 {
+		t.Fatalf("expected one job to be recovered, got %d", recovered)
+	}
+	for i, expected := range []models.LogJobState{models.LogJobStatePending, models.LogJobStateRunning} {
+		job, err := models.FindLogJobByID(database, jobs[i].ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if job.State != expected {
+			t.Fatalf("job %d: expected %s, got %s", i, expected, job.State)
+		}
+	}
+}
+
+func TestReuploadWhileRunningIsRequeued(t *testing.T) {
+	t.Parallel()
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+
+	job := models.LogJob{DeviceID: device.ID, Path: "00000001--0123456789--0/qlog.zst"}
+	if err := models.EnqueueLogJob(database, &job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claimed, err := models.ClaimLogJob(database, &job)
+	if err != nil || !claimed {
+		t.Fatalf("expected to claim the job, got %v", err)
+	}
+
+	// The log is uploaded again while it's being parsed
+	reupload := models.LogJob{DeviceID: device.ID, Path: job.Path}
+	if err := models.EnqueueLogJob(database, &reupload); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reupload.ID != job.ID {
+		t.Fatalf("expected the upload to requeue job %d, got %d", job.ID, reupload.ID)
+	}
+	stored, err := models.FindLogJobByID(database, job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.State != models.LogJobStateRunning || !stored.Requeue {
+		t.Fatalf("expected the job to keep running and be requeued, got %s requeue=%v", stored.State, stored.Requeue)
+	}
+	runnable, err := models.ListRunnableLogJobs(database, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runnable) != 0 {
+		t.Fatalf("expected the running job not to be runnable elsewhere")
+	}
+
+	// The run still reports to the job, which goes back to pending for the new file
+	if err := models.HeartbeatLogJob(database, job.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := models.CompleteLogJob(database, job.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err = models.FindLogJobByID(database, job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.State != models.LogJobStatePending || stored.Requeue || stored.Attempts != 0 {
+		t.Fatalf("expected the job to be pending again, got %s requeue=%v attempts=%d", stored.State, stored.Requeue, stored.Attempts)
+	}
+
+	// Without a reupload, the next run finishes the job
+	claimed, err = models.ClaimLogJob(database, &stored)
+	if err != nil || !claimed {
+		t.Fatalf("expected to claim the job, got %v", err)
+	}
+	if err := models.CompleteLogJob(database, job.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, err = models.FindLogJobByID(database, job.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.State != models.LogJobStateDone {
+		t.Fatalf("expected the job to be done, got %s", stored.State)
+	}
+}
@@ -0,0 +1,99 @@
+package logparser
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ThumbnailsDir is the directory thumbnails are stored in, within their segment's directory
+const ThumbnailsDir = "thumbnails"
+
+type thumbnail struct {
+	logMonoTime uint64
+	data        []byte
+}
+
+// thumbnailExtractor stores the JPEG previews of the road camera openpilot logs
+type thumbnailExtractor struct {
+	thumbnails []thumbnail
+}
+
+func (e *thumbnailExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{cereal.Event_Which_thumbnail}
+}
+
+func (e *thumbnailExtractor) Handle(event cereal.Event) error {
+	thumb, err := event.Thumbnail()
+	if err != nil {
+		return err
+	}
+	// Newer openpilot versions can log raw keyframes instead, which aren't images
+	if thumb.Encoding() != cereal.Thumbnail_Encoding_unknown && thumb.Encoding() != cereal.Thumbnail_Encoding_jpeg {
+		return nil
+	}
+	data, err := thumb.Thumbnail()
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return nil
+	}
+	e.thumbnails = append(e.thumbnails, thumbnail{
+		logMonoTime: event.LogMonoTime(),
+		// The data belongs to the message, which is reused for the next event
+		data: bytes.Clone(data),
+	})
+	return nil
+}
+
+func (e *thumbnailExtractor) Persist(db *gorm.DB, segment SegmentContext) error {
+	// Keep what's stored when the log has no thumbnails, such as a log from a build that doesn't record them
+	if len(e.thumbnails) == 0 || segment.Storage == nil {
+		return nil
+	}
+	stored, err := models.FindSegmentByRouteIDAndNumber(db, segment.Route.ID, segment.Number)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(segment.Dir, ThumbnailsDir)
+	err = segment.Storage.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnails directory: %w", err)
+	}
+	thumbnails := make([]models.Thumbnail, 0, len(e.thumbnails))
+	for i, thumb := range e.thumbnails {
+		path := filepath.Join(dir, strconv.Itoa(i)+".jpg")
+		err := writeFile(segment.Storage, path, thumb.data)
+		if err != nil {
+			return fmt.Errorf("failed to write thumbnail: %w", err)
+		}
+		thumbnails = append(thumbnails, models.Thumbnail{
+			Index:       i,
+			LogMonoTime: thumb.logMonoTime,
+			Path:        path,
+			Size:        int64(len(thumb.data)),
+		})
+	}
+	return models.ReplaceSegmentThumbnails(db, stored.ID, thumbnails)
+}
+
+func writeFile(store storage.Storage, path string, data []byte) error {
+	f, err := store.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,73 @@
+package logparser_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+func jpegThumbnail(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func thumbnail(encoding cereal.Thumbnail_Encoding, data []byte) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		thumbnail, err := ev.NewThumbnail()
+		if err != nil {
+			return err
+		}
+		thumbnail.SetEncoding(encoding)
+		return thumbnail.SetThumbnail(data)
+	}
+}
+
+func TestThumbnailsAreStored(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	jpg := jpegThumbnail(t)
+	q.upload(t, "qlog", encodeLog(t,
+		event{0, initData("0.9.7")},
+		event{1_000_000_000, thumbnail(cereal.Thumbnail_Encoding_unknown, jpg)},
+		event{2_000_000_000, thumbnail(cereal.Thumbnail_Encoding_keyframe, []byte{0, 0, 0, 1})},
+		event{3_000_000_000, thumbnail(cereal.Thumbnail_Encoding_jpeg, jpg)},
+	))
+
+	route, err := models.FindRouteByDeviceIDAndRouteID(q.db, q.device.ID, "abcdef0123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	thumbnails, err := models.FindThumbnailsByRouteID(q.db, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(thumbnails) != 2 {
+		t.Fatalf("expected 2 thumbnails, got %d", len(thumbnails))
+	}
+	for i, thumbnail := range thumbnails {
+		if thumbnail.Index != i || thumbnail.Number != 0 {
+			t.Errorf("expected thumbnail %d of segment 0, got %d of segment %d", i, thumbnail.Index, thumbnail.Number)
+		}
+		stored, err := os.ReadFile(filepath.Join(filepath.Dir(q.uploads), thumbnail.Path))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(stored, jpg) {
+			t.Errorf("expected thumbnail %d to be stored as logged", i)
+		}
+	}
+	if thumbnails[1].LogMonoTime != 3_000_000_000 {
+		t.Errorf("expected second thumbnail at 3s, got %d", thumbnails[1].LogMonoTime)
+	}
+}
@@ -0,0 +1,66 @@
+package logparser
+
+import (
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// TrackInterval is how far apart the points of a segment's stored track are
+const TrackInterval = time.Second
+
+// trackExtractor stores a downsampled copy of the positions a segment's log was decoded with
+type trackExtractor struct{}
+
+func (e *trackExtractor) Events() []cereal.Event_Which {
+	return nil
+}
+
+func (e *trackExtractor) Handle(cereal.Event) error {
+	return nil
+}
+
+func (e *trackExtractor) Persist(db *gorm.DB, segment SegmentContext) error {
+	stored, err := models.FindSegmentByRouteIDAndNumber(db, segment.Route.ID, segment.Number)
+	if err != nil {
+		return err
+	}
+	positions := downsample(segment.Data.Positions, TrackInterval)
+	points := make([]models.TrackPoint, 0, len(positions))
+	for _, position := range positions {
+		points = append(points, models.TrackPoint{
+			Time:      wallTime(segment.Route, position.LogMonoTime),
+			Latitude:  position.Latitude,
+			Longitude: position.Longitude,
+			Speed:     position.Speed,
+			Distance:  position.Distance,
+		})
+	}
+	return models.ReplaceSegmentTrack(db, stored.ID, points)
+}
+
+// downsample keeps a position at most every interval, along with the last one.
+// Positions without coordinates are dropped.
+func downsample(positions []Position, interval time.Duration) []Position {
+	var kept []Position
+	var next uint64
+	var skipped *Position
+	for _, position := range positions {
+		if position.Latitude == 0 && position.Longitude == 0 {
+			continue
+		}
+		if len(kept) == 0 || position.LogMonoTime >= next {
+			kept = append(kept, position)
+			next = position.LogMonoTime + uint64(interval)
+			skipped = nil
+			continue
+		}
+		skipped = &position
+	}
+	if skipped != nil {
+		kept = append(kept, *skipped)
+	}
+	return kept
+}
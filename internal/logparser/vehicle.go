@@ -0,0 +1,67 @@
+package logparser
+
+import (
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// vehicleExtractor identifies the car a segment was driven in from its
+// carParams and links the segment's route to it
+type vehicleExtractor struct {
+	// vehicle is nil if the log has no carParams
+	vehicle *models.Vehicle
+}
+
+func (e *vehicleExtractor) Events() []cereal.Event_Which {
+	return []cereal.Event_Which{cereal.Event_Which_carParams}
+}
+
+func (e *vehicleExtractor) Handle(event cereal.Event) error {
+	carParams, err := event.CarParams()
+	if err != nil {
+		return err
+	}
+	brand, err := carParams.Brand()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := carParams.CarFingerprint()
+	if err != nil {
+		return err
+	}
+	vin, err := carParams.CarVin()
+	if err != nil {
+		return err
+	}
+	// The VIN can't always be read, don't forget it if it was earlier
+	if !models.KnownVIN(vin) && e.vehicle != nil {
+		vin = e.vehicle.VIN
+	}
+	e.vehicle = &models.Vehicle{
+		VIN:                   vin,
+		Brand:                 brand,
+		Fingerprint:           fingerprint,
+		OpenpilotLongitudinal: carParams.OpenpilotLongitudinalControl(),
+		Radar:                 !carParams.RadarUnavailable(),
+	}
+	return nil
+}
+
+func (e *vehicleExtractor) Persist(db *gorm.DB, segment SegmentContext) error {
+	if e.vehicle == nil {
+		return nil
+	}
+	vehicle, err := models.FindOrCreateVehicle(db, segment.Device, *e.vehicle)
+	if err != nil {
+		return err
+	}
+	updates := map[string]any{
+		"vehicle_id": vehicle.ID,
+		"radar":      vehicle.Radar,
+	}
+	if vehicle.Fingerprint != "" {
+		updates["platform"] = vehicle.Fingerprint
+	}
+	return db.Model(&models.Route{}).Where("id = ?", segment.Route.ID).Updates(updates).Error
+}
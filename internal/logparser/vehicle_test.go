@@ -0,0 +1,108 @@
+package logparser_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/cereal"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+type car struct {
+	brand       string
+	fingerprint string
+	vin         string
+	radar       bool
+}
+
+func carParams(c car) func(cereal.Event) error {
+	return func(ev cereal.Event) error {
+		carParams, err := ev.NewCarParams()
+		if err != nil {
+			return err
+		}
+		if err := carParams.SetBrand(c.brand); err != nil {
+			return err
+		}
+		if err := carParams.SetCarFingerprint(c.fingerprint); err != nil {
+			return err
+		}
+		carParams.SetRadarUnavailable(!c.radar)
+		carParams.SetOpenpilotLongitudinalControl(true)
+		return carParams.SetCarVin(c.vin)
+	}
+}
+
+// driveCar encodes a log of meters driven in c
+func driveCar(t *testing.T, c car, meters float64) *bytes.Buffer {
+	t.Helper()
+	return encodeLog(t,
+		event{100, initData("0.9.7")},
+		event{150, carParams(c)},
+		event{200, liveLocationKalman(6378137, 0, 0, 0.001, 0)},
+		event{300, liveLocationKalman(6378137, 0, meters, 0.002, 0)},
+	)
+}
+
+func TestCarParamsVINIsKept(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+	q.uploadRoute(t, "0000000001", "qlog", encodeLog(t,
+		event{50, initData("0.9.7")},
+		event{100, carParams(car{brand: "toyota", fingerprint: "TOYOTA_RAV4", vin: "00000000000000000"})},
+		event{200, carParams(car{brand: "toyota", fingerprint: "TOYOTA_RAV4", vin: "JTMW1RFV0KD000001", radar: true})},
+		event{300, carParams(car{brand: "toyota", fingerprint: "TOYOTA_RAV4", vin: "00000000000000000", radar: true})},
+	))
+
+	vehicles, err := models.FindVehicleMileageByDeviceID(q.db, q.device.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vehicles) != 1 {
+		t.Fatalf("expected 1 vehicle, got %d", len(vehicles))
+	}
+	if vehicles[0].VIN != "JTMW1RFV0KD000001" {
+		t.Errorf("expected the VIN read mid-segment to be kept, got %q", vehicles[0].VIN)
+	}
+	if !vehicles[0].Radar || !vehicles[0].OpenpilotLongitudinal || vehicles[0].Fingerprint != "TOYOTA_RAV4" {
+		t.Errorf("unexpected vehicle %+v", vehicles[0].Vehicle)
+	}
+}
+
+func TestVehicleMileage(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue(t)
+
+	rav4 := car{brand: "toyota", fingerprint: "TOYOTA_RAV4", vin: "JTMW1RFV0KD000001", radar: true}
+	// Cars whose VIN can't be read are told apart by fingerprint
+	bolt := car{brand: "gm", fingerprint: "CHEVROLET_BOLT_EUV", vin: "00000000000000000"}
+
+	q.uploadRoute(t, "0000000001", "qlog", driveCar(t, rav4, 1000))
+	q.uploadRoute(t, "0000000002", "qlog", driveCar(t, bolt, 2000))
+	q.uploadRoute(t, "0000000003", "qlog", driveCar(t, rav4, 3000))
+
+	vehicles, err := models.FindVehicleMileageByDeviceID(q.db, q.device.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vehicles) != 2 {
+		t.Fatalf("expected 2 vehicles, got %d", len(vehicles))
+	}
+
+	const metersToMiles = 0.000621371
+	if vehicles[0].VIN != rav4.vin || vehicles[0].Routes != 2 || math.Abs(vehicles[0].Miles-4000*metersToMiles) > 1e-6 {
+		t.Errorf("unexpected mileage for the RAV4: %+v", vehicles[0])
+	}
+	if vehicles[1].VIN != "" || vehicles[1].Fingerprint != bolt.fingerprint || vehicles[1].Routes != 1 || math.Abs(vehicles[1].Miles-2000*metersToMiles) > 1e-6 {
+		t.Errorf("unexpected mileage for the Bolt: %+v", vehicles[1])
+	}
+
+	route, err := models.FindRouteByDeviceIDAndRouteID(q.db, q.device.ID, "0000000002")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if route.Radar || route.Platform != bolt.fingerprint || route.VehicleID == nil || *route.VehicleID != vehicles[1].ID {
+		t.Errorf("expected the route to be linked to the Bolt, got %+v", route)
+	}
+}
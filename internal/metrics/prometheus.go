@@ -10,6 +10,7 @@ type Metrics struct {
 	logParserErrors     *prometheus.CounterVec
 	logParserQueueSize  prometheus.Gauge
 	logParserActiveJobs prometheus.Gauge
+	deviceMetrics       *prometheus.GaugeVec
 }
 
 func NewMetrics() *Metrics {
@@ -34,6 +35,10 @@ func NewMetrics() *Metrics {
 			Name: "log_parser_active_jobs",
 			Help: "The current number of active log parser jobs",
 		}),
+		deviceMetrics: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "device_metric",
+			Help: "The latest value of a metric reported by a device",
+		}, []string{"dongle_id", "name", "field"}),
 	}
 	metrics.register()
 	return metrics
@@ -45,6 +50,7 @@ func (m *Metrics) register() {
 	prometheus.MustRegister(m.logParserErrors)
 	prometheus.MustRegister(m.logParserQueueSize)
 	prometheus.MustRegister(m.logParserActiveJobs)
+	prometheus.MustRegister(m.deviceMetrics)
 }
 
 func (m *Metrics) IncrementAthenaConnections(dongleID string) {
@@ -70,3 +76,9 @@ func (m *Metrics) SetLogParserQueueSize(size float64) {
 func (m *Metrics) SetLogParserActiveJobs(jobs float64) {
 	m.logParserActiveJobs.Set(jobs)
 }
+
+// SetDeviceMetric sets the latest value of a device's metric. Callers have to
+// limit the names and fields to an allow-list, the labels come from the device.
+func (m *Metrics) SetDeviceMetric(dongleID, name, field string, value float64) {
+	m.deviceMetrics.WithLabelValues(dongleID, name, field).Set(value)
+}
@@ -0,0 +1,133 @@
+package routefiles
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+type Kind string
+
+// The kinds are named after the keys of comma's route files API
+const (
+	KindCamera       Kind = "cameras"
+	KindDriverCamera Kind = "dcameras"
+	KindWideCamera   Kind = "ecameras"
+	KindLog          Kind = "logs"
+	KindQCamera      Kind = "qcameras"
+	KindQLog         Kind = "qlogs"
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	Kinds = []Kind{KindCamera, KindDriverCamera, KindWideCamera, KindLog, KindQCamera, KindQLog}
+
+	fileKinds = map[string]Kind{
+		"fcamera.hevc": KindCamera,
+		"dcamera.hevc": KindDriverCamera,
+		"ecamera.hevc": KindWideCamera,
+		"rlog":         KindLog,
+		"rlog.bz2":     KindLog,
+		"rlog.zst":     KindLog,
+		"qcamera.ts":   KindQCamera,
+		"qlog":         KindQLog,
+		"qlog.bz2":     KindQLog,
+		"qlog.zst":     KindQLog,
+	}
+
+	segmentDirRegex = regexp.MustCompile(`^(?P<route>.+)--(?P<segment>\d+)$`)
+)
+
+// KindOf returns the kind of a file from its name within a segment directory
+func KindOf(name string) (Kind, bool) {
+	kind, ok := fileKinds[name]
+	return kind, ok
+}
+
+// SegmentDir returns the directory a segment of route is uploaded to.
+// Devices upload each segment into its own directory named <route>--<segment>,
+// e.g. 000000dd--455f14369d--3/qlog.zst
+func SegmentDir(route string, segment int) string {
+	return fmt.Sprintf("%s--%d", route, segment)
+}
+
+// ParseSegmentDir splits a segment directory name into its route and segment number
+func ParseSegmentDir(dir string) (string, int, bool) {
+	match := segmentDirRegex.FindStringSubmatch(dir)
+	if match == nil {
+		return "", 0, false
+	}
+	segment, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return match[1], segment, true
+}
+
+type Segment struct {
+	Number int
+	// Dir is the segment's directory relative to the device's storage
+	Dir string
+	// Files holds the name of the file of each kind present in Dir
+	Files map[Kind]string
+}
+
+// MatchRoute reports whether the route part of a segment directory names route.
+// Routes can be named in full as <counter>--<route id> or by the route id alone.
+func MatchRoute(segmentRoute string, route string) bool {
+	return segmentRoute == route || strings.HasSuffix(segmentRoute, "--"+route)
+}
+
+// List returns the uploaded segments of a route ordered by segment number,
+// or of every route when route is empty. store must be rooted at the
+// device's upload directory.
+func List(store storage.Storage, route string) ([]Segment, error) {
+	entries, err := store.ReadDir(".")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		segmentRoute, number, ok := ParseSegmentDir(entry.Name())
+		if !ok || (route != "" && !MatchRoute(segmentRoute, route)) {
+			continue
+		}
+
+		files, err := store.ReadDir(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		segment := Segment{
+			Number: number,
+			Dir:    entry.Name(),
+			Files:  map[Kind]string{},
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			if kind, ok := KindOf(file.Name()); ok {
+				segment.Files[kind] = file.Name()
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return a.Number - b.Number
+	})
+	return segments, nil
+}
@@ -0,0 +1,90 @@
+package routefiles_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	store, err := storage.NewStorage(&config.Config{
+		Persistence: config.Persistence{
+			Uploads: config.Uploads{
+				Driver:            config.UploadsDriverFilesystem,
+				FilesystemOptions: config.FilesystemOptions{Directory: t.TempDir()},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{
+		"000000dd--455f14369d--10/qlog.zst",
+		"000000dd--455f14369d--2/qlog.zst",
+		"000000dd--455f14369d--2/qcamera.ts",
+		"000000dd--455f14369d--2/fcamera.hevc",
+		"000000dd--455f14369d--2/unknown.bin",
+		"000000dd--455f14369d0--1/qlog.zst",
+		"000000de--455f14369d--0/qlog.zst",
+	} {
+		if err := store.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		f, err := store.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	segments, err := routefiles.List(store, "000000dd--455f14369d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	if segments[0].Number != 2 || segments[1].Number != 10 {
+		t.Errorf("expected segments 2 and 10 in order, got %d and %d", segments[0].Number, segments[1].Number)
+	}
+	if len(segments[0].Files) != 3 {
+		t.Errorf("expected 3 known files in segment 2, got %v", segments[0].Files)
+	}
+	if segments[0].Files[routefiles.KindQCamera] != "qcamera.ts" {
+		t.Errorf("expected qcamera.ts, got %q", segments[0].Files[routefiles.KindQCamera])
+	}
+	if segments[1].Files[routefiles.KindQLog] != "qlog.zst" {
+		t.Errorf("expected qlog.zst, got %q", segments[1].Files[routefiles.KindQLog])
+	}
+
+	all, err := routefiles.List(store, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(all) != 4 {
+		t.Errorf("expected 4 segments across every route, got %d", len(all))
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	t.Parallel()
+
+	if !routefiles.MatchRoute("000000dd--455f14369d", "000000dd--455f14369d") {
+		t.Error("expected full route name to match")
+	}
+	if !routefiles.MatchRoute("000000dd--455f14369d", "455f14369d") {
+		t.Error("expected route id to match")
+	}
+	if routefiles.MatchRoute("000000dd--455f14369d", "55f14369d") {
+		t.Error("expected partial route id not to match")
+	}
+}
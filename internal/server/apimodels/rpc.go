@@ -5,6 +5,9 @@ type InboundRPCCall struct {
 	Method         string `json:"method"`
 	JSONRPCVersion string `json:"jsonrpc"`
 	Params         any    `json:"params"`
+	// Expiry is in seconds since the epoch. If it's set and the dongle is
+	// offline, the call is queued until then to be made when it connects.
+	Expiry int64 `json:"expiry"`
 }
 
 type RPCCall struct {
@@ -20,3 +23,32 @@ type RPCResponse struct {
 	Result         any    `json:"result"`
 	Error          string `json:"error"`
 }
+
+// UploadFileData is a file to upload in the files_data param of uploadFilesToUrls.
+// FileName is relative to the device's log directory.
+type UploadFileData struct {
+	FileName      string            `json:"fn"`
+	URL           string            `json:"url"`
+	Headers       map[string]string `json:"headers"`
+	AllowCellular bool              `json:"allow_cellular"`
+}
+
+// UploadItem is a file in a device's upload queue, as listed by listUploadQueue.
+// Path is absolute on the device.
+type UploadItem struct {
+	ID            string  `json:"id"`
+	Path          string  `json:"path"`
+	URL           string  `json:"url"`
+	Progress      float64 `json:"progress"`
+	Current       bool    `json:"current"`
+	RetryCount    int     `json:"retry_count"`
+	CreatedAt     int64   `json:"created_at"`
+	AllowCellular bool    `json:"allow_cellular"`
+}
+
+type UploadFilesToURLsResult struct {
+	Enqueued int          `json:"enqueued"`
+	Items    []UploadItem `json:"items"`
+	// Failed lists the file names the device doesn't have
+	Failed []string `json:"failed"`
+}
@@ -6,7 +6,8 @@ type StatsResponse struct {
 }
 
 type Stats struct {
-	Distance float64 `json:"distance"`
-	Minutes  int64   `json:"minutes"`
-	Routes   int64   `json:"routes"`
+	Distance       float64 `json:"distance"`
+	Minutes        int64   `json:"minutes"`
+	EngagedMinutes int64   `json:"engaged_minutes"`
+	Routes         int64   `json:"routes"`
 }
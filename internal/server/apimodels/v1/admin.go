@@ -0,0 +1,32 @@
+package v1
+
+import (
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+type LogJobResponse struct {
+	models.LogJob
+	DongleID string `json:"dongle_id"`
+}
+
+type ReprocessRequest struct {
+	// DongleID and Route narrow down the logs to reprocess, which is
+	// every device's when neither are set
+	DongleID string `json:"dongle_id"`
+	Route    string `json:"route"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+type ReprocessResponse struct {
+	// Logs are the paths of the logs within the uploads storage
+	Logs   []string `json:"logs"`
+	JobIDs []uint   `json:"job_ids"`
+}
+
+type ReprocessProgressResponse struct {
+	Total   int   `json:"total"`
+	Pending int64 `json:"pending"`
+	Running int64 `json:"running"`
+	Done    int64 `json:"done"`
+	Failed  int64 `json:"failed"`
+}
@@ -66,3 +66,33 @@ type RouteSegmentsResponse struct {
 	Version            string            `json:"version"`
 	VIN                string            `json:"vin"`
 }
+
+type AthenaOfflineQueueEntry struct {
+	ID             string `json:"id"`
+	Method         string `json:"method"`
+	JSONRPCVersion string `json:"jsonrpc"`
+	Params         any    `json:"params"`
+	// Expiry is in seconds since the epoch
+	Expiry int64 `json:"expiry"`
+}
+
+type DeviceMetricPoint struct {
+	Field string  `json:"field"`
+	Value float64 `json:"value"`
+	// Time is in milliseconds since the epoch
+	Time int64             `json:"time"`
+	Tags map[string]string `json:"tags"`
+}
+
+type DeviceMetricResponse struct {
+	Name   string              `json:"name"`
+	Points []DeviceMetricPoint `json:"points"`
+}
+
+type DeviceLogLine struct {
+	// Time is in milliseconds since the epoch
+	Time    int64  `json:"time"`
+	Level   string `json:"level"`
+	Daemon  string `json:"daemon"`
+	Message string `json:"msg"`
+}
@@ -26,3 +26,12 @@ type GETMyDevicesResponse struct {
 	IgnoreUploads    bool             `json:"ignore_uploads"`
 	IsOwner          bool             `json:"is_owner"`
 }
+
+type RouteFilesResponse struct {
+	Cameras       []string `json:"cameras"`
+	DriverCameras []string `json:"dcameras"`
+	WideCameras   []string `json:"ecameras"`
+	Logs          []string `json:"logs"`
+	QCameras      []string `json:"qcameras"`
+	QLogs         []string `json:"qlogs"`
+}
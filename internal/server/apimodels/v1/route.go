@@ -0,0 +1,27 @@
+package v1
+
+// RouteEvent is an entry of the events.json of a route segment, in comma's format
+type RouteEvent struct {
+	Type string `json:"type"`
+	// Time is the event's logMonoTime in seconds
+	Time float64 `json:"time"`
+	// OffsetMillis is the time since the start of the segment
+	OffsetMillis int64 `json:"offset_millis"`
+	// RouteOffsetMillis is the time since the start of the route
+	RouteOffsetMillis int64          `json:"route_offset_millis"`
+	Data              map[string]any `json:"data"`
+}
+
+// UploadRequestsRequest asks a device to upload a route's full-resolution files.
+// The segment range is inclusive and defaults to every segment uploaded so far.
+type UploadRequestsRequest struct {
+	StartSegment *int `json:"start_segment"`
+	EndSegment   *int `json:"end_segment"`
+	// Files are the kinds of files to upload, as named by /v1/route/:id/files
+	Files         []string `json:"files" binding:"required"`
+	AllowCellular bool     `json:"allow_cellular"`
+}
+
+type CancelUploadRequestsRequest struct {
+	IDs []uint `json:"ids" binding:"required"`
+}
@@ -4,14 +4,17 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
 	"github.com/USA-RedDragon/rtz-server/internal/metrics"
 	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
 	"github.com/USA-RedDragon/rtz-server/internal/server/websocket"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"gorm.io/gorm"
 )
 
 func HandleRPC(c *gin.Context) {
@@ -82,12 +85,13 @@ func HandleRPC(c *gin.Context) {
 
 	resp, err := rpcCaller.Call(c, nc, metrics, dongleID, call)
 	if err != nil {
-		if errors.Is(err, websocket.ErrNotConnected) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Dongle not connected"})
-			return
-		}
-		if errors.Is(err, nats.ErrNoResponders) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Dongle not connected"})
+		if errors.Is(err, websocket.ErrNotConnected) || errors.Is(err, nats.ErrNoResponders) {
+			expiry := time.Unix(inboundCall.Expiry, 0)
+			if inboundCall.Expiry == 0 || !expiry.After(time.Now()) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Dongle not connected"})
+				return
+			}
+			queueOfflineCall(c, dongleID, call, expiry)
 			return
 		}
 		slog.Error("Failed to call RPC", "error", err)
@@ -97,3 +101,31 @@ func HandleRPC(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// queueOfflineCall saves a call to be made when the dongle next connects
+func queueOfflineCall(c *gin.Context, dongleID string, call apimodels.RPCCall, expiry time.Time) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	err = websocket.QueueCall(db, device.ID, call, expiry)
+	if err != nil {
+		slog.Error("Failed to queue RPC call", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	// This is the response comma's athena gives, which the frontend looks for
+	c.JSON(http.StatusAccepted, apimodels.RPCResponse{
+		ID:             call.ID,
+		JSONRPCVersion: call.JSONRPCVersion,
+		Result:         "Device offline, message queued",
+	})
+}
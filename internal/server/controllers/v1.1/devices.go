@@ -86,30 +86,32 @@ func GETDeviceStats(c *gin.Context) {
 
 	slog.Info("Get Stats", "url", c.Request.URL.String(), "device", device.DongleID)
 
-	allTrips, err := models.CountRoutesSince(db, device.ID, device.CreatedAt)
+	all, err := models.SumDriveStats(db, device.ID, time.Time{})
 	if err != nil {
-		slog.Error("Failed to count routes", "error", err)
+		slog.Error("Failed to sum drive stats", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
 
-	weekTrips, err := models.CountRoutesSince(db, device.ID, time.Now().AddDate(0, 0, -7))
+	// The past week is today and the six days before it
+	week, err := models.SumDriveStats(db, device.ID, time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -6))
 	if err != nil {
-		slog.Error("Failed to count routes", "error", err)
+		slog.Error("Failed to sum drive stats", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
 
 	c.JSON(http.StatusOK, v1dot1.StatsResponse{
-		All: v1dot1.Stats{
-			Distance: 0, // TODO: Implement
-			Minutes:  0, // TODO: Implement
-			Routes:   allTrips,
-		},
-		Week: v1dot1.Stats{
-			Distance: 0, // TODO: Implement
-			Minutes:  0, // TODO: Implement
-			Routes:   weekTrips,
-		},
+		All:  driveStats(all),
+		Week: driveStats(week),
 	})
 }
+
+func driveStats(total models.DriveStatsTotal) v1dot1.Stats {
+	return v1dot1.Stats{
+		Distance:       total.Distance,
+		Minutes:        total.DriveMillis / time.Minute.Milliseconds(),
+		EngagedMinutes: total.EngagedMillis / time.Minute.Milliseconds(),
+		Routes:         total.Routes,
+	}
+}
@@ -0,0 +1,348 @@
+package v1dot4
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Chunks of in-progress uploads are kept under this directory in the
+// dongle's storage until the whole file has arrived
+const partialUploadDir = ".partial"
+
+var (
+	errInvalidContentRange = errors.New("invalid Content-Range")
+
+	contentRangeRegex = regexp.MustCompile(`^bytes (?:(?P<start>\d+)-(?P<end>\d+)|\*)/(?P<total>\d+|\*)$`)
+)
+
+// contentRange is a parsed `Content-Range: bytes <start>-<end>/<total>` header.
+// A header of the form `bytes */<total>` carries no data and is used to probe
+// how much of the file the server already has.
+type contentRange struct {
+	probe bool
+	start int64
+	end   int64
+	// total is -1 when the client doesn't know the final size yet
+	total int64
+}
+
+func parseContentRange(header string) (contentRange, error) {
+	match := contentRangeRegex.FindStringSubmatch(header)
+	if match == nil {
+		return contentRange{}, errInvalidContentRange
+	}
+	cr := contentRange{total: -1, probe: true}
+	for i, name := range contentRangeRegex.SubexpNames() {
+		if match[i] == "" {
+			continue
+		}
+		var err error
+		switch name {
+		case "start":
+			cr.probe = false
+			cr.start, err = strconv.ParseInt(match[i], 10, 64)
+		case "end":
+			cr.end, err = strconv.ParseInt(match[i], 10, 64)
+		case "total":
+			if match[i] != "*" {
+				cr.total, err = strconv.ParseInt(match[i], 10, 64)
+			}
+		}
+		if err != nil {
+			return contentRange{}, errInvalidContentRange
+		}
+	}
+	if cr.probe && cr.total < 0 {
+		return contentRange{}, errInvalidContentRange
+	}
+	if !cr.probe && (cr.end < cr.start || (cr.total >= 0 && cr.end >= cr.total)) {
+		return contentRange{}, errInvalidContentRange
+	}
+	return cr, nil
+}
+
+func chunkPath(path string, name string) string {
+	return filepath.Join(partialUploadDir, path, name)
+}
+
+func setUploadProgressHeaders(c *gin.Context, upload models.PartialUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	if upload.Size >= 0 {
+		c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	}
+	if upload.Received > 0 {
+		c.Header("Range", fmt.Sprintf("bytes=0-%d", upload.Received-1))
+	}
+}
+
+// HEADUpload lets a device find out how much of a file the server already
+// has so it can resume an interrupted upload from that offset
+func HEADUpload(c *gin.Context) {
+	dongleID, ok := c.Params.Get("dongle_id")
+	if !ok {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	path := c.Query("path")
+	if path == "" || !fs.ValidPath(path) {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	upload, err := models.FindPartialUpload(db, device.ID, path)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Error("Failed to find partial upload", "error", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		upload = models.PartialUpload{Path: path, Size: -1}
+	}
+
+	setUploadProgressHeaders(c, upload)
+	c.Status(http.StatusOK)
+}
+
+func putUploadChunk(c *gin.Context, db *gorm.DB, base storage.Storage, logQueue *logparser.LogQueue, device models.Device, path string) {
+	cr, err := parseContentRange(c.GetHeader("Content-Range"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Content-Range"})
+		return
+	}
+
+	upload, err := models.FindPartialUpload(db, device.ID, path)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Error("Failed to find partial upload", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+		if cr.probe || cr.start != 0 {
+			// Nothing has been received yet, so the device has to start from zero
+			upload = models.PartialUpload{Path: path, Size: -1}
+			setUploadProgressHeaders(c, upload)
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Upload must start at offset 0"})
+			return
+		}
+		upload = models.PartialUpload{
+			DeviceID: device.ID,
+			Path:     path,
+			Size:     cr.total,
+		}
+		err = db.Create(&upload).Error
+		if err != nil {
+			slog.Error("Failed to create partial upload", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+	}
+
+	if upload.Size >= 0 && cr.total >= 0 && cr.total != upload.Size {
+		setUploadProgressHeaders(c, upload)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload length changed"})
+		return
+	}
+	if cr.total >= 0 && upload.Size < 0 {
+		upload.Size = cr.total
+		err = db.Model(&upload).Update("size", upload.Size).Error
+		if err != nil {
+			slog.Error("Failed to update partial upload", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+	}
+
+	// Every chunk arrived but assembling them failed, so there's nothing left
+	// for the device to send. Whatever it sends, try assembling again.
+	if upload.Complete() {
+		finishUpload(c, db, base, logQueue, device, upload)
+		return
+	}
+
+	if cr.probe {
+		setUploadProgressHeaders(c, upload)
+		c.JSON(http.StatusOK, gin.H{"received": upload.Received})
+		return
+	}
+
+	if cr.start != upload.Received {
+		setUploadProgressHeaders(c, upload)
+		c.JSON(http.StatusConflict, gin.H{"error": "Offset does not match the received length"})
+		return
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		slog.Error("Failed to generate chunk name", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	name := strconv.FormatInt(cr.start, 10) + "." + id.String()
+	err = base.MkdirAll(filepath.Dir(chunkPath(path, name)), 0755)
+	if err != nil {
+		slog.Error("Failed to create chunk directory", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	f, err := base.Create(chunkPath(path, name))
+	if err != nil {
+		slog.Error("Failed to create chunk", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	// Whatever made it to us before a dropped connection is kept,
+	// the device can pick back up from the end of it. This copies straight
+	// into the file, a bufio.Writer would hold on to the read error and
+	// report it again from Flush.
+	written, copyErr := io.Copy(f, io.LimitReader(c.Request.Body, cr.end-cr.start+1))
+	err = f.Close()
+	if err != nil || written == 0 {
+		removeChunk(base, path, name)
+		if err != nil {
+			slog.Error("Failed to write chunk", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+	}
+	if copyErr != nil {
+		slog.Warn("Chunk upload interrupted", "path", path, "offset", cr.start, "written", written, "error", copyErr)
+	}
+
+	if written > 0 {
+		// Another request for the same offset may have been writing its own
+		// copy alongside this one, only the one that advances the upload is kept
+		advanced, err := models.AdvancePartialUpload(db, &upload, models.UploadChunk{
+			Offset: cr.start,
+			Size:   written,
+			Name:   name,
+		})
+		if err != nil || !advanced {
+			removeChunk(base, path, name)
+			if err != nil {
+				slog.Error("Failed to record chunk", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload was modified concurrently"})
+			return
+		}
+	}
+
+	if !upload.Complete() {
+		setUploadProgressHeaders(c, upload)
+		c.JSON(http.StatusAccepted, gin.H{"received": upload.Received})
+		return
+	}
+
+	finishUpload(c, db, base, logQueue, device, upload)
+}
+
+// finishUpload assembles a completed upload into its final file and processes it.
+// If assembling fails, the upload is kept so the next request can try again.
+func finishUpload(c *gin.Context, db *gorm.DB, base storage.Storage, logQueue *logparser.LogQueue, device models.Device, upload models.PartialUpload) {
+	err := assembleUpload(base, upload)
+	if err != nil {
+		slog.Error("Failed to assemble upload", "path", upload.Path, "error", err)
+		setUploadProgressHeaders(c, upload)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	discardPartialUpload(db, base, upload)
+
+	err = uploads.Process(db, base, logQueue, device, upload.Path)
+	if err != nil {
+		if errors.Is(err, uploads.ErrInvalidFile) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// assembleUpload concatenates the chunks of a completed upload into the final file
+func assembleUpload(base storage.Storage, upload models.PartialUpload) error {
+	f, err := base.Create(upload.Path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, chunk := range upload.Chunks {
+		err = copyChunk(w, base, chunkPath(upload.Path, chunk.Name), chunk.Size)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return errors.Join(w.Flush(), f.Close())
+}
+
+func copyChunk(w io.Writer, base storage.Storage, path string, size int64) error {
+	chunk, err := base.Open(path)
+	if err != nil {
+		return err
+	}
+	defer chunk.Close()
+	written, err := io.Copy(w, chunk)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("chunk %s is %d bytes, expected %d", path, written, size)
+	}
+	return nil
+}
+
+func removeChunk(base storage.Storage, path string, name string) {
+	err := base.Remove(chunkPath(path, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Failed to remove chunk", "path", path, "chunk", name, "error", err)
+	}
+}
+
+// discardPartialUpload removes the stored chunks and tracking rows of an upload
+func discardPartialUpload(db *gorm.DB, base storage.Storage, upload models.PartialUpload) {
+	for _, chunk := range upload.Chunks {
+		removeChunk(base, upload.Path, chunk.Name)
+	}
+	// Clean up the now empty chunk directories, stopping at the first one still in use
+	for dir := filepath.Join(partialUploadDir, upload.Path); dir != "." && dir != partialUploadDir; dir = filepath.Dir(dir) {
+		if base.Remove(dir) != nil {
+			break
+		}
+	}
+	err := models.DeletePartialUpload(db, upload.ID)
+	if err != nil {
+		slog.Warn("Failed to delete partial upload", "path", upload.Path, "error", err)
+	}
+}
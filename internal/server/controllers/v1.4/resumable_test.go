@@ -0,0 +1,146 @@
+package v1dot4_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/controllers/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	dongleID   = testutil.DongleID
+	uploadPath = "boot/0000000a--0123456789"
+)
+
+// interruptedReader returns its data and then fails, like a connection dropping mid-upload
+type interruptedReader struct {
+	data io.Reader
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Parallel()
+	cfg := testutil.Config(t)
+	uploadsDir := cfg.Persistence.Uploads.FilesystemOptions.Directory
+	database := testutil.OpenDB(t, cfg)
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device := testutil.NewDevice(t, database)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("db", database)
+		c.Set("storage", store)
+		c.Set("logQueue", (*logparser.LogQueue)(nil))
+		c.Next()
+	})
+	router.HEAD("/v1.4/:dongle_id/upload", v1dot4.HEADUpload)
+	router.PUT("/v1.4/:dongle_id/upload", v1dot4.PUTUpload)
+
+	put := func(contentRange string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v1.4/"+dongleID+"/upload?path="+uploadPath, body)
+		req.Header.Set("Content-Range", contentRange)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	expectProgress := func(w *httptest.ResponseRecorder, status int, offset string) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Upload-Offset"); got != offset {
+			t.Fatalf("expected Upload-Offset %s, got %q", offset, got)
+		}
+	}
+
+	// Nothing has been received, so the device is told to start from zero
+	expectProgress(put("bytes */10", nil), http.StatusRequestedRangeNotSatisfiable, "0")
+	expectProgress(put("bytes 4-9/10", strings.NewReader("456789")), http.StatusRequestedRangeNotSatisfiable, "0")
+
+	expectProgress(put("bytes 0-3/10", strings.NewReader("0123")), http.StatusAccepted, "4")
+
+	// A probe reports what's been received without storing anything
+	expectProgress(put("bytes */10", nil), http.StatusOK, "4")
+	head := httptest.NewRecorder()
+	router.ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/v1.4/"+dongleID+"/upload?path="+uploadPath, nil))
+	expectProgress(head, http.StatusOK, "4")
+	if got := head.Header().Get("Upload-Length"); got != "10" {
+		t.Fatalf("expected Upload-Length 10, got %q", got)
+	}
+
+	// Resending a range that's already been received is refused
+	expectProgress(put("bytes 0-3/10", strings.NewReader("0123")), http.StatusConflict, "4")
+	expectProgress(put("bytes 6-9/10", strings.NewReader("6789")), http.StatusConflict, "4")
+	expectProgress(put("bytes 4-9/11", strings.NewReader("456789")), http.StatusBadRequest, "4")
+
+	// What arrived before the connection dropped is kept
+	expectProgress(put("bytes 4-9/10", &interruptedReader{data: strings.NewReader("45")}), http.StatusAccepted, "6")
+
+	// Resuming from the reported offset completes the file, but it can't be
+	// assembled while something's in the way of the final file
+	blocker := filepath.Join(uploadsDir, dongleID, uploadPath)
+	if err := os.MkdirAll(blocker, 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectProgress(put("bytes 6-9/10", strings.NewReader("6789")), http.StatusInternalServerError, "10")
+
+	// Once it's out of the way, the next request assembles the upload
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := put("bytes */10", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	contents, err := os.ReadFile(filepath.Join(uploadsDir, dongleID, uploadPath))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(contents) != "0123456789" {
+		t.Fatalf("expected the chunks to be assembled in order, got %q", contents)
+	}
+
+	// The chunks and tracking rows are cleaned up and the file is processed
+	if _, err := os.Stat(filepath.Join(uploadsDir, dongleID, ".partial", "boot")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the chunk directories to be removed, got %v", err)
+	}
+	var partials int64
+	if err := database.Model(&models.PartialUpload{}).Count(&partials).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var chunks int64
+	if err := database.Model(&models.UploadChunk{}).Count(&chunks).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if partials != 0 || chunks != 0 {
+		t.Fatalf("expected the partial upload to be deleted, got %d uploads and %d chunks", partials, chunks)
+	}
+	var bootLogs int64
+	if err := database.Model(&models.BootLog{}).Where("device_id = ?", device.ID).Count(&bootLogs).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bootLogs != 1 {
+		t.Fatalf("expected the upload to be processed once, got %d boot logs", bootLogs)
+	}
+}
@@ -2,7 +2,6 @@ package v1dot4
 
 import (
 	"bufio"
-	"encoding/binary"
 	"errors"
 	"io"
 	"io/fs"
@@ -10,23 +9,18 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"regexp"
 	"strings"
 
-	"github.com/USA-RedDragon/rtz-server/internal/config"
+	configPkg "github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/USA-RedDragon/rtz-server/internal/db/models"
 	"github.com/USA-RedDragon/rtz-server/internal/logparser"
 	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
 	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-var (
-	newRouteRegex = regexp.MustCompile(`^(?P<motonic>[0-9a-fA-F]+)--(?P<route>[0-9a-fA-F]+)--(?P<segment>\d+)`)
-	oldRouteRegex = regexp.MustCompile(`^(?P<date>\d{4}-\d{2}-\d{2})--(?P<time>\d{2}-\d{2}-\d{2})`)
-)
-
 func GETUploadURL(c *gin.Context) {
 	dongleID, ok := c.Params.Get("dongle_id")
 	if !ok {
@@ -34,7 +28,7 @@ func GETUploadURL(c *gin.Context) {
 		return
 	}
 
-	config, ok := c.MustGet("config").(*config.Config)
+	config, ok := c.MustGet("config").(*configPkg.Config)
 	if !ok {
 		slog.Error("Failed to get config from context")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
@@ -47,6 +41,11 @@ func GETUploadURL(c *gin.Context) {
 		return
 	}
 
+	if config.Persistence.Uploads.Driver == configPkg.UploadsDriverS3 {
+		presignedUploadURL(c, dongleID, path)
+		return
+	}
+
 	c.JSON(http.StatusOK, v1dot4.UploadURLResponse{
 		URL: config.HTTP.BackendURL + "/v1.4/" + dongleID + "/upload?path=" + url.QueryEscape(path),
 		Headers: map[string]string{
@@ -55,6 +54,46 @@ func GETUploadURL(c *gin.Context) {
 	})
 }
 
+// presignedUploadURL points the device straight at the S3 bucket so the
+// upload doesn't pass through us. The upload watcher picks the file up
+// for processing once it lands.
+func presignedUploadURL(c *gin.Context, dongleID string, path string) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	presigner, ok := c.MustGet("storage").(storage.Presigner)
+	if !ok {
+		slog.Error("Storage driver does not support presigned uploads")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	if !fs.ValidPath(path) || strings.HasPrefix(path, partialUploadDir+"/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return
+	}
+
+	uploadURL, headers, err := uploads.Presign(db, presigner, device, path, uploads.PresignExpiry)
+	if err != nil {
+		slog.Error("Failed to presign upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	c.JSON(http.StatusOK, v1dot4.UploadURLResponse{
+		URL:     uploadURL,
+		Headers: headers,
+	})
+}
+
 func PUTUpload(c *gin.Context) {
 	dongleID, ok := c.Params.Get("dongle_id")
 	if !ok {
@@ -91,7 +130,7 @@ func PUTUpload(c *gin.Context) {
 		return
 	}
 
-	if !fs.ValidPath(path) {
+	if !fs.ValidPath(path) || strings.HasPrefix(path, partialUploadDir+"/") {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
 		return
 	}
@@ -118,6 +157,21 @@ func PUTUpload(c *gin.Context) {
 		return
 	}
 
+	if c.GetHeader("Content-Range") != "" {
+		putUploadChunk(c, db, base, logQueue, device, path)
+		return
+	}
+
+	// A plain PUT replaces whatever a previous chunked upload left behind
+	partial, err := models.FindPartialUpload(db, device.ID, path)
+	if err == nil {
+		discardPartialUpload(db, base, partial)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.Error("Failed to find partial upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
 	fileReader := bufio.NewReader(c.Request.Body)
 	f, err := base.Create(path)
 	if err != nil {
@@ -129,6 +183,7 @@ func PUTUpload(c *gin.Context) {
 	_, err = io.Copy(w, fileReader)
 	if err != nil {
 		slog.Error("Failed to write file", "error", err)
+		removeIncompleteFile(base, f, path)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -136,6 +191,7 @@ func PUTUpload(c *gin.Context) {
 	err = w.Flush()
 	if err != nil {
 		slog.Error("Failed to flush file", "error", err)
+		removeIncompleteFile(base, f, path)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
@@ -147,96 +203,25 @@ func PUTUpload(c *gin.Context) {
 		return
 	}
 
-	switch {
-	case strings.Contains(path, "boot/"):
-		// Boot log
-		err = db.Create(&models.BootLog{
-			DeviceID: device.ID,
-			FileName: filepath.Base(path),
-		}).Error
-		if err != nil {
-			slog.Error("Failed to create boot log", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
-			return
-		}
-	case strings.Contains(path, "crash/"):
-		// Crash log
-		err = db.Create(&models.CrashLog{
-			DeviceID: device.ID,
-			FileName: filepath.Base(path),
-		}).Error
-		if err != nil {
-			slog.Error("Failed to create crash log", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+	err = uploads.Process(db, base, logQueue, device, path)
+	if err != nil {
+		if errors.Is(err, uploads.ErrInvalidFile) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file"})
 			return
 		}
-	case newRouteRegex.Match([]byte(path)):
-		slog.Warn("New route upload", "path", path)
-		match := newRouteRegex.FindStringSubmatch(path)
-		var result v1dot4.RouteInfo
-		for i, name := range newRouteRegex.SubexpNames() {
-			switch name {
-			case "motonic":
-				result.Motonic = match[i]
-			case "route":
-				result.Route = match[i]
-			case "segment":
-				result.Segment = match[i]
-			}
-		}
-
-		// Verify file type
-		switch {
-		case strings.Contains(path, "qlog.bz2"):
-			file, err := base.Open(path)
-			if err != nil {
-				slog.Error("Failed to open file", "error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
-				return
-			}
-			header := make([]byte, 3)
-			read, err := file.Read(header)
-			if err != nil {
-				slog.Error("Failed to read header", "error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
-				return
-			}
-			if read != 3 || string(header) != "BZh" {
-				slog.Error("Invalid header", "header", string(header))
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file"})
-				return
-			}
-			file.Close()
-			go logQueue.AddLog(path, dongleID, result)
-		case strings.Contains(path, "qlog.zst"):
-			file, err := base.Open(path)
-			if err != nil {
-				slog.Error("Failed to open file", "error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
-				return
-			}
-			var magic uint32
-			err = binary.Read(file, binary.LittleEndian, &magic)
-			if err != nil {
-				slog.Error("Failed to read magic", "error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
-				return
-			}
-			// 0xFD2FB528 is the magic number for zstd
-			// https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#zstandard-frames
-			if magic != 0xFD2FB528 {
-				slog.Error("Invalid magic", "magic", magic)
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file"})
-				return
-			}
-			file.Close()
-			go logQueue.AddLog(path, dongleID, result)
-		}
-	case oldRouteRegex.Match([]byte(path)):
-		slog.Warn("Old route upload", "path", path)
-	default:
-		slog.Warn("Got unknown upload path", "path", path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// removeIncompleteFile closes and deletes a file whose upload failed part way
+// so a truncated copy isn't left behind for processing
+func removeIncompleteFile(base storage.Storage, f storage.File, path string) {
+	_ = f.Close()
+	err := base.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Failed to remove incomplete file", "path", path, "error", err)
+	}
+}
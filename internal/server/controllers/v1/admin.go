@@ -0,0 +1,212 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GETLogJobs(c *gin.Context) {
+	state := models.LogJobState(c.Query("state"))
+	switch state {
+	case "", models.LogJobStatePending, models.LogJobStateRunning, models.LogJobStateFailed, models.LogJobStateDone:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be one of pending, running, failed or done"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	jobs, err := models.ListLogJobs(db, state, limit)
+	if err != nil {
+		slog.Error("Failed to list log jobs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	resp := []v1.LogJobResponse{}
+	for _, job := range jobs {
+		resp = append(resp, v1.LogJobResponse{
+			LogJob:   job,
+			DongleID: job.Device.DongleID,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func POSTLogJobRetry(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be an integer"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	logQueue, ok := c.MustGet("logQueue").(*logparser.LogQueue)
+	if !ok {
+		slog.Error("Failed to get log queue from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	job, err := models.FindLogJobByID(db, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		slog.Error("Failed to find log job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	retried, err := logQueue.Retry(job.ID)
+	if err != nil {
+		slog.Error("Failed to retry log job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !retried {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed jobs can be retried"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": 1})
+}
+
+// POSTReprocess parses stored logs again, rebuilding their routes from scratch
+func POSTReprocess(c *gin.Context) {
+	var req v1.ReprocessRequest
+	if err := c.BindJSON(&req); err != nil {
+		slog.Error("Failed to bind request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Route != "" && req.DongleID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "route requires dongle_id"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	logQueue, ok := c.MustGet("logQueue").(*logparser.LogQueue)
+	if !ok {
+		slog.Error("Failed to get log queue from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	logs, err := logparser.FindStoredLogs(db, store, logparser.ReprocessScope{
+		DongleID: req.DongleID,
+		Route:    req.Route,
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+		slog.Error("Failed to find stored logs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	resp := v1.ReprocessResponse{
+		Logs:   []string{},
+		JobIDs: []uint{},
+	}
+	for _, log := range logs {
+		resp.Logs = append(resp.Logs, log.Device.DongleID+"/"+log.Path)
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	ids, err := logQueue.Reprocess(logs)
+	if err != nil {
+		slog.Error("Failed to queue logs for reprocessing", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	resp.JobIDs = ids
+	c.JSON(http.StatusOK, resp)
+}
+
+// GETReprocessProgress reports how far along the jobs queued by POSTReprocess are.
+// The jobs are given as job_ids, either comma separated or repeated.
+func GETReprocessProgress(c *gin.Context) {
+	var jobIDs []uint
+	for _, param := range c.QueryArray("job_ids") {
+		for _, value := range strings.Split(param, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 0)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "job_ids must be a list of integers"})
+				return
+			}
+			jobIDs = append(jobIDs, uint(id))
+		}
+	}
+	if len(jobIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "job_ids is required"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	counts, err := models.CountLogJobsByIDs(db, jobIDs)
+	if err != nil {
+		slog.Error("Failed to count log jobs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	c.JSON(http.StatusOK, v1.ReprocessProgressResponse{
+		Total:   len(jobIDs),
+		Pending: counts[models.LogJobStatePending],
+		Running: counts[models.LogJobStateRunning],
+		Done:    counts[models.LogJobStateDone],
+		Failed:  counts[models.LogJobStateFailed],
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/USA-RedDragon/rtz-server/internal/db/models"
 	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
 	"github.com/USA-RedDragon/rtz-server/internal/utils"
@@ -19,6 +20,36 @@ import (
 const commaAPIHost = "api.comma.ai"
 const schemeHTTPS = "https"
 
+// routeShareExpiry is how long the share signatures handed out with routes stay valid
+const routeShareExpiry = 7 * 24 * time.Hour
+
+// deviceFromParam looks up the device in the dongle_id param. It writes the
+// error response itself and returns false if the device can't be found.
+func deviceFromParam(c *gin.Context) (*gorm.DB, models.Device, bool) {
+	dongleID, ok := c.Params.Get("dongle_id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dongle_id is required"})
+		return nil, models.Device{}, false
+	}
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, models.Device{}, false
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		} else {
+			slog.Error("Failed to find device by dongle ID", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		}
+		return nil, models.Device{}, false
+	}
+	return db, device, true
+}
+
 func PATCHDevice(c *gin.Context) {
 	dongleID, ok := c.Params.Get("dongle_id")
 	if !ok {
@@ -230,6 +261,39 @@ func GETDeviceLocation(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// GETDeviceVehicles lists the vehicles a device has driven and how far it drove each
+func GETDeviceVehicles(c *gin.Context) {
+	_, ok := c.Get("demo")
+	if ok {
+		c.JSON(http.StatusOK, []models.VehicleMileage{})
+		return
+	}
+	dongleID, ok := c.Params.Get("dongle_id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dongle_id is required"})
+		return
+	}
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	vehicles, err := models.FindVehicleMileageByDeviceID(db, device.ID)
+	if err != nil {
+		slog.Error("Failed to find vehicles", "device", device.DongleID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	c.JSON(http.StatusOK, vehicles)
+}
+
 func GETDeviceRoutesSegments(c *gin.Context) {
 	_, ok := c.Get("demo")
 	if ok {
@@ -299,6 +363,12 @@ func GETDeviceRoutesSegments(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
 		return
 	}
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
 
 	device, err := models.FindDeviceByDongleID(db, dongleID)
 	if err != nil {
@@ -314,8 +384,23 @@ func GETDeviceRoutesSegments(c *gin.Context) {
 		return
 	}
 
+	shareExp := time.Now().Add(routeShareExpiry).Unix()
 	routeSegmentsResponse := []v1.RouteSegmentsResponse{}
 	for _, route := range routes {
+		fullName := device.DongleID + "|" + route.RouteID + ":" + strconv.FormatInt(int64(route.ID), 10)
+		shareSig := utils.RouteShareSignature(config.JWT.Secret, fullName, shareExp)
+		vin := ""
+		if route.Vehicle != nil {
+			vin = route.Vehicle.VIN
+		}
+		segmentNumbers := make([]int, 0, len(route.Segments))
+		segmentStartTimes := make([]int64, 0, len(route.Segments))
+		segmentEndTimes := make([]int64, 0, len(route.Segments))
+		for _, segment := range route.Segments {
+			segmentNumbers = append(segmentNumbers, segment.Number)
+			segmentStartTimes = append(segmentStartTimes, time.Unix(0, segment.StartTime).UnixMilli())
+			segmentEndTimes = append(segmentEndTimes, time.Unix(0, segment.EndTime).UnixMilli())
+		}
 		routeSegmentsResponse = append(routeSegmentsResponse, v1.RouteSegmentsResponse{
 			CAN:                true, // TODO: Implement
 			CreationTime:       route.CreatedAt.Unix(),
@@ -329,40 +414,51 @@ func GETDeviceRoutesSegments(c *gin.Context) {
 			GitCommit:          route.GitCommit,
 			GitDirty:           route.GitDirty,
 			GitRemote:          route.GitRemote,
-			FullName:           device.DongleID + "|" + route.RouteID + ":" + strconv.FormatInt(int64(route.ID), 10),
+			FullName:           fullName,
 			HPGPS:              false, // TODO: Implement
 			InitLogMonoTime:    route.InitLogMonoTime,
 			IsPreserved:        route.IsPreserved,
 			IsPublic:           route.IsPublic,
 			Length:             route.Length,
-			MaxCamera:          -1,    // TODO: Implement
-			MaxDCamera:         -1,    // TODO: Implement
-			MaxECamera:         -1,    // TODO: Implement
-			MaxLog:             -1,    // TODO: Implement
-			MaxQCamera:         -1,    // TODO: Implement
-			MaxQLog:            -1,    // TODO: Implement
+			MaxCamera:          lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileFCamera),
+			MaxDCamera:         lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileDCamera),
+			MaxECamera:         lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileECamera),
+			MaxLog:             lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileRLog),
+			MaxQCamera:         lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileQCamera),
+			MaxQLog:            lastSegment(route.Segments, models.Segment.Uploaded, models.SegmentFileQLog),
 			Passive:            false, // TODO: Implement
 			Platform:           route.Platform,
-			ProcCamera:         -1, // TODO: Implement
-			ProcLog:            -1, // TODO: Implement
-			ProcQCamera:        -1, // TODO: Implement
-			ProcQLog:           -1, // TODO: Implement
+			ProcCamera:         lastSegment(route.Segments, models.Segment.Processed, models.SegmentFileFCamera),
+			ProcLog:            lastSegment(route.Segments, models.Segment.Processed, models.SegmentFileRLog),
+			ProcQCamera:        lastSegment(route.Segments, models.Segment.Processed, models.SegmentFileQCamera),
+			ProcQLog:           lastSegment(route.Segments, models.Segment.Processed, models.SegmentFileQLog),
 			Radar:              route.Radar,
-			SegmentEndTimes:    []int64{}, // TODO: Implement
-			SegmentStartTimes:  []int64{}, // TODO: Implement
-			SegmentNumbers:     []int{},   // TODO: Implement
-			ShareExp:           "",        // TODO: Implement
-			ShareSig:           "",        // TODO: Implement
+			SegmentEndTimes:    segmentEndTimes,
+			SegmentStartTimes:  segmentStartTimes,
+			SegmentNumbers:     segmentNumbers,
+			ShareExp:           strconv.FormatInt(shareExp, 10),
+			ShareSig:           shareSig,
 			StartLat:           route.StartLat,
 			StartLng:           route.StartLng,
 			StartTime:          route.StartTime.Format("2006-01-02T15:04:05"),
 			StartTimeUTCMillis: route.StartTime.UnixMilli(),
-			URL:                route.URL,
+			URL:                routeDerivedURL(config, fullName, shareExp, shareSig),
 			UserID:             device.OwnerID,
 			Version:            route.Version,
-			VIN:                "", // TODO: Implement
+			VIN:                vin,
 		})
 	}
 
 	c.JSON(http.StatusOK, routeSegmentsResponse)
 }
+
+// lastSegment returns the highest segment number for which has reports true, or -1 if there's none
+func lastSegment(segments []models.Segment, has func(models.Segment, models.SegmentFile) bool, file models.SegmentFile) int {
+	last := -1
+	for _, segment := range segments {
+		if has(segment, file) {
+			last = max(last, segment.Number)
+		}
+	}
+	return last
+}
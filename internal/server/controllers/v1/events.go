@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// routeEvent converts a stored segment event to comma's events.json format
+func routeEvent(route models.Route, segment models.Segment, event models.SegmentEvent) v1.RouteEvent {
+	// The route's init time is only known for certain once its first segment has been
+	// processed, until then openpilot's minute long segments are assumed
+	routeOffset := int64(segment.Number)*defaultSegmentDuration.Milliseconds() + event.OffsetMillis
+	if route.InitLogMonoTime != 0 && event.LogMonoTime >= route.InitLogMonoTime {
+		routeOffset = int64((event.LogMonoTime - route.InitLogMonoTime) / uint64(time.Millisecond))
+	}
+
+	data := map[string]any{}
+	//nolint:golint,exhaustive
+	switch event.Type {
+	case models.SegmentEventTypeState, models.SegmentEventTypeEngage, models.SegmentEventTypeDisengage:
+		data["state"] = event.State
+		data["enabled"] = event.Enabled
+		data["alertStatus"] = event.AlertStatus
+	case models.SegmentEventTypeAlert:
+		data["alertStatus"] = event.AlertStatus
+		data["alertSize"] = event.AlertSize
+		data["alertType"] = event.AlertType
+		data["alertText1"] = event.AlertText1
+		data["alertText2"] = event.AlertText2
+		data["should_take_control"] = event.AlertStatus == "critical"
+	}
+
+	return v1.RouteEvent{
+		Type:              string(event.Type),
+		Time:              float64(event.LogMonoTime) / float64(time.Second),
+		OffsetMillis:      event.OffsetMillis,
+		RouteOffsetMillis: routeOffset,
+		Data:              data,
+	}
+}
+
+// GETRouteSegmentEvents serves a segment's timeline in the format of comma's events.json
+func GETRouteSegmentEvents(c *gin.Context) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("segment"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+		return
+	}
+
+	route, ok := routeFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	response := []v1.RouteEvent{}
+	segment, err := models.FindSegmentByRouteIDAndNumber(db, route.ID, number)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		slog.Error("Failed to find segment", "route", c.Param("id"), "segment", number, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	events, err := models.FindSegmentEventsBySegmentID(db, segment.ID)
+	if err != nil {
+		slog.Error("Failed to find segment events", "route", c.Param("id"), "segment", number, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	for _, event := range events {
+		response = append(response, routeEvent(route, segment, event))
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,171 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// fileURLExpiry is how long the signed URLs handed out for route files stay valid
+const fileURLExpiry = 24 * time.Hour
+
+//nolint:golint,gochecknoglobals
+var fileContentTypes = map[string]string{
+	".bz2":  "application/x-bzip2",
+	".zst":  "application/zstd",
+	".hevc": "video/hevc",
+	".ts":   "video/mp2t",
+	".jpg":  "image/jpeg",
+}
+
+// parseRouteID splits a <dongle_id>|<route> id. The fullnames we hand out in
+// the routes API carry a :<id> suffix with the route's database ID, which is
+// returned separately as routeDBID, or 0 if there isn't one.
+func parseRouteID(id string) (dongleID string, route string, routeDBID uint, ok bool) {
+	dongleID, route, ok = strings.Cut(id, "|")
+	if !ok || !fs.ValidPath(dongleID) || !fs.ValidPath(route) || strings.Contains(dongleID, "/") || strings.Contains(route, "/") {
+		return "", "", 0, false
+	}
+	route, suffix, hasSuffix := strings.Cut(route, ":")
+	if hasSuffix {
+		parsed, err := strconv.ParseUint(suffix, 10, 0)
+		if err != nil {
+			return "", "", 0, false
+		}
+		routeDBID = uint(parsed)
+	}
+	return dongleID, route, routeDBID, true
+}
+
+// findRoute looks up the processed route a parsed route id refers to
+func findRoute(db *gorm.DB, deviceID uint, route string, routeDBID uint) (models.Route, error) {
+	if routeDBID != 0 {
+		return models.FindRouteByDeviceIDAndID(db, deviceID, routeDBID)
+	}
+	// Full route names are <counter>--<route id>, we only store the route id
+	if i := strings.LastIndex(route, "--"); i >= 0 {
+		route = route[i+2:]
+	}
+	return models.FindRouteByDeviceIDAndRouteID(db, deviceID, route)
+}
+
+// routeFromParam looks up the route in the id param. It writes the error
+// response itself and returns false if the route can't be found.
+func routeFromParam(c *gin.Context, db *gorm.DB) (models.Route, bool) {
+	dongleID, routeName, routeDBID, ok := parseRouteID(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route"})
+		return models.Route{}, false
+	}
+
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		} else {
+			slog.Error("Failed to find device by dongle ID", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		}
+		return models.Route{}, false
+	}
+
+	route, err := findRoute(db, device.ID, routeName, routeDBID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		} else {
+			slog.Error("Failed to find route", "route", c.Param("id"), "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		}
+		return models.Route{}, false
+	}
+	return route, true
+}
+
+// signedFileURL returns a URL that allows downloading a stored file without other auth.
+// name is relative to the root of the uploads storage.
+func signedFileURL(config *config.Config, name string) (string, error) {
+	token, err := utils.GenerateFileJWT(config.JWT.Secret, name, fileURLExpiry)
+	if err != nil {
+		return "", err
+	}
+	return config.HTTP.BackendURL + "/v1/files/" + name + "?sig=" + url.QueryEscape(token), nil
+}
+
+// GETFile serves a stored file to anyone holding a valid signed URL for it
+func GETFile(c *gin.Context) {
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	name := strings.TrimPrefix(c.Param("path"), "/")
+	if !fs.ValidPath(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return
+	}
+
+	signedName, err := utils.VerifyFileJWT(config.JWT.Secret, c.Query("sig"))
+	if err != nil || signedName != name {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	serveFile(c, store, name)
+}
+
+// serveFile streams a stored file, honoring Range and conditional requests
+func serveFile(c *gin.Context, store storage.Storage, name string) {
+	info, err := store.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		slog.Error("Failed to stat file", "path", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	f, err := store.Open(name)
+	if err != nil {
+		slog.Error("Failed to open file", "path", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	defer f.Close()
+
+	if contentType, ok := fileContentTypes[path.Ext(name)]; ok {
+		c.Header("Content-Type", contentType)
+	}
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	// Uploaded files never change, so they can be cached for as long as the URL is valid
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(fileURLExpiry.Seconds())))
+	http.ServeContent(c.Writer, c.Request, path.Base(name), info.ModTime(), f)
+}
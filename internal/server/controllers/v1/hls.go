@@ -0,0 +1,56 @@
+package v1
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+)
+
+// openpilot rotates segments every minute, used when a segment hasn't been processed yet
+const defaultSegmentDuration = time.Minute
+
+type playlistSegment struct {
+	number   int
+	url      string
+	duration time.Duration
+}
+
+// segmentDurations maps segment numbers to their length from a route's processed segments
+func segmentDurations(segments []models.Segment) map[int]time.Duration {
+	durations := map[int]time.Duration{}
+	for _, segment := range segments {
+		duration := time.Duration(segment.EndTime - segment.StartTime)
+		if duration > 0 {
+			durations[segment.Number] = duration
+		}
+	}
+	return durations
+}
+
+// qcameraPlaylist renders a VOD HLS playlist of qcamera segments ordered by segment number
+func qcameraPlaylist(segments []playlistSegment) string {
+	targetDuration := 0.0
+	for _, segment := range segments {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.duration.Seconds()))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(targetDuration))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, segment := range segments {
+		// Let the player know to reset its timeline across segments that were never uploaded
+		if i > 0 && segment.number != segments[i-1].number+1 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", segment.duration.Seconds())
+		b.WriteString(segment.url + "\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
@@ -1,15 +1,21 @@
 package v1
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"path"
 	"strings"
 
+	"github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
 	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
 	"github.com/USA-RedDragon/rtz-server/internal/utils"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -56,7 +62,131 @@ func GETRouteFiles(c *gin.Context) {
 		c.Data(http.StatusOK, "application/json", bodyBytes)
 		return
 	}
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
+
+	resp := v1.RouteFilesResponse{
+		Cameras:       []string{},
+		DriverCameras: []string{},
+		WideCameras:   []string{},
+		Logs:          []string{},
+		QCameras:      []string{},
+		QLogs:         []string{},
+	}
+
+	// The demo token skips the ownership check, so it only gets to see the demo device's files
+	_, ok = c.Get("demo")
+	if ok {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	_, routeName, routeDBID, ok := parseRouteID(id)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route"})
+		return
+	}
+
+	device, err := models.FindDeviceByDongleID(db, deviceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		} else {
+			slog.Error("Failed to find device by dongle ID", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		}
+		return
+	}
+
+	// The files are listed from storage, so a route that's been uploaded but
+	// not parsed yet has its files listed all the same. Only a route that's
+	// neither parsed nor in storage is a 404.
+	parsed := true
+	_, err = findRoute(db, device.ID, routeName, routeDBID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.Error("Failed to find route", "route", id, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+		parsed = false
+	}
+
+	base, err := store.Sub(deviceID)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing has been uploaded by this device yet
+			if !parsed {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		slog.Error("Failed to get base storage", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	defer base.Close()
+
+	segments, err := routefiles.List(base, routeName)
+	if err != nil {
+		slog.Error("Failed to list route files", "route", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if len(segments) == 0 && !parsed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+
+	for _, segment := range segments {
+		for _, kind := range routefiles.Kinds {
+			name, ok := segment.Files[kind]
+			if !ok {
+				continue
+			}
+			fileURL, err := signedFileURL(config, path.Join(deviceID, segment.Dir, name))
+			if err != nil {
+				slog.Error("Failed to sign file URL", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+				return
+			}
+			switch kind {
+			case routefiles.KindCamera:
+				resp.Cameras = append(resp.Cameras, fileURL)
+			case routefiles.KindDriverCamera:
+				resp.DriverCameras = append(resp.DriverCameras, fileURL)
+			case routefiles.KindWideCamera:
+				resp.WideCameras = append(resp.WideCameras, fileURL)
+			case routefiles.KindLog:
+				resp.Logs = append(resp.Logs, fileURL)
+			case routefiles.KindQCamera:
+				resp.QCameras = append(resp.QCameras, fileURL)
+			case routefiles.KindQLog:
+				resp.QLogs = append(resp.QLogs, fileURL)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func GETAthenaOfflineQueue(c *gin.Context) {
@@ -65,7 +195,51 @@ func GETAthenaOfflineQueue(c *gin.Context) {
 		c.Data(http.StatusOK, "application/json", []byte("[]"))
 		return
 	}
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
+	dongleID, ok := c.Params.Get("dongle_id")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dongle_id is required"})
+		return
+	}
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	device, err := models.FindDeviceByDongleID(db, dongleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	calls, err := models.ListAthenaOfflineCalls(db, device.ID)
+	if err != nil {
+		slog.Error("Failed to list athena offline calls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	queue := make([]v1.AthenaOfflineQueueEntry, 0, len(calls))
+	for _, call := range calls {
+		var params any
+		if call.Params != "" {
+			err := json.Unmarshal([]byte(call.Params), &params)
+			if err != nil {
+				slog.Error("Failed to unmarshal athena offline call params", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+				return
+			}
+		}
+		queue = append(queue, v1.AthenaOfflineQueueEntry{
+			ID:             call.CallID,
+			Method:         call.Method,
+			JSONRPCVersion: call.JSONRPCVersion,
+			Params:         params,
+			Expiry:         call.Expiry.Unix(),
+		})
+	}
+
+	c.JSON(http.StatusOK, queue)
 }
 
 func GETRouteQCameraM3U8(c *gin.Context) {
@@ -109,7 +283,117 @@ func GETRouteQCameraM3U8(c *gin.Context) {
 		c.Data(http.StatusOK, "application/x-mpegURL", bodyBytes)
 		return
 	}
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
+
+	// The demo token skips the ownership check, so it only gets to see the demo device's video
+	_, ok = c.Get("demo")
+	if ok {
+		c.Data(http.StatusOK, "application/x-mpegURL", []byte(qcameraPlaylist(nil)))
+		return
+	}
+
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	_, routeName, routeDBID, ok := parseRouteID(id)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route"})
+		return
+	}
+
+	device, err := models.FindDeviceByDongleID(db, deviceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		} else {
+			slog.Error("Failed to find device by dongle ID", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		}
+		return
+	}
+
+	// Uploaded segments that haven't been processed yet fall back to the default length
+	var routeSegments []models.Segment
+	route, err := findRoute(db, device.ID, routeName, routeDBID)
+	switch {
+	case err == nil:
+		routeSegments, err = models.FindSegmentsByRouteID(db, route.ID)
+		if err != nil {
+			slog.Error("Failed to find route segments", "route", id, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		slog.Error("Failed to find route", "route", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	durations := segmentDurations(routeSegments)
+
+	base, err := store.Sub(deviceID)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		slog.Error("Failed to get base storage", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	defer base.Close()
+
+	segments, err := routefiles.List(base, routeName)
+	if err != nil {
+		slog.Error("Failed to list route files", "route", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	var playlist []playlistSegment
+	for _, segment := range segments {
+		name, ok := segment.Files[routefiles.KindQCamera]
+		if !ok {
+			continue
+		}
+		fileURL, err := signedFileURL(config, path.Join(deviceID, segment.Dir, name))
+		if err != nil {
+			slog.Error("Failed to sign file URL", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+		duration, ok := durations[segment.Number]
+		if !ok {
+			duration = defaultSegmentDuration
+		}
+		playlist = append(playlist, playlistSegment{
+			number:   segment.Number,
+			url:      fileURL,
+			duration: duration,
+		})
+	}
+	if len(playlist) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	// The segment URLs are signed, so the playlist mustn't outlive them
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "application/x-mpegURL", []byte(qcameraPlaylist(playlist)))
 }
 
 func GETMe(c *gin.Context) {
@@ -0,0 +1,216 @@
+package v1
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/metrics"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/server/websocket"
+	ws "github.com/USA-RedDragon/rtz-server/internal/websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	gorillaWebsocket "github.com/gorilla/websocket"
+	"github.com/mattn/go-nulltype"
+	"gorm.io/gorm"
+)
+
+// maxProxySessionLimit caps how many proxy sessions are returned at once
+const maxProxySessionLimit = 1000
+
+// GETDeviceProxy forwards a port on a device, SSH by default, to the caller's
+// websocket through startLocalProxy. Binary messages sent on the websocket are
+// written to the port and what's read from the port comes back as binary
+// messages. Every session is recorded for auditing.
+//
+// Sessions aren't shared between instances, so the device has to be connected
+// to the instance serving the request. Rather than asking another instance's
+// device to connect back to an instance that doesn't know the session, the
+// request is refused with a 409 when NATS is enabled and the device isn't
+// connected here.
+func GETDeviceProxy(c *gin.Context) {
+	port, err := strconv.Atoi(c.DefaultQuery("port", "22"))
+	if err != nil || port <= 0 || port > 65535 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "port must be between 1 and 65535"})
+		return
+	}
+	if !gorillaWebsocket.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a websocket upgrade"})
+		return
+	}
+
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	user, ok := c.MustGet("user").(*models.User)
+	if !ok {
+		slog.Error("Failed to get user from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	rpcCaller, ok := c.MustGet("rpcWebsocket").(*websocket.RPCWebsocket)
+	if !ok {
+		slog.Error("Failed to get rpc from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	db, device, ok := deviceFromParam(c)
+	if !ok {
+		return
+	}
+	metrics, ok := c.MustGet("metrics").(*metrics.Metrics)
+	if !ok {
+		slog.Error("Failed to get metrics from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if !rpcCaller.ConnectedLocally(device.DongleID) {
+		if config.NATS.Enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Dongle is not connected to this instance, proxy sessions have to be started on the instance the dongle is connected to"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dongle not connected"})
+		return
+	}
+
+	session := models.ProxySession{
+		DeviceID:   device.ID,
+		UserID:     user.ID,
+		Port:       port,
+		ClientAddr: c.ClientIP(),
+	}
+	err = models.CreateProxySession(db, &session)
+	if err != nil {
+		slog.Error("Failed to create proxy session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	slog.Info("Starting proxy session", "session", session.ID, "device", device.DongleID, "user", user.ID, "port", port, "client", session.ClientAddr)
+
+	id, deviceConns, err := rpcCaller.OpenProxy(device.DongleID)
+	if err != nil {
+		endProxySession(db, &session, err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	defer rpcCaller.CloseProxy(id)
+
+	callID, err := uuid.NewRandom()
+	if err != nil {
+		endProxySession(db, &session, err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	result := make(chan error, 1)
+	go func() {
+		// The call is never sent over NATS, the device has to connect back here
+		resp, err := rpcCaller.Call(c, nil, metrics, device.DongleID, apimodels.RPCCall{
+			ID:             callID.String(),
+			Method:         "startLocalProxy",
+			JSONRPCVersion: "2.0",
+			Params: map[string]any{
+				"remote_ws_uri": websocket.ProxyURL(config, device.DongleID, id),
+				"local_port":    port,
+			},
+		})
+		if err == nil && resp.Error != "" {
+			err = errors.New(resp.Error)
+		}
+		result <- err
+	}()
+
+	// The device connects back before it responds, but it can still fail the
+	// call or drop off before it does
+	timeout := time.NewTimer(websocket.ProxyConnectTimeout)
+	defer timeout.Stop()
+	var deviceConn *gorillaWebsocket.Conn
+	for deviceConn == nil {
+		select {
+		case deviceConn = <-deviceConns:
+		case err := <-result:
+			switch {
+			case deviceOffline(err):
+				endProxySession(db, &session, "device not connected")
+				c.JSON(http.StatusNotFound, gin.H{"error": "Dongle not connected"})
+				return
+			case err != nil:
+				slog.Warn("Failed to start local proxy", "device", device.DongleID, "error", err)
+				endProxySession(db, &session, err.Error())
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Device failed to start the proxy"})
+				return
+			}
+			// Stop waiting for a result
+			result = nil
+		case <-timeout.C:
+			endProxySession(db, &session, "timed out waiting for the device to connect")
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for the device"})
+			return
+		}
+	}
+
+	upgrader := gorillaWebsocket.Upgrader{
+		ReadBufferSize:  32 * 1024,
+		WriteBufferSize: 32 * 1024,
+		CheckOrigin:     ws.CheckOrigin(config),
+	}
+	clientConn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already responded
+		_ = deviceConn.Close()
+		endProxySession(db, &session, err.Error())
+		return
+	}
+
+	session.ConnectedAt = nulltype.NullTimeOf(time.Now())
+	err = models.UpdateProxySession(db, &session)
+	if err != nil {
+		slog.Warn("Failed to update proxy session", "session", session.ID, "error", err)
+	}
+	session.BytesToDevice, session.BytesFromDevice = ws.Bridge(clientConn, deviceConn)
+	endProxySession(db, &session, "")
+}
+
+// endProxySession records the end of a proxy session, and why it failed if it did
+func endProxySession(db *gorm.DB, session *models.ProxySession, reason string) {
+	session.EndedAt = nulltype.NullTimeOf(time.Now())
+	session.Error = reason
+	err := models.UpdateProxySession(db, session)
+	if err != nil {
+		slog.Error("Failed to update proxy session", "session", session.ID, "error", err)
+	}
+	slog.Info("Ended proxy session", "session", session.ID, "user", session.UserID,
+		"bytes_to_device", session.BytesToDevice, "bytes_from_device", session.BytesFromDevice, "error", reason)
+}
+
+// GETDeviceProxySessions lists the audit records of a device's proxy sessions, newest first
+func GETDeviceProxySessions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > maxProxySessionLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer up to " + strconv.Itoa(maxProxySessionLimit)})
+		return
+	}
+	db, device, ok := deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := models.ListProxySessions(db, device.ID, limit)
+	if err != nil {
+		slog.Error("Failed to list proxy sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if sessions == nil {
+		sessions = []models.ProxySession{}
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
@@ -0,0 +1,170 @@
+package v1
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
+	"github.com/USA-RedDragon/rtz-server/internal/telemetry"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTelemetrySpan is how far back telemetry is returned from when no start is given
+const defaultTelemetrySpan = 24 * time.Hour
+
+// telemetryTimeRange reads the start and end query parameters in milliseconds
+// since the epoch, defaulting to the day up to now
+func telemetryTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	end := time.Now()
+	if param := c.Query("end"); param != "" {
+		millis, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end must be an integer"})
+			return time.Time{}, time.Time{}, false
+		}
+		end = time.UnixMilli(millis)
+	}
+	start := end.Add(-defaultTelemetrySpan)
+	if param := c.Query("start"); param != "" {
+		millis, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start must be an integer"})
+			return time.Time{}, time.Time{}, false
+		}
+		start = time.UnixMilli(millis)
+	}
+	if start.After(end) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+func GETDeviceMetricNames(c *gin.Context) {
+	db, device, ok := deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	names, err := models.ListDeviceMetricNames(db, device.ID)
+	if err != nil {
+		slog.Error("Failed to list device metric names", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	c.JSON(http.StatusOK, names)
+}
+
+// maxDeviceMetricLimit caps how many metric points are returned at once
+const maxDeviceMetricLimit = 10000
+
+func GETDeviceMetric(c *gin.Context) {
+	name := c.Param("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	start, end, ok := telemetryTimeRange(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit <= 0 || limit > maxDeviceMetricLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer up to " + strconv.Itoa(maxDeviceMetricLimit)})
+		return
+	}
+	db, device, ok := deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	metrics, err := models.FindDeviceMetrics(db, device.ID, name, start, end, limit)
+	if err != nil {
+		slog.Error("Failed to find device metrics", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	resp := v1.DeviceMetricResponse{
+		Name:   name,
+		Points: make([]v1.DeviceMetricPoint, 0, len(metrics)),
+	}
+	for _, metric := range metrics {
+		tags := map[string]string{}
+		if metric.Tags != "" {
+			err := json.Unmarshal([]byte(metric.Tags), &tags)
+			if err != nil {
+				slog.Warn("Failed to unmarshal device metric tags", "error", err)
+			}
+		}
+		resp.Points = append(resp.Points, v1.DeviceMetricPoint{
+			Field: metric.Field,
+			Value: metric.Value,
+			Time:  metric.Time.UnixMilli(),
+			Tags:  tags,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// maxDeviceLogLimit caps how many log lines are returned at once
+const maxDeviceLogLimit = 10000
+
+func GETDeviceLogs(c *gin.Context) {
+	start, end, ok := telemetryTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := models.DeviceLogFilter{
+		Start:    start,
+		End:      end,
+		Contains: c.Query("contains"),
+	}
+	if level := c.Query("level"); level != "" {
+		filter.MinLevelNum, ok = telemetry.LogLevels[strings.ToUpper(level)]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "level must be one of debug, info, warning, error or critical"})
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil || limit <= 0 || limit > maxDeviceLogLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer up to " + strconv.Itoa(maxDeviceLogLimit)})
+		return
+	}
+	filter.Limit = limit
+
+	db, device, ok := deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	logs, err := models.FindDeviceLogs(db, device.ID, filter)
+	if err != nil {
+		slog.Error("Failed to find device logs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	resp := make([]v1.DeviceLogLine, 0, len(logs))
+	for _, log := range logs {
+		resp = append(resp, v1.DeviceLogLine{
+			Time:    log.Time.UnixMilli(),
+			Level:   log.Level,
+			Daemon:  log.Daemon,
+			Message: log.Message,
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
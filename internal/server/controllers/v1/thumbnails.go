@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/sprite"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// spriteTileWidth is the width thumbnails are scaled to in sprites
+const spriteTileWidth = 160
+
+// routeThumbnails loads the thumbnails of the route in the id param.
+// It writes the error response itself and returns false if they can't be loaded.
+func routeThumbnails(c *gin.Context) (storage.Storage, []models.SegmentThumbnail, bool) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, nil, false
+	}
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, nil, false
+	}
+
+	route, ok := routeFromParam(c, db)
+	if !ok {
+		return nil, nil, false
+	}
+
+	thumbnails, err := models.FindThumbnailsByRouteID(db, route.ID)
+	if err != nil {
+		slog.Error("Failed to find route thumbnails", "route", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, nil, false
+	}
+	return store, thumbnails, true
+}
+
+func readThumbnail(store storage.Storage, thumbnail models.SegmentThumbnail) ([]byte, error) {
+	f, err := store.Open(thumbnail.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func renderSprite(c *gin.Context, store storage.Storage, thumbnails []models.SegmentThumbnail, vertical bool) {
+	if len(thumbnails) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+	images := make([][]byte, 0, len(thumbnails))
+	for _, thumbnail := range thumbnails {
+		data, err := readThumbnail(store, thumbnail)
+		if err != nil {
+			slog.Error("Failed to read thumbnail", "path", thumbnail.Path, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+			return
+		}
+		images = append(images, data)
+	}
+	body, err := sprite.Compose(images, spriteTileWidth, vertical)
+	if err != nil {
+		slog.Error("Failed to compose sprite", "route", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(int(fileURLExpiry.Seconds())))
+	c.Data(http.StatusOK, "image/jpeg", body)
+}
+
+// GETRouteThumbnail serves a single thumbnail of a segment
+func GETRouteThumbnail(c *gin.Context) {
+	// The demo token skips the ownership check, so it isn't shown any thumbnails
+	_, ok := c.Get("demo")
+	if ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+	number, err := strconv.Atoi(c.Param("segment"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid index"})
+		return
+	}
+
+	store, thumbnails, ok := routeThumbnails(c)
+	if !ok {
+		return
+	}
+	for _, thumbnail := range thumbnails {
+		if thumbnail.Number == number && thumbnail.Index == index {
+			serveFile(c, store, thumbnail.Path)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+}
+
+// GETRouteSprite serves a preview of a route, with the first thumbnail of each segment side by side
+func GETRouteSprite(c *gin.Context) {
+	_, ok := c.Get("demo")
+	if ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+	store, thumbnails, ok := routeThumbnails(c)
+	if !ok {
+		return
+	}
+	var first []models.SegmentThumbnail
+	for _, thumbnail := range thumbnails {
+		if len(first) == 0 || first[len(first)-1].Number != thumbnail.Number {
+			first = append(first, thumbnail)
+		}
+	}
+	renderSprite(c, store, first, false)
+}
+
+// GETRouteSegmentSprite serves every thumbnail of a segment stacked top to bottom, like comma's sprite.jpg
+func GETRouteSegmentSprite(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("segment"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+		return
+	}
+
+	store, thumbnails, ok := routeThumbnails(c)
+	if !ok {
+		return
+	}
+	var segment []models.SegmentThumbnail
+	for _, thumbnail := range thumbnails {
+		if thumbnail.Number == number {
+			segment = append(segment, thumbnail)
+		}
+	}
+	renderSprite(c, store, segment, true)
+}
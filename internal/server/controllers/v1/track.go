@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/track"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// routeDerivedURL returns the base URL of the files derived from a route's logs.
+// Connect appends /<segment>/<file> to a route's url without any auth, so the
+// route's share signature is carried in the path.
+func routeDerivedURL(config *config.Config, fullName string, shareExp int64, shareSig string) string {
+	return config.HTTP.BackendURL + "/v1/route/" + url.PathEscape(fullName) + "/derived/" + strconv.FormatInt(shareExp, 10) + "/" + shareSig
+}
+
+// routeTrack loads the stored track of the route in the id param, split into its segments.
+// It writes the error response itself and returns false if the track can't be loaded.
+func routeTrack(c *gin.Context) (models.Route, []track.Segment, bool) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return models.Route{}, nil, false
+	}
+
+	route, ok := routeFromParam(c, db)
+	if !ok {
+		return models.Route{}, nil, false
+	}
+
+	points, err := models.FindTrackPointsByRouteID(db, route.ID)
+	if err != nil {
+		slog.Error("Failed to find route track", "route", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return models.Route{}, nil, false
+	}
+
+	// Stored distances restart with each segment
+	var segments []track.Segment
+	var offset, segmentDistance float64
+	for _, point := range points {
+		if len(segments) == 0 || segments[len(segments)-1].Number != point.Number {
+			offset += segmentDistance
+			segments = append(segments, track.Segment{Number: point.Number})
+		}
+		segmentDistance = point.Distance
+		var t time.Time
+		if point.Time > 0 {
+			t = time.Unix(0, point.Time)
+		}
+		segment := &segments[len(segments)-1]
+		segment.Points = append(segment.Points, track.Point{
+			Time:      t,
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Speed:     point.Speed,
+			Distance:  offset + point.Distance,
+		})
+	}
+	return route, segments, true
+}
+
+func renderRouteTrack(c *gin.Context, contentType string, extension string, render func(string, []track.Segment) ([]byte, error)) {
+	var segments []track.Segment
+	// The demo token skips the ownership check, so it only gets an empty track
+	_, ok := c.Get("demo")
+	if !ok {
+		_, segments, ok = routeTrack(c)
+		if !ok {
+			return
+		}
+	}
+	body, err := render(c.Param("id"), segments)
+	if err != nil {
+		slog.Error("Failed to render route track", "route", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	_, routeName, _, _ := parseRouteID(c.Param("id"))
+	c.Header("Content-Disposition", `attachment; filename="`+routeName+extension+`"`)
+	c.Data(http.StatusOK, contentType, body)
+}
+
+func GETRouteTrackGeoJSON(c *gin.Context) {
+	renderRouteTrack(c, "application/geo+json", ".geojson", track.GeoJSON)
+}
+
+func GETRouteTrackGPX(c *gin.Context) {
+	renderRouteTrack(c, "application/gpx+xml", ".gpx", track.GPX)
+}
+
+func GETRouteTrackKML(c *gin.Context) {
+	renderRouteTrack(c, "application/vnd.google-earth.kml+xml", ".kml", track.KML)
+}
+
+// GETRouteSegmentCoords serves a segment's track in the format of comma's coords.json
+func GETRouteSegmentCoords(c *gin.Context) {
+	number, err := strconv.Atoi(c.Param("segment"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+		return
+	}
+
+	route, segments, ok := routeTrack(c)
+	if !ok {
+		return
+	}
+	for _, segment := range segments {
+		if segment.Number == number {
+			c.JSON(http.StatusOK, track.Coords(route.StartTime, segment.Points))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, []track.Coord{})
+}
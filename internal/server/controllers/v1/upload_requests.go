@@ -0,0 +1,304 @@
+package v1
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/metrics"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	v1 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1"
+	"github.com/USA-RedDragon/rtz-server/internal/server/websocket"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	"gorm.io/gorm"
+)
+
+// uploadRequestRoute finds the route of the id parameter and its device
+func uploadRequestRoute(c *gin.Context) (*gorm.DB, models.Device, models.Route, bool) {
+	db, ok := c.MustGet("db").(*gorm.DB)
+	if !ok {
+		slog.Error("Failed to get db from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, models.Device{}, models.Route{}, false
+	}
+	route, ok := routeFromParam(c, db)
+	if !ok {
+		return nil, models.Device{}, models.Route{}, false
+	}
+	device, err := models.FindDeviceByID(db, route.DeviceID)
+	if err != nil {
+		slog.Error("Failed to find device", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, models.Device{}, models.Route{}, false
+	}
+	return db, device, route, true
+}
+
+// deviceCaller returns a function that makes RPC calls to a device over Athena
+func deviceCaller(c *gin.Context, dongleID string) (uploads.CallFunc, bool) {
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, false
+	}
+	metrics, ok := c.MustGet("metrics").(*metrics.Metrics)
+	if !ok {
+		slog.Error("Failed to get metrics from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, false
+	}
+	maybeNats, ok := c.Get("nats")
+	if !ok && config.NATS.Enabled {
+		slog.Error("Failed to get NATS from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, false
+	}
+	nc, ok := maybeNats.(*nats.Conn)
+	if !ok {
+		nc = nil
+	}
+	rpcCaller, ok := c.MustGet("rpcWebsocket").(*websocket.RPCWebsocket)
+	if !ok {
+		slog.Error("Failed to get rpc from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return nil, false
+	}
+
+	return func(call apimodels.RPCCall) (apimodels.RPCResponse, error) {
+		return rpcCaller.Call(c, nc, metrics, dongleID, call)
+	}, true
+}
+
+func deviceOffline(err error) bool {
+	return errors.Is(err, websocket.ErrNotConnected) || errors.Is(err, nats.ErrNoResponders)
+}
+
+// respondUploadRequests responds with every upload request for a route
+func respondUploadRequests(c *gin.Context, db *gorm.DB, route models.Route) {
+	requests, err := models.ListUploadRequests(db, route.ID)
+	if err != nil {
+		slog.Error("Failed to list upload requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if requests == nil {
+		requests = []models.UploadRequest{}
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// GETRouteUploadRequests lists the uploads requested for a route, refreshing
+// their progress from the device's upload queue when it's online
+func GETRouteUploadRequests(c *gin.Context) {
+	db, device, route, ok := uploadRequestRoute(c)
+	if !ok {
+		return
+	}
+	call, ok := deviceCaller(c, device.DongleID)
+	if !ok {
+		return
+	}
+
+	err := uploads.Refresh(db, device, call)
+	if err != nil && !deviceOffline(err) {
+		slog.Warn("Failed to refresh upload requests", "device", device.DongleID, "error", err)
+	}
+
+	respondUploadRequests(c, db, route)
+}
+
+// POSTRouteUploadRequests asks a device to upload the full-resolution files
+// of a range of a route's segments. Requests for an offline device are sent
+// when it next connects.
+func POSTRouteUploadRequests(c *gin.Context) {
+	var req v1.UploadRequestsRequest
+	if err := c.BindJSON(&req); err != nil {
+		slog.Error("Failed to bind request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	var kinds []routefiles.Kind
+	for _, file := range req.Files {
+		kind := routefiles.Kind(file)
+		if _, ok := uploads.RequestFiles[kind]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown file type " + file})
+			return
+		}
+		if !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "files is required"})
+		return
+	}
+
+	config, ok := c.MustGet("config").(*config.Config)
+	if !ok {
+		slog.Error("Failed to get config from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	store, ok := c.MustGet("storage").(storage.Storage)
+	if !ok {
+		slog.Error("Failed to get storage from context")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	db, device, route, ok := uploadRequestRoute(c)
+	if !ok {
+		return
+	}
+	call, ok := deviceCaller(c, device.DongleID)
+	if !ok {
+		return
+	}
+
+	// The device names segment directories after the full route name, which
+	// is only known from the segments uploaded so far
+	var segments []routefiles.Segment
+	base, err := store.Sub(device.DongleID)
+	if err == nil {
+		segments, err = routefiles.List(base, route.RouteID)
+		base.Close()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Failed to list route files", "route", route.RouteID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if len(segments) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "No segments of this route have been uploaded yet"})
+		return
+	}
+	routeName, _, _ := routefiles.ParseSegmentDir(segments[0].Dir)
+
+	// Segments are known from what's been uploaded of them, in storage or recorded in the database
+	last := segments[len(segments)-1].Number
+	recorded, err := models.FindSegmentsByRouteID(db, route.ID)
+	if err != nil {
+		slog.Error("Failed to find segments", "route", route.RouteID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if len(recorded) > 0 {
+		last = max(last, recorded[len(recorded)-1].Number)
+	}
+
+	start := 0
+	if req.StartSegment != nil {
+		start = *req.StartSegment
+	}
+	end := last
+	if req.EndSegment != nil {
+		end = *req.EndSegment
+	}
+	if end > last {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_segment must not be past the route's last segment, " + strconv.Itoa(last)})
+		return
+	}
+	if start < 0 || start > end {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_segment must be between 0 and end_segment"})
+		return
+	}
+
+	stored := map[int]routefiles.Segment{}
+	for _, segment := range segments {
+		stored[segment.Number] = segment
+	}
+	var requests []models.UploadRequest
+	for number := start; number <= end; number++ {
+		dir := routefiles.SegmentDir(routeName, number)
+		for _, kind := range kinds {
+			if _, ok := stored[number].Files[kind]; ok {
+				continue
+			}
+			requests = append(requests, models.UploadRequest{
+				DeviceID:      device.ID,
+				RouteID:       route.ID,
+				Path:          dir + "/" + uploads.RequestFiles[kind],
+				Segment:       number,
+				AllowCellular: req.AllowCellular,
+			})
+		}
+	}
+	_, err = models.CreateUploadRequests(db, requests)
+	if err != nil {
+		slog.Error("Failed to create upload requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+
+	err = uploads.Dispatch(config, db, store, device, call)
+	if err != nil && !deviceOffline(err) {
+		// The requests stay pending and are sent again when the device reconnects
+		slog.Warn("Failed to dispatch upload requests", "device", device.DongleID, "error", err)
+	}
+
+	respondUploadRequests(c, db, route)
+}
+
+// POSTRouteCancelUploadRequests cancels uploads requested for a route, removing
+// them from the device's upload queue now or when it next connects
+func POSTRouteCancelUploadRequests(c *gin.Context) {
+	var req v1.CancelUploadRequestsRequest
+	if err := c.BindJSON(&req); err != nil {
+		slog.Error("Failed to bind request", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	db, device, route, ok := uploadRequestRoute(c)
+	if !ok {
+		return
+	}
+	call, ok := deviceCaller(c, device.DongleID)
+	if !ok {
+		return
+	}
+
+	routeRequests, err := models.ListUploadRequests(db, route.ID)
+	if err != nil {
+		slog.Error("Failed to list upload requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	var requests []models.UploadRequest
+	for _, request := range routeRequests {
+		if slices.Contains(req.IDs, request.ID) {
+			requests = append(requests, request)
+		}
+	}
+
+	cancelCall, expiry, ok, err := uploads.Cancel(db, requests)
+	if err != nil {
+		slog.Error("Failed to cancel upload requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+		return
+	}
+	if ok {
+		_, err := call(cancelCall)
+		switch {
+		case deviceOffline(err):
+			err := websocket.QueueCall(db, device.ID, cancelCall, expiry)
+			if err != nil {
+				slog.Error("Failed to queue cancelUpload call", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Try again later"})
+				return
+			}
+		case err != nil:
+			slog.Warn("Failed to cancel uploads on the device", "device", device.DongleID, "error", err)
+		}
+	}
+
+	respondUploadRequests(c, db, route)
+}
@@ -50,6 +50,9 @@ func applyRoutes(r *gin.Engine, config *config.Config, rpcWebsocket *websocketCo
 	wsV2 := r.Group("/ws/v2")
 	wsV2.GET("/:dongle_id", requireCookieAuth(config), websocket.CreateHandler(rpcWebsocket, config))
 
+	// Websockets devices open for startLocalProxy
+	r.GET("/ws/proxy/:dongle_id/:session", requireCookieAuth(config), rpcWebsocket.CreateProxyHandler(config))
+
 	r.POST("/:dongle_id", requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwnerOrShared(), controllers.HandleRPC)
 
 	r.NoRoute(func(c *gin.Context) {
@@ -60,13 +63,31 @@ func applyRoutes(r *gin.Engine, config *config.Config, rpcWebsocket *websocketCo
 
 func v1(group *gin.RouterGroup, config *config.Config) {
 	group.GET("/me", requireAuth(config, AuthTypeUser|AuthTypeDemo), controllersV1.GETMe)
-	group.GET("/route/:id/qcamera.m3u8", controllersV1.GETRouteQCameraM3U8)
-	group.GET("/route/:id/files", controllersV1.GETRouteFiles)
+	group.GET("/route/:id/qcamera.m3u8", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteQCameraM3U8)
+	group.GET("/route/:id/files", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwnerOrShared(), controllersV1.GETRouteFiles)
+	group.GET("/route/:id/upload_requests", routeDongleID(), requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETRouteUploadRequests)
+	group.POST("/route/:id/upload_requests", routeDongleID(), requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.POSTRouteUploadRequests)
+	group.POST("/route/:id/upload_requests/cancel", routeDongleID(), requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.POSTRouteCancelUploadRequests)
+	group.GET("/route/:id/track.geojson", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteTrackGeoJSON)
+	group.GET("/route/:id/track.gpx", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteTrackGPX)
+	group.GET("/route/:id/track.kml", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteTrackKML)
+	group.GET("/route/:id/thumbnails/:segment/:index", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteThumbnail)
+	group.GET("/route/:id/sprite.jpg", routeDongleID(), requireAuth(config, AuthTypeUser|AuthTypeDemo|AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteSprite)
+	group.GET("/route/:id/derived/:exp/:sig/:segment/coords.json", routeDongleID(), requireAuth(config, AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteSegmentCoords)
+	group.GET("/route/:id/derived/:exp/:sig/:segment/events.json", routeDongleID(), requireAuth(config, AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteSegmentEvents)
+	group.GET("/route/:id/derived/:exp/:sig/:segment/sprite.jpg", routeDongleID(), requireAuth(config, AuthTypeRouteShare), requireDeviceOwnerOrShared(), controllersV1.GETRouteSegmentSprite)
+	group.GET("/files/*path", controllersV1.GETFile)
 	group.GET("/devices/:dongle_id/athena_offline_queue", requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwner(), controllersV1.GETAthenaOfflineQueue)
 	group.PATCH("/devices/:dongle_id", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.PATCHDevice)
 	group.POST("/devices/:dongle_id/unpair", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.POSTDeviceUnpair)
 	group.POST("/devices/:dongle_id/add_user", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.POSTDeviceAddUser)
 	group.GET("/devices/:dongle_id/location", requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwnerOrShared(), controllersV1.GETDeviceLocation)
+	group.GET("/devices/:dongle_id/vehicles", requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwnerOrShared(), controllersV1.GETDeviceVehicles)
+	group.GET("/devices/:dongle_id/metrics", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETDeviceMetricNames)
+	group.GET("/devices/:dongle_id/metrics/:name", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETDeviceMetric)
+	group.GET("/devices/:dongle_id/logs", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETDeviceLogs)
+	group.GET("/devices/:dongle_id/proxy", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETDeviceProxy)
+	group.GET("/devices/:dongle_id/proxy_sessions", requireAuth(config, AuthTypeUser), requireDeviceOwner(), controllersV1.GETDeviceProxySessions)
 	group.GET("/devices/:dongle_id/routes_segments", requireAuth(config, AuthTypeUser|AuthTypeDemo), requireDeviceOwnerOrShared(), controllersV1.GETDeviceRoutesSegments)
 	group.GET("/me/devices", requireAuth(config, AuthTypeUser|AuthTypeDemo), controllersV1.GETMyDevices)
 	group.POST("/navigation/:dongle_id/set_destination", requireAuth(config, AuthTypeUser|AuthTypeDevice), requireDeviceOwnerOrShared(), controllersV1.POSTSetDestination)
@@ -76,6 +97,10 @@ func v1(group *gin.RouterGroup, config *config.Config) {
 	group.DELETE("/navigation/:dongle_id/next", requireAuth(config, AuthTypeUser|AuthTypeDevice), requireDeviceOwnerOrShared(), controllersV1.DELETENavigationNext)
 	group.GET("/navigation/:dongle_id/locations", requireAuth(config, AuthTypeUser|AuthTypeDevice), requireDeviceOwnerOrShared(), controllersV1.GETNavigationLocations)
 	group.GET("/prime/subscription", requireAuth(config, AuthTypeUser), controllersV1.GETPrimeSubscription)
+	group.GET("/admin/log_jobs", requireAuth(config, AuthTypeUser), requireSuperuser(), controllersV1.GETLogJobs)
+	group.POST("/admin/log_jobs/:id/retry", requireAuth(config, AuthTypeUser), requireSuperuser(), controllersV1.POSTLogJobRetry)
+	group.POST("/admin/reprocess", requireAuth(config, AuthTypeUser), requireSuperuser(), controllersV1.POSTReprocess)
+	group.GET("/admin/reprocess/progress", requireAuth(config, AuthTypeUser), requireSuperuser(), controllersV1.GETReprocessProgress)
 }
 
 func v1dot1(group *gin.RouterGroup, config *config.Config) {
@@ -85,7 +110,8 @@ func v1dot1(group *gin.RouterGroup, config *config.Config) {
 
 func v1dot4(group *gin.RouterGroup, config *config.Config) {
 	group.GET("/:dongle_id/upload_url", requireAuth(config, AuthTypeDevice), controllersV1dot4.GETUploadURL)
-	group.PUT("/:dongle_id/upload", requireAuth(config, AuthTypeDevice), controllersV1dot4.PUTUpload)
+	group.PUT("/:dongle_id/upload", requireAuth(config, AuthTypeDevice|AuthTypeUploadURL), controllersV1dot4.PUTUpload)
+	group.HEAD("/:dongle_id/upload", requireAuth(config, AuthTypeDevice|AuthTypeUploadURL), controllersV1dot4.HEADUpload)
 }
 
 func v2(group *gin.RouterGroup, config *config.Config) {
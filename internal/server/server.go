@@ -69,7 +69,7 @@ func NewServer(config *config.Config, db *gorm.DB, nats *nats.Conn, logQueue *lo
 
 	writeTimeout := defTimeout
 
-	rpcWebsocket := websocketControllers.CreateRPCWebsocket(config, metrics)
+	rpcWebsocket := websocketControllers.CreateRPCWebsocket(config, metrics, storage)
 	applyMiddleware(r, config, "api", db, rpcWebsocket, nats, logQueue, metrics, storage)
 	applyRoutes(r, config, rpcWebsocket)
 
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/metrics"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
+	"github.com/USA-RedDragon/rtz-server/internal/websocket"
+	gorillaWebsocket "github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// OfflineCallTimeout is how long a device has to respond to a queued call
+// before delivery stops, leaving the rest queued for its next connection
+const OfflineCallTimeout = 30 * time.Second
+
+// QueueCall stores a call for an offline device to be made when it next connects, unless it's expired by then
+func QueueCall(db *gorm.DB, deviceID uint, call apimodels.RPCCall, expiry time.Time) error {
+	params, err := json.Marshal(call.Params)
+	if err != nil {
+		return err
+	}
+	return models.QueueAthenaOfflineCall(db, &models.AthenaOfflineCall{
+		DeviceID:       deviceID,
+		CallID:         call.ID,
+		Method:         call.Method,
+		JSONRPCVersion: call.JSONRPCVersion,
+		Params:         string(params),
+		Expiry:         expiry,
+	})
+}
+
+// deliverOfflineCalls makes the calls queued for a device while it was
+// offline, one at a time in the order they were queued. Each call is
+// forgotten once the device responds to it.
+func deliverOfflineCalls(ctx context.Context, w websocket.Writer, device *models.Device, db *gorm.DB, metrics *metrics.Metrics, dongle *dongle) {
+	err := models.DeleteExpiredAthenaOfflineCalls(db, device.ID)
+	if err != nil {
+		metrics.IncrementAthenaErrors(device.DongleID, "delete_expired_offline_calls")
+		slog.Warn("Error deleting expired offline calls", "error", err)
+	}
+	calls, err := models.ListAthenaOfflineCalls(db, device.ID)
+	if err != nil {
+		metrics.IncrementAthenaErrors(device.DongleID, "list_offline_calls")
+		slog.Warn("Error listing offline calls", "error", err)
+		return
+	}
+
+	for _, queued := range calls {
+		call := apimodels.RPCCall{
+			ID:             queued.CallID,
+			Method:         queued.Method,
+			JSONRPCVersion: queued.JSONRPCVersion,
+		}
+		err := json.Unmarshal([]byte(queued.Params), &call.Params)
+		if err != nil {
+			metrics.IncrementAthenaErrors(device.DongleID, "unmarshal_offline_call")
+			slog.Warn("Error unmarshalling offline call params, dropping it", "method", queued.Method, "error", err)
+			err := models.DeleteAthenaOfflineCall(db, queued.ID)
+			if err != nil {
+				slog.Warn("Error deleting offline call", "error", err)
+			}
+			continue
+		}
+		resp, err := callDongle(ctx, w, dongle, call, OfflineCallTimeout)
+		switch {
+		case errors.Is(err, ErrCallTimeout):
+			metrics.IncrementAthenaErrors(device.DongleID, "offline_call_timeout")
+			slog.Warn("Timeout delivering offline call", "device", device.DongleID, "method", call.Method)
+			return
+		case err != nil:
+			if ctx.Err() == nil {
+				metrics.IncrementAthenaErrors(device.DongleID, "offline_call")
+				slog.Warn("Error making offline call", "error", err)
+			}
+			return
+		case resp.Error != "":
+			slog.Warn("Offline call failed on the device", "device", device.DongleID, "method", call.Method, "error", resp.Error)
+		default:
+			slog.Debug("Delivered offline call", "device", device.DongleID, "method", call.Method)
+		}
+
+		err = models.DeleteAthenaOfflineCall(db, queued.ID)
+		if err != nil {
+			metrics.IncrementAthenaErrors(device.DongleID, "delete_offline_call")
+			slog.Warn("Error deleting offline call", "error", err)
+			return
+		}
+	}
+}
+
+// callDongle writes a call straight to a connected device and waits up to
+// timeout for its response
+func callDongle(ctx context.Context, w websocket.Writer, dongle *dongle, call apimodels.RPCCall, timeout time.Duration) (apimodels.RPCResponse, error) {
+	jsonData, err := json.Marshal(call)
+	if err != nil {
+		return apimodels.RPCResponse{}, err
+	}
+
+	// Buffered so a response arriving after the timeout doesn't block the watcher
+	responseChan := make(chan apimodels.RPCResponse, 1)
+	dongle.channelWatcher.Subscribe(call.ID, func(response apimodels.RPCResponse) {
+		responseChan <- response
+	})
+
+	w.WriteMessage(websocket.Message{
+		Type: gorillaWebsocket.TextMessage,
+		Data: jsonData,
+	})
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case <-timeoutCtx.Done():
+		if ctx.Err() != nil {
+			return apimodels.RPCResponse{}, ctx.Err()
+		}
+		return apimodels.RPCResponse{}, ErrCallTimeout
+	case resp := <-responseChan:
+		return resp, nil
+	}
+}
+
+// dispatchUploadRequests sends the uploads requested while a device was
+// offline. Requests it doesn't respond to stay pending for its next connection.
+func (c *RPCWebsocket) dispatchUploadRequests(ctx context.Context, w websocket.Writer, device *models.Device, db *gorm.DB, metrics *metrics.Metrics, dongle *dongle) {
+	err := uploads.Dispatch(c.config, db, c.storage, *device, func(call apimodels.RPCCall) (apimodels.RPCResponse, error) {
+		return callDongle(ctx, w, dongle, call, OfflineCallTimeout)
+	})
+	if err != nil && ctx.Err() == nil {
+		metrics.IncrementAthenaErrors(device.DongleID, "dispatch_upload_requests")
+		slog.Warn("Error dispatching upload requests", "device", device.DongleID, "error", err)
+	}
+}
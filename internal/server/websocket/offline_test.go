@@ -0,0 +1,117 @@
+package websocket_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/metrics"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/server/websocket"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	rawWebsocket "github.com/USA-RedDragon/rtz-server/internal/websocket"
+	gorillaWebsocket "github.com/gorilla/websocket"
+)
+
+type testWriter struct {
+	messages chan rawWebsocket.Message
+}
+
+func (w testWriter) WriteMessage(message rawWebsocket.Message) {
+	w.messages <- message
+}
+
+func (w testWriter) Error(string) {}
+
+func TestOfflineCallsAreDeliveredInOrder(t *testing.T) {
+	t.Parallel()
+	cfg := testutil.Config(t)
+	database := testutil.OpenDB(t, cfg)
+	device := testutil.NewDevice(t, database)
+
+	expiry := time.Now().Add(time.Hour)
+	for i, method := range []string{"setNavDestination", "uploadFilesToUrls"} {
+		call := apimodels.RPCCall{
+			ID:             fmt.Sprintf("call-%d", i),
+			Method:         method,
+			JSONRPCVersion: "2.0",
+			Params:         map[string]any{"n": i},
+		}
+		if err := websocket.QueueCall(database, device.ID, call, expiry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expired := apimodels.RPCCall{ID: "expired", Method: "takeSnapshot", JSONRPCVersion: "2.0"}
+	if err := websocket.QueueCall(database, device.ID, expired, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	queued, err := models.ListAthenaOfflineCalls(database, device.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("expected 2 unexpired calls to be listed, got %d", len(queued))
+	}
+
+	metrics := metrics.NewMetrics()
+	rpc := websocket.CreateRPCWebsocket(cfg, metrics, nil)
+	writer := testWriter{messages: make(chan rawWebsocket.Message, 4)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rpc.OnConnect(ctx, nil, writer, &device, database, nil, metrics, nil)
+	defer rpc.OnDisconnect(nil, &device, database, metrics)
+
+	for i, method := range []string{"setNavDestination", "uploadFilesToUrls"} {
+		var msg rawWebsocket.Message
+		select {
+		case msg = <-writer.messages:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for call %d", i)
+		}
+		var call apimodels.RPCCall
+		if err := json.Unmarshal(msg.Data, &call); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if call.Method != method || call.ID != fmt.Sprintf("call-%d", i) {
+			t.Fatalf("expected call %d to be %s, got %s (%s)", i, method, call.Method, call.ID)
+		}
+		params, ok := call.Params.(map[string]any)
+		if !ok || params["n"] != float64(i) {
+			t.Fatalf("expected call %d to keep its params, got %v", i, call.Params)
+		}
+
+		select {
+		case msg = <-writer.messages:
+			t.Fatalf("expected call %d to wait for a response, got %s", i+1, msg.Data)
+		default:
+		}
+
+		response := fmt.Sprintf(`{"id":%q,"jsonrpc":"2.0","result":{"success":1}}`, call.ID)
+		rpc.OnMessage(nil, writer, []byte(response), gorillaWebsocket.TextMessage, &device, database, metrics)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int64
+		if err := database.Model(&models.AthenaOfflineCall{}).Count(&count).Error; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected delivered and expired calls to be deleted, %d left", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case msg := <-writer.messages:
+		t.Fatalf("expected the expired call not to be delivered, got %s", msg.Data)
+	default:
+	}
+}
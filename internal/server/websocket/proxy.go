@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	gorillaWebsocket "github.com/gorilla/websocket"
+)
+
+// ProxyConnectTimeout is how long a device has to connect back after being
+// asked to start a local proxy
+const ProxyConnectTimeout = 30 * time.Second
+
+// proxySession waits for the websocket a device opens for startLocalProxy.
+// Sessions only live in memory and aren't shared over NATS, so the device has
+// to connect back to the instance that asked it to.
+type proxySession struct {
+	dongleID string
+	device   chan *gorillaWebsocket.Conn
+	// done is closed when the session ends, releasing the device's handler
+	done chan struct{}
+}
+
+// ConnectedLocally reports whether a device's Athena websocket is held by this
+// instance. Proxy sessions can only be started for these devices, since the
+// device connects back to whichever instance it's routed to.
+func (c *RPCWebsocket) ConnectedLocally(dongleID string) bool {
+	dongle, loaded := c.dongles.Load(dongleID)
+	return loaded && dongle.bidiChannel.open
+}
+
+// OpenProxy starts a session for the websocket a device opens for startLocalProxy.
+// It returns the session's ID and the channel the device's websocket is sent on
+// once it connects. The session has to be closed with CloseProxy.
+func (c *RPCWebsocket) OpenProxy(dongleID string) (string, <-chan *gorillaWebsocket.Conn, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, err
+	}
+	session := &proxySession{
+		dongleID: dongleID,
+		device:   make(chan *gorillaWebsocket.Conn, 1),
+		done:     make(chan struct{}),
+	}
+	c.proxies.Store(id.String(), session)
+	return id.String(), session.device, nil
+}
+
+// CloseProxy ends a session. A device websocket that was never taken from
+// the session is closed.
+func (c *RPCWebsocket) CloseProxy(id string) {
+	session, loaded := c.proxies.LoadAndDelete(id)
+	if !loaded {
+		return
+	}
+	close(session.done)
+	select {
+	case conn := <-session.device:
+		_ = conn.Close()
+	default:
+	}
+}
+
+// ProxyURL returns the URL a device is told to connect to for a session
+func ProxyURL(config *config.Config, dongleID string, id string) string {
+	url := config.HTTP.BackendURL
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		url = "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		url = "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return url + "/ws/proxy/" + dongleID + "/" + id
+}
+
+// CreateProxyHandler returns the handler for the websockets devices open for
+// startLocalProxy. The device has to be authenticated before it's called.
+func (c *RPCWebsocket) CreateProxyHandler(config *config.Config) func(*gin.Context) {
+	upgrader := gorillaWebsocket.Upgrader{
+		ReadBufferSize:  32 * 1024,
+		WriteBufferSize: 32 * 1024,
+		CheckOrigin:     websocket.CheckOrigin(config),
+	}
+
+	return func(ctx *gin.Context) {
+		session, loaded := c.proxies.Load(ctx.Param("session"))
+		if !loaded || session.dongleID != ctx.Param("dongle_id") {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+
+		conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+		if err != nil {
+			slog.Error("Failed to set websocket upgrade", "error", err)
+			return
+		}
+		select {
+		case session.device <- conn:
+		default:
+			slog.Warn("Device connected to a proxy session more than once", "device", session.dongleID)
+			_ = conn.Close()
+			return
+		}
+
+		// Hold the request until the session is over
+		select {
+		case <-session.done:
+		case <-ctx.Request.Context().Done():
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package websocket_test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/server/websocket"
+	rawWebsocket "github.com/USA-RedDragon/rtz-server/internal/websocket"
+	"github.com/gin-gonic/gin"
+	gorillaWebsocket "github.com/gorilla/websocket"
+)
+
+func TestLocalProxyBridgesTheDevice(t *testing.T) {
+	t.Parallel()
+	cfg := &config.Config{}
+	rpc := websocket.CreateRPCWebsocket(cfg, nil, nil)
+	router := gin.New()
+	router.GET("/ws/proxy/:dongle_id/:session", rpc.CreateProxyHandler(cfg))
+	server := httptest.NewServer(router)
+	defer server.Close()
+	cfg.HTTP.BackendURL = server.URL
+
+	const dongleID = "0000000000000001"
+	// Sessions can't be started for a device without an Athena connection here
+	if rpc.ConnectedLocally(dongleID) {
+		t.Fatalf("expected the dongle not to be connected to this instance")
+	}
+	id, deviceConns, err := rpc.OpenProxy(dongleID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rpc.CloseProxy(id)
+
+	// Another device can't take the session
+	_, resp, err := gorillaWebsocket.DefaultDialer.Dial(websocket.ProxyURL(cfg, "0000000000000002", id), nil)
+	if !errors.Is(err, gorillaWebsocket.ErrBadHandshake) || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected another device to be turned away, got %v", err)
+	}
+
+	// The device connects back, as athenad does once it's asked to start the proxy
+	device, _, err := gorillaWebsocket.DefaultDialer.Dial(websocket.ProxyURL(cfg, dongleID, id), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer device.Close()
+	deviceConn := <-deviceConns
+
+	client, local := net.Pipe()
+	bridged := make(chan int64)
+	go func() {
+		_, toDevice := rawWebsocket.BridgeTCP(deviceConn, local)
+		bridged <- toDevice
+	}()
+
+	if _, err := client.Write([]byte("SSH-2.0-OpenSSH_9.6")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messageType, msg, err := device.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if messageType != gorillaWebsocket.BinaryMessage || string(msg) != "SSH-2.0-OpenSSH_9.6" {
+		t.Fatalf("unexpected message to the device: %d %q", messageType, msg)
+	}
+
+	if err := device.WriteMessage(gorillaWebsocket.BinaryMessage, []byte("SSH-2.0-dropbear")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, len("SSH-2.0-dropbear"))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf) != "SSH-2.0-dropbear" {
+		t.Fatalf("unexpected message from the device: %q", buf)
+	}
+
+	// Closing the client ends the bridge and closes the device's websocket
+	client.Close()
+	if toDevice := <-bridged; toDevice != int64(len("SSH-2.0-OpenSSH_9.6")) {
+		t.Fatalf("expected the bytes sent to the device to be counted, got %d", toDevice)
+	}
+	if _, _, err := device.ReadMessage(); err == nil {
+		t.Fatalf("expected the device's websocket to be closed")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/USA-RedDragon/rtz-server/internal/metrics"
 	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
 	"github.com/USA-RedDragon/rtz-server/internal/websocket"
 	gorillaWebsocket "github.com/gorilla/websocket"
 	"github.com/nats-io/nats.go"
@@ -20,6 +21,7 @@ import (
 
 var (
 	ErrNotConnected = errors.New("dongle not connected")
+	ErrCallTimeout  = errors.New("timed out waiting for the dongle to respond")
 )
 
 type RPCWebsocket struct {
@@ -27,13 +29,17 @@ type RPCWebsocket struct {
 	dongles *xsync.MapOf[string, *dongle]
 	metrics *metrics.Metrics
 	config  *config.Config
+	storage storage.Storage
+	proxies *xsync.MapOf[string, *proxySession]
 }
 
-func CreateRPCWebsocket(config *config.Config, metrics *metrics.Metrics) *RPCWebsocket {
+func CreateRPCWebsocket(config *config.Config, metrics *metrics.Metrics, storage storage.Storage) *RPCWebsocket {
 	socket := &RPCWebsocket{
 		dongles: xsync.NewMapOf[string, *dongle](),
 		metrics: metrics,
 		config:  config,
+		storage: storage,
+		proxies: xsync.NewMapOf[string, *proxySession](),
 	}
 	return socket
 }
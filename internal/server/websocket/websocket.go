@@ -11,6 +11,7 @@ import (
 	"github.com/USA-RedDragon/rtz-server/internal/db/models"
 	"github.com/USA-RedDragon/rtz-server/internal/metrics"
 	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/telemetry"
 	"github.com/USA-RedDragon/rtz-server/internal/utils"
 	"github.com/USA-RedDragon/rtz-server/internal/websocket"
 	gorillaWebsocket "github.com/gorilla/websocket"
@@ -18,7 +19,7 @@ import (
 	"gorm.io/gorm"
 )
 
-func (c *RPCWebsocket) OnMessage(_ *http.Request, _ websocket.Writer, msg []byte, msgType int, device *models.Device, _ *gorm.DB, metrics *metrics.Metrics) {
+func (c *RPCWebsocket) OnMessage(_ *http.Request, w websocket.Writer, msg []byte, msgType int, device *models.Device, db *gorm.DB, metrics *metrics.Metrics) {
 	var rawJSON map[string]interface{}
 	err := json.Unmarshal(msg, &rawJSON)
 	if err != nil {
@@ -45,29 +46,37 @@ func (c *RPCWebsocket) OnMessage(_ *http.Request, _ websocket.Writer, msg []byte
 			switch jsonRPC.Method {
 			case "forwardLogs":
 				slog.Debug("RPC: forwardLogs", "device", device.DongleID, "logs", jsonRPC.Params)
-				dongle.bidiChannel.outbound <- apimodels.RPCResponse{
-					ID:             jsonRPC.ID,
-					JSONRPCVersion: jsonRPC.JSONRPCVersion,
-					Result: map[string]bool{
-						"success": true,
-					},
+				if !c.forwardLogs(db, metrics, device, jsonRPC.ID, jsonRPC.Params) {
+					// Without a success the device sends the logs again later
+					return
 				}
 			case "storeStats":
 				slog.Debug("RPC: storeStats", "device", device.DongleID, "stats", jsonRPC.Params)
+				c.storeStats(db, metrics, device, jsonRPC.Params)
 			default:
 				metrics.IncrementAthenaErrors(device.DongleID, "unknown_rpc_method")
 				slog.Warn("Unknown RPC method", "method", jsonRPC.Method)
 				slog.Info("Message", "type", msgType, "msg", msg)
 				return
 			}
+			// The response goes to the device, not to the calls made through outbound
+			jsonData, err := json.Marshal(apimodels.RPCResponse{
+				ID:             jsonRPC.ID,
+				JSONRPCVersion: jsonRPC.JSONRPCVersion,
+				Result: map[string]bool{
+					"success": true,
+				},
+			})
+			if err != nil {
+				metrics.IncrementAthenaErrors(device.DongleID, "marshal_rpc_response")
+				slog.Warn("Error marshalling response data:", "error", err)
+				return
+			}
 			if dongle.bidiChannel.open {
-				dongle.bidiChannel.outbound <- apimodels.RPCResponse{
-					ID:             jsonRPC.ID,
-					JSONRPCVersion: jsonRPC.JSONRPCVersion,
-					Result: map[string]bool{
-						"success": true,
-					},
-				}
+				w.WriteMessage(websocket.Message{
+					Type: gorillaWebsocket.TextMessage,
+					Data: jsonData,
+				})
 			}
 		}()
 	} else if _, ok := rawJSON["result"]; ok {
@@ -91,7 +100,69 @@ func (c *RPCWebsocket) OnMessage(_ *http.Request, _ websocket.Writer, msg []byte
 	}
 }
 
-func (c *RPCWebsocket) OnConnect(ctx context.Context, _ *http.Request, w websocket.Writer, device *models.Device, _ *gorm.DB, nc *nats.Conn, metrics *metrics.Metrics, conn *gorillaWebsocket.Conn) {
+// forwardLogs saves the swaglog lines from a forwardLogs call, whose params are
+// {"logs": "<lines>"} and whose ID is the name of the log file. It reports
+// whether they were saved.
+func (c *RPCWebsocket) forwardLogs(db *gorm.DB, metrics *metrics.Metrics, device *models.Device, entry string, params any) bool {
+	paramsMap, ok := params.(map[string]any)
+	if !ok {
+		metrics.IncrementAthenaErrors(device.DongleID, "forward_logs_params")
+		slog.Warn("Invalid forwardLogs params", "device", device.DongleID)
+		return false
+	}
+	lines, ok := paramsMap["logs"].(string)
+	if !ok {
+		metrics.IncrementAthenaErrors(device.DongleID, "forward_logs_params")
+		slog.Warn("Invalid forwardLogs params", "device", device.DongleID)
+		return false
+	}
+	logs, err := telemetry.ParseLogs(entry, lines)
+	if err != nil {
+		// Keep whatever parsed
+		metrics.IncrementAthenaErrors(device.DongleID, "parse_logs")
+		slog.Warn("Error parsing forwarded logs", "device", device.DongleID, "error", err)
+	}
+	err = telemetry.StoreLogs(db, device, logs)
+	if err != nil {
+		metrics.IncrementAthenaErrors(device.DongleID, "store_logs")
+		slog.Warn("Error storing forwarded logs", "device", device.DongleID, "error", err)
+		return false
+	}
+	return true
+}
+
+// storeStats saves the metrics from a storeStats call, whose params are {"stats": "<line protocol>"}
+func (c *RPCWebsocket) storeStats(db *gorm.DB, metrics *metrics.Metrics, device *models.Device, params any) {
+	paramsMap, ok := params.(map[string]any)
+	if !ok {
+		metrics.IncrementAthenaErrors(device.DongleID, "store_stats_params")
+		slog.Warn("Invalid storeStats params", "device", device.DongleID)
+		return
+	}
+	stats, ok := paramsMap["stats"].(string)
+	if !ok {
+		metrics.IncrementAthenaErrors(device.DongleID, "store_stats_params")
+		slog.Warn("Invalid storeStats params", "device", device.DongleID)
+		return
+	}
+	points, err := telemetry.ParseStats(stats)
+	if err != nil {
+		// Keep whatever parsed
+		metrics.IncrementAthenaErrors(device.DongleID, "parse_stats")
+		slog.Warn("Error parsing stats", "device", device.DongleID, "error", err)
+	}
+	var exportPrometheus []string
+	if c.config.Telemetry.Prometheus {
+		exportPrometheus = c.config.Telemetry.PrometheusMetrics
+	}
+	err = telemetry.StoreStats(db, metrics, exportPrometheus, device, points)
+	if err != nil {
+		metrics.IncrementAthenaErrors(device.DongleID, "store_stats")
+		slog.Warn("Error storing stats", "device", device.DongleID, "error", err)
+	}
+}
+
+func (c *RPCWebsocket) OnConnect(ctx context.Context, _ *http.Request, w websocket.Writer, device *models.Device, db *gorm.DB, nc *nats.Conn, metrics *metrics.Metrics, conn *gorillaWebsocket.Conn) {
 	bidi := bidiChannel{
 		open:     true,
 		inbound:  make(chan apimodels.RPCCall),
@@ -131,6 +202,10 @@ func (c *RPCWebsocket) OnConnect(ctx context.Context, _ *http.Request, w websock
 	}()
 	c.dongles.Store(device.DongleID, &dongle)
 	metrics.IncrementAthenaConnections(device.DongleID)
+	go func() {
+		deliverOfflineCalls(ctx, w, device, db, metrics, &dongle)
+		c.dispatchUploadRequests(ctx, w, device, db, metrics, &dongle)
+	}()
 	if c.config.NATS.Enabled {
 		sub, err := nc.Subscribe("rpc:call:"+device.DongleID, func(msg *nats.Msg) {
 			var call apimodels.RPCCall
@@ -0,0 +1,86 @@
+// Package sprite lays road camera thumbnails out into a single preview image
+package sprite
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// Quality is the JPEG quality sprites are encoded with
+const Quality = 80
+
+var ErrNoImages = errors.New("no images to compose")
+
+// Compose decodes JPEG images, scales each to width and lays them out in a
+// row, or in a column if vertical is set. Tiles take the aspect ratio of
+// the first image.
+func Compose(images [][]byte, width int, vertical bool) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+	if width <= 0 {
+		return nil, errors.New("width must be positive")
+	}
+
+	var sprite *image.RGBA
+	var height int
+	for i, data := range images {
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if sprite == nil {
+			bounds := img.Bounds()
+			height = max(1, bounds.Dy()*width/max(1, bounds.Dx()))
+			if vertical {
+				sprite = image.NewRGBA(image.Rect(0, 0, width, height*len(images)))
+			} else {
+				sprite = image.NewRGBA(image.Rect(0, 0, width*len(images), height))
+			}
+		}
+		tile := image.Rect(width*i, 0, width*(i+1), height)
+		if vertical {
+			tile = image.Rect(0, height*i, width, height*(i+1))
+		}
+		scale(sprite, tile, img)
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, sprite, &jpeg.Options{Quality: Quality})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale draws src into the dst rectangle r, averaging the source pixels each destination pixel covers
+func scale(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	for y := 0; y < r.Dy(); y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/r.Dy()
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sb.Dy()/r.Dy())
+		for x := 0; x < r.Dx(); x++ {
+			x0 := sb.Min.X + x*sb.Dx()/r.Dx()
+			x1 := max(x0+1, sb.Min.X+(x+1)*sb.Dx()/r.Dx())
+			var red, green, blue, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := src.At(sx, sy).RGBA()
+					red += cr
+					green += cg
+					blue += cb
+					n++
+				}
+			}
+			dst.SetRGBA(r.Min.X+x, r.Min.Y+y, color.RGBA{
+				R: uint8(red / n >> 8),
+				G: uint8(green / n >> 8),
+				B: uint8(blue / n >> 8),
+				A: 0xFF,
+			})
+		}
+	}
+}
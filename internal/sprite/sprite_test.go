@@ -0,0 +1,83 @@
+package sprite_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/sprite"
+)
+
+func solid(t *testing.T, width, height int, c color.RGBA) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// near allows for JPEG's compression artifacts
+func near(a uint32, b uint32) bool {
+	return max(a, b)-min(a, b) < 16
+}
+
+func TestCompose(t *testing.T) {
+	t.Parallel()
+
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	blue := color.RGBA{B: 0xFF, A: 0xFF}
+	images := [][]byte{solid(t, 160, 120, red), solid(t, 160, 120, blue)}
+
+	tests := []struct {
+		name     string
+		vertical bool
+		width    int
+		height   int
+		second   image.Point
+	}{
+		{"horizontal", false, 160, 60, image.Pt(120, 30)},
+		{"vertical", true, 80, 120, image.Pt(40, 90)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := sprite.Compose(images, 80, tt.vertical)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if img.Bounds().Dx() != tt.width || img.Bounds().Dy() != tt.height {
+				t.Fatalf("expected %dx%d sprite, got %v", tt.width, tt.height, img.Bounds())
+			}
+			r, _, b, _ := img.At(10, 10).RGBA()
+			if !near(r>>8, 0xFF) || !near(b>>8, 0) {
+				t.Errorf("expected first tile to be red, got r=%d b=%d", r>>8, b>>8)
+			}
+			r, _, b, _ = img.At(tt.second.X, tt.second.Y).RGBA()
+			if !near(r>>8, 0) || !near(b>>8, 0xFF) {
+				t.Errorf("expected second tile to be blue, got r=%d b=%d", r>>8, b>>8)
+			}
+		})
+	}
+}
+
+func TestComposeNothing(t *testing.T) {
+	t.Parallel()
+	_, err := sprite.Compose(nil, 80, false)
+	if !errors.Is(err, sprite.ErrNoImages) {
+		t.Errorf("expected ErrNoImages, got %v", err)
+	}
+}
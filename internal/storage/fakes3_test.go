@@ -0,0 +1,226 @@
+package storage_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+const fakeS3Bucket = "rtz-test"
+
+type fakeS3Object struct {
+	data     []byte
+	modified time.Time
+}
+
+// fakeS3 is a minimal path-style S3 stand-in that keeps objects in memory.
+// It doesn't check signatures.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	// uploads holds the parts of in-progress multipart uploads by upload ID
+	uploads  map[string]map[int][]byte
+	nextID   int
+	aborted  int
+	maxPart  int
+	failPart int
+}
+
+// pendingUploads returns how many multipart uploads are neither completed nor aborted
+func (f *fakeS3) pendingUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[key]
+	return obj.data, ok
+}
+
+func (f *fakeS3) serveMultipart(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID = strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = map[int][]byte{}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, fakeS3Bucket, key, uploadID)
+	case r.Method == http.MethodPut:
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || partNumber == f.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.maxPart = max(f.maxPart, len(data))
+		parts[partNumber] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, uploadID, partNumber))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost:
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var data []byte
+		for i := 1; i <= len(parts); i++ {
+			data = append(data, parts[i]...)
+		}
+		delete(f.uploads, uploadID)
+		f.objects[key] = fakeS3Object{data: data, modified: time.Now()}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"%s"</ETag></CompleteMultipartUploadResult>`, fakeS3Bucket, key, uploadID)
+	case r.Method == http.MethodDelete:
+		delete(f.uploads, uploadID)
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveList implements ListObjectsV2 without pagination
+func (f *fakeS3) serveList(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var contents, commonPrefixes strings.Builder
+	seenPrefixes := map[string]bool{}
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				commonPrefix := prefix + rest[:i+len(delimiter)]
+				if !seenPrefixes[commonPrefix] {
+					seenPrefixes[commonPrefix] = true
+					fmt.Fprintf(&commonPrefixes, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", commonPrefix)
+				}
+				continue
+			}
+		}
+		obj := f.objects[key]
+		fmt.Fprintf(&contents, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, obj.modified.UTC().Format("2006-01-02T15:04:05.000Z"), len(obj.data))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><IsTruncated>false</IsTruncated>%s%s</ListBucketResult>`,
+		fakeS3Bucket, prefix, contents.String(), commonPrefixes.String())
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodGet && strings.TrimSuffix(r.URL.Path, "/") == "/"+fakeS3Bucket {
+		f.serveList(w, r)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if query := r.URL.Query(); query.Has("uploads") || query.Has("uploadId") {
+		f.serveMultipart(w, r, key)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[key] = fakeS3Object{data: data, modified: time.Now()}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, key, obj.modified, strings.NewReader(string(obj.data)))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newFakeS3Storage starts a fake S3 server and returns an S3 storage driver pointed at it
+func newFakeS3Storage(t *testing.T) (storage.Storage, *fakeS3) {
+	t.Helper()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	fake := &fakeS3{
+		objects: map[string]fakeS3Object{},
+		uploads: map[string]map[int][]byte{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := storage.NewStorage(&config.Config{
+		Persistence: config.Persistence{
+			Uploads: config.Uploads{
+				Driver: config.UploadsDriverS3,
+				S3Options: config.S3Options{
+					Region:   "us-east-1",
+					Bucket:   fakeS3Bucket,
+					Endpoint: server.URL,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store, fake
+}
@@ -79,3 +79,15 @@ func (f Filesystem) Sub(dir string) (Storage, error) {
 	}
 	return Filesystem{osRoot: newRoot}, nil
 }
+
+func (f Filesystem) Stat(name string) (fs.FileInfo, error) {
+	return f.osRoot.Stat(name)
+}
+
+func (f Filesystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.osRoot.FS(), name)
+}
+
+func (f Filesystem) Walk(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(f.osRoot.FS(), root, fn)
+}
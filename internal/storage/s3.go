@@ -3,14 +3,38 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"golang.org/x/sync/errgroup"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3PartSize is the size of each part of a multipart upload, and so the most
+// a single file being written holds in memory. S3 requires at least 5 MiB.
+const S3PartSize = 8 << 20
+
+var (
+	errSeekInvalidWhence = errors.New("seek: invalid whence")
+	errSeekNegative      = errors.New("seek: negative position")
+	errFileClosed        = errors.New("file already closed")
+
+	//nolint:golint,gochecknoglobals
+	partBufferPool = sync.Pool{
+		New: func() any {
+			return bytes.NewBuffer(make([]byte, 0, S3PartSize))
+		},
+	}
 )
 
 type S3 struct {
@@ -23,59 +47,267 @@ type S3 struct {
 	s3Client *s3.Client
 }
 
-type bufferWriter struct {
-	io.Writer
-	hasWritten bool
-	buffer     []byte
-}
-
-func (w *bufferWriter) Write(p []byte) (n int, err error) {
-	w.hasWritten = true
-	w.buffer = append(w.buffer, p...)
-	return len(p), nil
-}
-
+// S3File is either an object being read or one being written, depending on
+// whether it came from Open or Create. Reads are served from ranged GETs so
+// the file can be seeked without downloading what comes before. Writes are
+// streamed to S3 as a multipart upload one part at a time.
 type S3File struct {
 	File
 	key        string
-	body       io.ReadCloser
-	writer     bufferWriter
 	filesystem *S3
+
+	// Read side
+	body   io.ReadCloser
+	offset int64
+	size   int64
+
+	// Write side
+	writable bool
+	closed   bool
+	buffer   *bytes.Buffer
+	uploadID *string
+	parts    []types.CompletedPart
+	err      error
 }
 
 func (f *S3File) Write(p []byte) (n int, err error) {
-	return f.writer.Write(p)
-}
+	if !f.writable {
+		return 0, fs.ErrPermission
+	}
+	if f.closed {
+		return 0, errFileClosed
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
 
-func (f *S3File) Read(p []byte) (n int, err error) {
-	return f.body.Read(p)
+	for len(p) > 0 {
+		if f.buffer == nil {
+			f.buffer, _ = partBufferPool.Get().(*bytes.Buffer)
+		}
+		chunk := p
+		if free := S3PartSize - f.buffer.Len(); len(chunk) > free {
+			chunk = chunk[:free]
+		}
+		f.buffer.Write(chunk)
+		n += len(chunk)
+		p = p[len(chunk):]
+
+		if f.buffer.Len() == S3PartSize {
+			err = f.uploadPart()
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
 }
 
-func (f *S3File) Close() error {
-	errGrp := errgroup.Group{}
-	errGrp.Go(func() error {
-		if f.body != nil {
-			return f.body.Close()
+// uploadPart sends the buffered data as the next part, starting the
+// multipart upload on the first call
+func (f *S3File) uploadPart() error {
+	if f.uploadID == nil {
+		res, err := f.filesystem.s3Client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(f.filesystem.bucket),
+			Key:    aws.String(f.key),
+		})
+		if err != nil {
+			f.err = err
+			return err
 		}
-		return nil
+		f.uploadID = res.UploadId
+	}
+
+	partNumber := int32(len(f.parts) + 1) //nolint:golint,gosec
+	res, err := f.filesystem.s3Client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:     aws.String(f.filesystem.bucket),
+		Key:        aws.String(f.key),
+		UploadId:   f.uploadID,
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(f.buffer.Bytes()),
 	})
-	slog.Info("closing file", "key", f.key)
-	slog.Info("hasWritten", "written", f.writer.hasWritten)
-	// Write the buffer to S3
-	if f.writer.hasWritten {
-		slog.Info("writing to s3", "key", f.key)
-		errGrp.Go(func() error {
-			_, err := f.filesystem.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: aws.String(f.filesystem.bucket),
-				Key:    aws.String(f.key),
-				Body:   bytes.NewReader(f.writer.buffer),
-			})
-			slog.Info("done writing to s3", "key", f.key)
-			return err
+	if err != nil {
+		f.err = err
+		f.abort()
+		return err
+	}
+	f.parts = append(f.parts, types.CompletedPart{
+		ETag:       res.ETag,
+		PartNumber: aws.Int32(partNumber),
+	})
+	f.buffer.Reset()
+	return nil
+}
+
+// abort discards a failed multipart upload so its parts don't linger in the bucket
+func (f *S3File) abort() {
+	if f.uploadID == nil {
+		return
+	}
+	_, err := f.filesystem.s3Client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(f.filesystem.bucket),
+		Key:      aws.String(f.key),
+		UploadId: f.uploadID,
+	})
+	if err != nil {
+		slog.Error("Failed to abort multipart upload", "key", f.key, "error", err)
+	}
+	f.uploadID = nil
+}
+
+func (f *S3File) releaseBuffer() {
+	if f.buffer != nil {
+		f.buffer.Reset()
+		partBufferPool.Put(f.buffer)
+		f.buffer = nil
+	}
+}
+
+// flush finishes writing the object
+func (f *S3File) flush() error {
+	defer f.releaseBuffer()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	// Small files never fill a part, so skip the multipart dance
+	if f.uploadID == nil {
+		var body []byte
+		if f.buffer != nil {
+			body = f.buffer.Bytes()
+		}
+		_, err := f.filesystem.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(f.filesystem.bucket),
+			Key:    aws.String(f.key),
+			Body:   bytes.NewReader(body),
 		})
+		return err
 	}
 
-	return errGrp.Wait()
+	if f.buffer != nil && f.buffer.Len() > 0 {
+		err := f.uploadPart()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := f.filesystem.s3Client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(f.filesystem.bucket),
+		Key:      aws.String(f.key),
+		UploadId: f.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: f.parts,
+		},
+	})
+	if err != nil {
+		f.abort()
+		return err
+	}
+	return nil
+}
+
+func (f *S3File) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, errFileClosed
+	}
+	if f.writable {
+		return 0, fs.ErrPermission
+	}
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		f.body, err = f.filesystem.getRange(f.key, f.offset, f.size-1)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes from off with a single ranged GET,
+// independent of the file's read offset
+func (f *S3File) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, errFileClosed
+	}
+	if f.writable {
+		return 0, fs.ErrPermission
+	}
+	if off < 0 {
+		return 0, errSeekNegative
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := min(off+int64(len(p)), f.size) - 1
+	body, err := f.filesystem.getRange(f.key, off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err = io.ReadFull(body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *S3File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, errFileClosed
+	}
+	if f.writable {
+		return 0, fs.ErrPermission
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errSeekInvalidWhence
+	}
+	if offset < 0 {
+		return 0, errSeekNegative
+	}
+	if offset != f.offset && f.body != nil {
+		// The next Read starts a new ranged GET from the new offset
+		err := f.body.Close()
+		f.body = nil
+		if err != nil {
+			return 0, err
+		}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Size returns the size of an object opened for reading
+func (f *S3File) Size() int64 {
+	return f.size
+}
+
+func (f *S3File) Close() error {
+	if f.closed {
+		return errFileClosed
+	}
+	f.closed = true
+
+	if f.writable {
+		return f.flush()
+	}
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
 }
 
 //nolint:golint,unparam
@@ -92,21 +324,39 @@ func (s S3) Close() error {
 	return nil
 }
 
+// getRange fetches the bytes of key from start to end inclusive
+func (s S3) getRange(key string, start, end int64) (io.ReadCloser, error) {
+	res, err := s.s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
 func (s S3) Open(name string) (File, error) {
+	key := filepath.Join(s.root, name)
 	res, err := s.s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(filepath.Join(s.root, name)),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		slog.Error("failed to open file", "bucket", s.bucket, "file", filepath.Join(s.root, name), "error", err)
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		slog.Error("failed to open file", "bucket", s.bucket, "file", key, "error", err)
 		return nil, err
 	}
 
 	return &S3File{
 		body:       res.Body,
+		size:       aws.ToInt64(res.ContentLength),
 		filesystem: &s,
-		key:        filepath.Join(s.root, name),
-		writer:     bufferWriter{buffer: make([]byte, 0)},
+		key:        key,
 	}, nil
 }
 
@@ -128,7 +378,7 @@ func (s S3) Create(name string) (File, error) {
 	return &S3File{
 		filesystem: &s,
 		key:        filepath.Join(s.root, name),
-		writer:     bufferWriter{buffer: make([]byte, 0)},
+		writable:   true,
 	}, nil
 }
 
@@ -139,3 +389,169 @@ func (s S3) Remove(name string) error {
 	})
 	return err
 }
+
+func (s S3) PresignPut(name string, expires time.Duration) (string, map[string]string, error) {
+	req, err := s3.NewPresignClient(s.s3Client).PresignPutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.root, name)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", nil, err
+	}
+
+	headers := make(map[string]string, len(req.SignedHeader))
+	for key := range req.SignedHeader {
+		// The client sets Host itself from the URL
+		if key == "Host" {
+			continue
+		}
+		headers[key] = req.SignedHeader.Get(key)
+	}
+	return req.URL, headers, nil
+}
+
+// key maps a name relative to this storage to its object key
+func (s S3) key(name string) string {
+	return filepath.Join(s.root, name)
+}
+
+// prefix maps a directory name relative to this storage to the prefix of the keys under it
+func (s S3) prefix(name string) string {
+	key := s.key(name)
+	if key == "." || key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s S3) Stat(name string) (fs.FileInfo, error) {
+	res, err := s.s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err == nil {
+		return s3FileInfo{
+			name:    filepath.Base(name),
+			size:    aws.ToInt64(res.ContentLength),
+			modTime: aws.ToTime(res.LastModified),
+		}, nil
+	}
+	var notFound *types.NotFound
+	if !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	// S3 has no directories, but any key under name/ means it acts like one
+	list, err := s.s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(s.prefix(name)),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Contents) == 0 && s.prefix(name) != "" {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return s3FileInfo{name: filepath.Base(name), dir: true}, nil
+}
+
+func (s S3) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := s.prefix(name)
+	var entries []fs.DirEntry
+	paginator := s3.NewListObjectsV2Paginator(s.s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			dir := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), prefix), "/")
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{name: dir, dir: true}))
+		}
+		for _, object := range page.Contents {
+			entries = append(entries, fs.FileInfoToDirEntry(s3FileInfo{
+				name:    strings.TrimPrefix(aws.ToString(object.Key), prefix),
+				size:    aws.ToInt64(object.Size),
+				modTime: aws.ToTime(object.LastModified),
+			}))
+		}
+	}
+	if len(entries) == 0 && prefix != "" {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (s S3) Walk(root string, fn fs.WalkDirFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walkDir(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkDir mirrors the recursion in fs.WalkDir
+func (s S3) walkDir(name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := s.ReadDir(name)
+	if err != nil {
+		err = fn(name, d, err)
+		if err != nil {
+			if errors.Is(err, fs.SkipDir) && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		err := s.walkDir(path.Join(name, entry.Name()), entry, fn)
+		if err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// s3FileInfo describes an object, or a key prefix standing in for a directory
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i s3FileInfo) Name() string       { return i.name }
+func (i s3FileInfo) Size() int64        { return i.size }
+func (i s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i s3FileInfo) IsDir() bool        { return i.dir }
+func (i s3FileInfo) Sys() any           { return nil }
+
+func (i s3FileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
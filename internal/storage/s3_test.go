@@ -0,0 +1,198 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+func TestS3PresignedUpload(t *testing.T) {
+	store, _ := newFakeS3Storage(t)
+
+	presigner, ok := store.(storage.Presigner)
+	if !ok {
+		t.Fatal("S3 storage should support presigned uploads")
+	}
+
+	_, err := store.Stat("1234567890abcdef/boot/0000")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	uploadURL, headers, err := presigner.PresignPut("1234567890abcdef/boot/0000", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(uploadURL, "X-Amz-Signature=") {
+		t.Errorf("expected a signed URL, got %s", uploadURL)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, uploadURL, strings.NewReader("boot log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	info, err := store.Stat("1234567890abcdef/boot/0000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.ModTime().Before(before.Truncate(time.Second)) {
+		t.Errorf("expected modification time after %v, got %v", before, info.ModTime())
+	}
+
+	sub, err := store.Sub("1234567890abcdef")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err := sub.Open("boot/0000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "boot log" {
+		t.Errorf("expected %q, got %q", "boot log", string(data))
+	}
+}
+
+func TestS3MultipartWrite(t *testing.T) {
+	store, fake := newFakeS3Storage(t)
+
+	// Two and a half parts
+	data := bytes.Repeat([]byte("0123456789abcdef"), (storage.S3PartSize*5/2)/16)
+
+	f, err := store.Create("1234567890abcdef/route/0/fcamera.hevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Write in odd sized pieces so they straddle part boundaries
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 1<<20+7)
+		written, err := f.Write(rest[:n])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if written != n {
+			t.Fatalf("expected to write %d bytes, wrote %d", n, written)
+		}
+		rest = rest[n:]
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored, ok := fake.object("1234567890abcdef/route/0/fcamera.hevc")
+	if !ok {
+		t.Fatal("expected object to be stored")
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("stored object doesn't match what was written")
+	}
+	if fake.maxPart > storage.S3PartSize {
+		t.Errorf("expected parts of at most %d bytes, got %d", storage.S3PartSize, fake.maxPart)
+	}
+	if fake.pendingUploads() != 0 {
+		t.Errorf("expected no pending multipart uploads, got %d", fake.pendingUploads())
+	}
+}
+
+func TestS3MultipartWriteAbortsOnError(t *testing.T) {
+	store, fake := newFakeS3Storage(t)
+	fake.failPart = 2
+
+	f, err := store.Create("1234567890abcdef/route/0/fcamera.hevc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = f.Write(make([]byte, storage.S3PartSize*2))
+	if err == nil {
+		t.Fatal("expected write to fail")
+	}
+	if err := f.Close(); err == nil {
+		t.Error("expected close to report the failed write")
+	}
+
+	if _, ok := fake.object("1234567890abcdef/route/0/fcamera.hevc"); ok {
+		t.Error("expected no object to be stored")
+	}
+	if fake.aborted != 1 {
+		t.Errorf("expected the multipart upload to be aborted, got %d aborts", fake.aborted)
+	}
+	if fake.pendingUploads() != 0 {
+		t.Errorf("expected no pending multipart uploads, got %d", fake.pendingUploads())
+	}
+}
+
+func TestS3RangedRead(t *testing.T) {
+	store, _ := newFakeS3Storage(t)
+
+	f, err := store.Create("qlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err = store.Open("qlog")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	seeker, ok := f.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("expected S3 files to be seekable")
+	}
+	if _, err := seeker.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := io.ReadAll(seeker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "6789" {
+		t.Errorf("expected %q, got %q", "6789", string(data))
+	}
+
+	readerAt, ok := f.(io.ReaderAt)
+	if !ok {
+		t.Fatal("expected S3 files to support ReadAt")
+	}
+	buf := make([]byte, 3)
+	if _, err := readerAt.ReadAt(buf, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf) != "234" {
+		t.Errorf("expected %q, got %q", "234", string(buf))
+	}
+
+	_, err = store.Open("missing")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
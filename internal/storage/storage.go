@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/USA-RedDragon/rtz-server/internal/config"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,11 +22,19 @@ type Manager interface {
 	MkdirAll(name string, perm fs.FileMode) error
 	Remove(name string) error
 	Sub(dir string) (Storage, error)
+	Stat(name string) (fs.FileInfo, error)
+	// ReadDir returns the entries of a directory sorted by name
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// Walk behaves like fs.WalkDir
+	Walk(root string, fn fs.WalkDirFunc) error
 }
 
+// File is read and seeked when it comes from Open, and written when it comes from Create
 type File interface {
 	io.ReadCloser
 	io.Writer
+	io.Seeker
+	io.ReaderAt
 }
 
 type Storage interface {
@@ -33,6 +42,14 @@ type Storage interface {
 	Close() error
 }
 
+// Presigner is implemented by storage drivers that can hand out URLs
+// for clients to upload directly to the backing store
+type Presigner interface {
+	// PresignPut returns a URL and the headers to send with it
+	// that allow a single PUT of name until the URL expires
+	PresignPut(name string, expires time.Duration) (string, map[string]string, error)
+}
+
 func NewStorage(cfg *config.Config) (Storage, error) {
 	switch cfg.Persistence.Uploads.Driver {
 	case config.UploadsDriverFilesystem:
@@ -0,0 +1,153 @@
+package storage_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+)
+
+func newFilesystemStorage(t *testing.T) storage.Storage {
+	t.Helper()
+	store, err := storage.NewStorage(&config.Config{
+		Persistence: config.Persistence{
+			Uploads: config.Uploads{
+				Driver: config.UploadsDriverFilesystem,
+				FilesystemOptions: config.FilesystemOptions{
+					Directory: t.TempDir(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func writeFile(t *testing.T, store storage.Storage, name string, data string) {
+	t.Helper()
+	err := store.MkdirAll(path.Dir(name), 0755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err := store.Create(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.Write([]byte(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFilesystemManager(t *testing.T) {
+	t.Parallel()
+	testManager(t, newFilesystemStorage(t))
+}
+
+func TestS3Manager(t *testing.T) {
+	store, _ := newFakeS3Storage(t)
+	testManager(t, store)
+}
+
+func testManager(t *testing.T, store storage.Storage) {
+	t.Helper()
+
+	writeFile(t, store, "dongle/route--0/qlog.zst", "0123456789")
+	writeFile(t, store, "dongle/route--0/qcamera.ts", "qcamera")
+	writeFile(t, store, "dongle/route--1/qlog.zst", "qlog")
+	writeFile(t, store, "dongle/boot/0000", "boot")
+
+	info, err := store.Stat("dongle/route--0/qlog.zst")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.IsDir() || info.Size() != 10 || info.Name() != "qlog.zst" {
+		t.Errorf("unexpected file info: name=%s size=%d dir=%t", info.Name(), info.Size(), info.IsDir())
+	}
+
+	info, err = store.Stat("dongle/route--0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !info.IsDir() {
+		t.Error("expected dongle/route--0 to be a directory")
+	}
+
+	_, err = store.Stat("dongle/route--2")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	entries, err := store.ReadDir("dongle")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		if !entry.IsDir() {
+			t.Errorf("expected %s to be a directory", entry.Name())
+		}
+	}
+	if !slices.Equal(names, []string{"boot", "route--0", "route--1"}) {
+		t.Errorf("unexpected directory entries: %v", names)
+	}
+
+	var walked []string
+	err = store.Walk("dongle", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "boot" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() {
+			walked = append(walked, name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"dongle/route--0/qcamera.ts", "dongle/route--0/qlog.zst", "dongle/route--1/qlog.zst"}
+	if !slices.Equal(walked, expected) {
+		t.Errorf("expected walk to visit %v, got %v", expected, walked)
+	}
+
+	sub, err := store.Sub("dongle")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err := sub.Open("route--0/qlog.zst")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, 4)
+	if _, err := f.ReadAt(buf, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(buf) != "3456" {
+		t.Errorf("expected %q, got %q", "3456", string(buf))
+	}
+	if _, err := f.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(rest) != "89" {
+		t.Errorf("expected %q, got %q", "89", string(rest))
+	}
+}
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// LogLevels maps the Python logging level names swaglog uses to their numbers
+//
+//nolint:golint,gochecknoglobals
+var LogLevels = map[string]int{
+	"DEBUG":    10,
+	"INFO":     20,
+	"WARNING":  30,
+	"ERROR":    40,
+	"CRITICAL": 50,
+}
+
+// ParseLogs decodes the swaglog lines openpilot sends with forwardLogs. Each
+// line is a JSON object, with its keys suffixed by the type of their value
+// by the file formatter, so a message is under msg$s or msg:
+//
+//	{"msg$s": "...", "ctx": {"daemon$s": "manager"}, "level$s": "INFO", "levelnum$i": 20, "created$f": 1700000000.5}
+//
+// Lines that aren't JSON are skipped and reported in the error alongside the lines that parsed.
+func ParseLogs(entry string, payload string) ([]models.DeviceLog, error) {
+	var logs []models.DeviceLog
+	var errs []error
+	for i, line := range strings.Split(payload, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var raw map[string]any
+		err := json.Unmarshal([]byte(line), &raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+
+		log := models.DeviceLog{
+			Entry: entry,
+			Line:  i,
+			Raw:   line,
+		}
+		if created, ok := swaglogValue(raw, "created").(float64); ok {
+			seconds, fraction := math.Modf(created)
+			log.Time = time.Unix(int64(seconds), int64(fraction*float64(time.Second)))
+		}
+		if level, ok := swaglogValue(raw, "level").(string); ok {
+			log.Level = level
+		}
+		if levelNum, ok := swaglogValue(raw, "levelnum").(float64); ok {
+			log.LevelNum = int(levelNum)
+		} else {
+			log.LevelNum = LogLevels[log.Level]
+		}
+		if ctx, ok := swaglogValue(raw, "ctx").(map[string]any); ok {
+			if daemon, ok := swaglogValue(ctx, "daemon").(string); ok {
+				log.Daemon = daemon
+			}
+		}
+		switch msg := swaglogValue(raw, "msg").(type) {
+		case nil:
+		case string:
+			log.Message = msg
+		default:
+			// Events log a structure instead of a message
+			message, err := json.Marshal(msg)
+			if err == nil {
+				log.Message = string(message)
+			}
+		}
+		logs = append(logs, log)
+	}
+	return logs, errors.Join(errs...)
+}
+
+// swaglogValue looks up key with or without the type suffix added by swaglog's file formatter
+func swaglogValue(raw map[string]any, key string) any {
+	for _, suffix := range []string{"", "$s", "$f", "$i", "$b", "$a"} {
+		if value, ok := raw[key+suffix]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// StoreLogs saves the lines of a forwardLogs payload for a device. Lines
+// without a time are given the time they were received.
+func StoreLogs(db *gorm.DB, device *models.Device, logs []models.DeviceLog) error {
+	now := time.Now()
+	for i := range logs {
+		logs[i].DeviceID = device.ID
+		if logs[i].Time.IsZero() {
+			logs[i].Time = now
+		}
+	}
+	return models.CreateDeviceLogs(db, logs)
+}
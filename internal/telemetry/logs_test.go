@@ -0,0 +1,106 @@
+package telemetry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/telemetry"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+)
+
+const logs = `{"msg$s": "starting manager", "ctx": {"daemon$s": "manager", "dongle_id$s": "0000000000000001"}, "level$s": "INFO", "levelnum$i": 20, "created$f": 1700000000.5}
+{"msg$s": "disk 95% full", "ctx": {"daemon$s": "deleter"}, "level$s": "WARNING", "levelnum$i": 30, "created$f": 1700000001.0}
+not json
+{"msg": {"event$s": "thermal"}, "ctx": {"daemon": "thermald"}, "level": "ERROR", "created": 1700000002.0}
+`
+
+func TestParseAndSearchLogs(t *testing.T) {
+	t.Parallel()
+	parsed, err := telemetry.ParseLogs("2023-11-14--22-13-20.log", logs)
+	if err == nil {
+		t.Fatalf("expected the line that isn't JSON to be reported")
+	}
+	if len(parsed) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(parsed))
+	}
+	first := parsed[0]
+	if first.Message != "starting manager" || first.Daemon != "manager" || first.Level != "INFO" || first.LevelNum != 20 {
+		t.Fatalf("unexpected first line: %+v", first)
+	}
+	if !first.Time.Equal(time.Unix(1_700_000_000, int64(500*time.Millisecond))) {
+		t.Fatalf("unexpected time: %v", first.Time)
+	}
+	event := parsed[2]
+	if event.Message != `{"event$s":"thermal"}` || event.Daemon != "thermald" || event.LevelNum != 40 {
+		t.Fatalf("unexpected event line: %+v", event)
+	}
+
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+
+	// The device sends a file again when it misses the response
+	for range 2 {
+		parsed, _ := telemetry.ParseLogs("2023-11-14--22-13-20.log", logs)
+		if err := telemetry.StoreLogs(database, &device, parsed); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	window := models.DeviceLogFilter{Start: time.Unix(1_700_000_000, 0), End: time.Unix(1_700_000_010, 0)}
+	found, err := models.FindDeviceLogs(database, device.ID, window)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 3 {
+		t.Fatalf("expected lines sent twice to be stored once, got %d", len(found))
+	}
+
+	filter := window
+	filter.MinLevelNum = telemetry.LogLevels["WARNING"]
+	found, err = models.FindDeviceLogs(database, device.ID, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 2 || found[0].Daemon != "deleter" || found[1].Daemon != "thermald" {
+		t.Fatalf("expected the warning and error, got %+v", found)
+	}
+
+	filter = window
+	filter.Contains = "95% FULL"
+	found, err = models.FindDeviceLogs(database, device.ID, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 1 || found[0].Message != "disk 95% full" {
+		t.Fatalf("expected the disk warning, got %+v", found)
+	}
+
+	filter.Contains = "5%f"
+	found, err = models.FindDeviceLogs(database, device.ID, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("expected %% to be matched literally, got %+v", found)
+	}
+
+	filter = window
+	filter.End = time.Unix(1_700_000_001, 0)
+	found, err = models.FindDeviceLogs(database, device.ID, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected the lines up to the end of the window, got %d", len(found))
+	}
+
+	telemetry.NewPruner(database, 24*time.Hour, 24*time.Hour).Check()
+	found, err = models.FindDeviceLogs(database, device.ID, window)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("expected lines past retention to be pruned, got %d", len(found))
+	}
+}
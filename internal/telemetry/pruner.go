@@ -0,0 +1,71 @@
+package telemetry
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// PruneInterval is how often telemetry past its retention is deleted
+const PruneInterval = time.Hour
+
+// Pruner deletes the telemetry devices reported once it's older than its retention
+type Pruner struct {
+	db *gorm.DB
+	// metricsRetention is how long metrics are kept, and logRetention log lines
+	metricsRetention time.Duration
+	logRetention     time.Duration
+	interval         time.Duration
+	stopChan         chan any
+	closeChan        chan any
+}
+
+func NewPruner(db *gorm.DB, metricsRetention, logRetention time.Duration) *Pruner {
+	return &Pruner{
+		db:               db,
+		metricsRetention: metricsRetention,
+		logRetention:     logRetention,
+		interval:         PruneInterval,
+		stopChan:         make(chan any),
+		closeChan:        make(chan any),
+	}
+}
+
+func (p *Pruner) Start() {
+	p.Check()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			p.closeChan <- struct{}{}
+			return
+		case <-ticker.C:
+			p.Check()
+		}
+	}
+}
+
+func (p *Pruner) Stop() {
+	close(p.stopChan)
+	<-p.closeChan
+}
+
+// Check deletes the telemetry that's past its retention
+func (p *Pruner) Check() {
+	deleted, err := models.DeleteDeviceMetricsBefore(p.db, time.Now().Add(-p.metricsRetention))
+	if err != nil {
+		slog.Error("Failed to prune device metrics", "error", err)
+	} else if deleted > 0 {
+		slog.Debug("Pruned device metrics", "deleted", deleted)
+	}
+
+	deleted, err = models.DeleteDeviceLogsBefore(p.db, time.Now().Add(-p.logRetention))
+	if err != nil {
+		slog.Error("Failed to prune device logs", "error", err)
+	} else if deleted > 0 {
+		slog.Debug("Pruned device logs", "deleted", deleted)
+	}
+}
@@ -0,0 +1,143 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/metrics"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMalformedLine = errors.New("malformed stats line")
+)
+
+// Point is a single measurement from a storeStats payload
+type Point struct {
+	Name   string
+	Tags   map[string]string
+	Fields map[string]float64
+	Time   time.Time
+}
+
+// ParseStats decodes the stats openpilot's statsd sends with storeStats. They're
+// InfluxDB line protocol, one measurement per line:
+//
+//	gauge.cpu_temp,started=True,version=0.9.7 value=52.0,dongle_id=1d3dc3e03047b0c7 1700000000000000000
+//
+// The dongle ID field and any other field that isn't a number are skipped. Malformed lines
+// are skipped too and reported in the error alongside the points that parsed.
+func ParseStats(payload string) ([]Point, error) {
+	var points []Point
+	var errs []error
+	for i, line := range strings.Split(payload, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		point, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, errors.Join(errs...)
+}
+
+func parseLine(line string) (Point, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 3 {
+		return Point{}, ErrMalformedLine
+	}
+	key, fields, timestamp := parts[0], strings.Join(parts[1:len(parts)-1], " "), parts[len(parts)-1]
+
+	nanos, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("%w: bad timestamp %q", ErrMalformedLine, timestamp)
+	}
+	point := Point{
+		Tags:   map[string]string{},
+		Fields: map[string]float64{},
+		Time:   time.Unix(0, nanos),
+	}
+
+	tags := strings.Split(key, ",")
+	point.Name = tags[0]
+	if point.Name == "" {
+		return Point{}, fmt.Errorf("%w: missing measurement", ErrMalformedLine)
+	}
+	for _, tag := range tags[1:] {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok {
+			return Point{}, fmt.Errorf("%w: bad tag %q", ErrMalformedLine, tag)
+		}
+		point.Tags[k] = v
+	}
+
+	for _, field := range strings.Split(fields, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return Point{}, fmt.Errorf("%w: bad field %q", ErrMalformedLine, field)
+		}
+		if k == "dongle_id" {
+			// Dongle IDs are hex and can look like a number
+			continue
+		}
+		// Integers are suffixed with an i
+		value, err := strconv.ParseFloat(strings.TrimSuffix(v, "i"), 64)
+		if err != nil {
+			continue
+		}
+		point.Fields[k] = value
+	}
+	if len(point.Fields) == 0 {
+		return Point{}, fmt.Errorf("%w: no numeric fields", ErrMalformedLine)
+	}
+	return point, nil
+}
+
+// exported reports whether a field of a device metric is in a Prometheus
+// allow-list. Entries are <name>:<field>, or just <name> for its value field.
+func exported(allowList []string, name, field string) bool {
+	return slices.Contains(allowList, name+":"+field) || (field == "value" && slices.Contains(allowList, name))
+}
+
+// StoreStats saves the points of a storeStats payload for a device. Fields
+// allowed by exportPrometheus are also set on the Prometheus metrics, the
+// allow-list is what keeps devices from adding series without limit.
+func StoreStats(db *gorm.DB, m *metrics.Metrics, exportPrometheus []string, device *models.Device, points []Point) error {
+	var rows []models.DeviceMetric
+	for _, point := range points {
+		tags, err := json.Marshal(point.Tags)
+		if err != nil {
+			return err
+		}
+		fields := make([]string, 0, len(point.Fields))
+		for field := range point.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			rows = append(rows, models.DeviceMetric{
+				DeviceID: device.ID,
+				Name:     point.Name,
+				Field:    field,
+				Value:    point.Fields[field],
+				Time:     point.Time,
+				Tags:     string(tags),
+			})
+			if exported(exportPrometheus, point.Name, field) {
+				m.SetDeviceMetric(device.DongleID, point.Name, field, point.Fields[field])
+			}
+		}
+	}
+	return models.CreateDeviceMetrics(db, rows)
+}
@@ -0,0 +1,97 @@
+package telemetry_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/telemetry"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+)
+
+const stats = `gauge.cpu_temp,started=True,version=0.9.7,branch=release3 value=52.5,dongle_id=0000000000000001 1700000000000000000
+sample.modeld_time,started=True,version=0.9.7,branch=release3 count=20,min=0.01,max=0.05,mean=0.02,p5=0.01,p50=0.02,p95=0.04,dongle_id=0000000000000001 1700000000000000000
+not a valid line
+gauge.free_space,started=False value=0.5i,dongle_id=0000000000000001 1700000060000000000
+`
+
+func TestParseStats(t *testing.T) {
+	t.Parallel()
+	points, err := telemetry.ParseStats(stats)
+	if !errors.Is(err, telemetry.ErrMalformedLine) {
+		t.Fatalf("expected the malformed line to be reported, got %v", err)
+	}
+	if len(points) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(points))
+	}
+
+	gauge := points[0]
+	if gauge.Name != "gauge.cpu_temp" {
+		t.Fatalf("expected gauge.cpu_temp, got %s", gauge.Name)
+	}
+	if gauge.Tags["version"] != "0.9.7" || gauge.Tags["started"] != "True" {
+		t.Fatalf("unexpected tags: %v", gauge.Tags)
+	}
+	if len(gauge.Fields) != 1 || math.Abs(gauge.Fields["value"]-52.5) > 1e-9 {
+		t.Fatalf("expected only the value field, got %v", gauge.Fields)
+	}
+	if !gauge.Time.Equal(time.Unix(1_700_000_000, 0)) {
+		t.Fatalf("unexpected time: %v", gauge.Time)
+	}
+
+	sample := points[1]
+	if len(sample.Fields) != 7 || math.Abs(sample.Fields["p95"]-0.04) > 1e-9 {
+		t.Fatalf("unexpected sample fields: %v", sample.Fields)
+	}
+
+	if math.Abs(points[2].Fields["value"]-0.5) > 1e-9 {
+		t.Fatalf("expected the integer suffix to be dropped, got %v", points[2].Fields)
+	}
+}
+
+func TestStoreAndPruneStats(t *testing.T) {
+	t.Parallel()
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+
+	now := time.Now()
+	points := []telemetry.Point{
+		{Name: "gauge.cpu_temp", Fields: map[string]float64{"value": 50}, Time: now.Add(-48 * time.Hour)},
+		{Name: "gauge.cpu_temp", Fields: map[string]float64{"value": 51}, Time: now.Add(-time.Hour)},
+		{Name: "gauge.cpu_temp", Tags: map[string]string{"version": "0.9.7"}, Fields: map[string]float64{"value": 52}, Time: now},
+		{Name: "sample.modeld_time", Fields: map[string]float64{"count": 20, "p50": 0.02}, Time: now},
+	}
+	if err := telemetry.StoreStats(database, nil, nil, &device, points); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names, err := models.ListDeviceMetricNames(database, device.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 || names[0] != "gauge.cpu_temp" || names[1] != "sample.modeld_time" {
+		t.Fatalf("unexpected metric names: %v", names)
+	}
+
+	metrics, err := models.FindDeviceMetrics(database, device.ID, "gauge.cpu_temp", now.Add(-2*time.Hour), now, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(metrics) != 2 || metrics[0].Value != 51 || metrics[1].Value != 52 {
+		t.Fatalf("expected the last two temperatures in order, got %v", metrics)
+	}
+	if metrics[1].Tags != `{"version":"0.9.7"}` {
+		t.Fatalf("unexpected tags: %s", metrics[1].Tags)
+	}
+
+	telemetry.NewPruner(database, 24*time.Hour, 24*time.Hour).Check()
+	metrics, err = models.FindDeviceMetrics(database, device.ID, "gauge.cpu_temp", time.Time{}, now, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("expected the temperature past retention to be pruned, got %d left", len(metrics))
+	}
+}
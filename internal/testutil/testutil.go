@@ -0,0 +1,60 @@
+// Package testutil sets up the database and fixtures tests have in common
+package testutil
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"gorm.io/gorm"
+)
+
+// DongleID is the dongle ID of the device NewDevice creates
+const DongleID = "0000000000000001"
+
+// Config returns a config with a SQLite database and filesystem uploads,
+// both kept in the test's temporary directory
+func Config(t testing.TB) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	return &config.Config{
+		Persistence: config.Persistence{
+			Database: config.Database{
+				Driver:   config.DatabaseDriverSQLite,
+				Database: filepath.Join(dir, "rtz.db"),
+			},
+			Uploads: config.Uploads{
+				Driver:            config.UploadsDriverFilesystem,
+				FilesystemOptions: config.FilesystemOptions{Directory: filepath.Join(dir, "uploads")},
+			},
+		},
+	}
+}
+
+// OpenDB opens and migrates the database of cfg
+func OpenDB(t testing.TB, cfg *config.Config) *gorm.DB {
+	t.Helper()
+	database, err := db.MakeDB(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return database
+}
+
+// NewDB returns a fresh, migrated database
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	return OpenDB(t, Config(t))
+}
+
+// NewDevice creates a device in database with DongleID
+func NewDevice(t testing.TB, database *gorm.DB) models.Device {
+	t.Helper()
+	device := models.Device{DongleID: DongleID, PublicKey: "key", Serial: "1"}
+	if err := database.Create(&device).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return device
+}
@@ -0,0 +1,205 @@
+package track
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// Point is a position along a route's track
+type Point struct {
+	// Time is the zero time when the device's clock wasn't known
+	Time      time.Time
+	Latitude  float64
+	Longitude float64
+	// Speed is in meters per second
+	Speed float64
+	// Distance is how far the device had travelled since the start of the route, in meters
+	Distance float64
+}
+
+// Segment holds the points recorded during one segment of a route
+type Segment struct {
+	Number int
+	Points []Point
+}
+
+func points(segments []Segment) []Point {
+	var all []Point
+	for _, segment := range segments {
+		all = append(all, segment.Points...)
+	}
+	return all
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Name string `json:"name"`
+	// CoordTimes follows the convention of togeojson for timestamping coordinates
+	CoordTimes []string `json:"coordTimes"`
+}
+
+// GeoJSON renders a route's track as a GeoJSON Feature holding a LineString
+func GeoJSON(name string, segments []Segment) ([]byte, error) {
+	all := points(segments)
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONLineString{
+			Type:        "LineString",
+			Coordinates: make([][2]float64, 0, len(all)),
+		},
+		Properties: geoJSONProperties{
+			Name:       name,
+			CoordTimes: make([]string, 0, len(all)),
+		},
+	}
+	for _, point := range all {
+		feature.Geometry.Coordinates = append(feature.Geometry.Coordinates, [2]float64{point.Longitude, point.Latitude})
+		feature.Properties.CoordTimes = append(feature.Properties.CoordTimes, formatTime(point.Time))
+	}
+	return json.Marshal(feature)
+}
+
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Time      string  `xml:"time,omitempty"`
+}
+
+// GPX renders a route's track as a GPX 1.1 track with a track segment per route segment
+func GPX(name string, segments []Segment) ([]byte, error) {
+	doc := gpx{
+		Version: "1.1",
+		Creator: "rtz-server",
+		Track:   gpxTrack{Name: name},
+	}
+	for _, segment := range segments {
+		trkseg := gpxSegment{Points: make([]gpxPoint, 0, len(segment.Points))}
+		for _, point := range segment.Points {
+			trkseg.Points = append(trkseg.Points, gpxPoint{
+				Latitude:  point.Latitude,
+				Longitude: point.Longitude,
+				Time:      formatTime(point.Time),
+			})
+		}
+		doc.Track.Segments = append(doc.Track.Segments, trkseg)
+	}
+	return marshalXML(doc)
+}
+
+type kml struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	GX       string      `xml:"xmlns:gx,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name      string       `xml:"name"`
+	Placemark kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name       string        `xml:"name"`
+	MultiTrack kmlMultiTrack `xml:"gx:MultiTrack"`
+}
+
+type kmlMultiTrack struct {
+	Tracks []kmlTrack `xml:"gx:Track"`
+}
+
+type kmlTrack struct {
+	When   []string `xml:"when"`
+	Coords []string `xml:"gx:coord"`
+}
+
+// KML renders a route's track as a KML placemark with a timestamped gx:Track per route segment
+func KML(name string, segments []Segment) ([]byte, error) {
+	doc := kml{
+		GX: "http://www.google.com/kml/ext/2.2",
+		Document: kmlDocument{
+			Name:      name,
+			Placemark: kmlPlacemark{Name: name},
+		},
+	}
+	for _, segment := range segments {
+		var track kmlTrack
+		for _, point := range segment.Points {
+			track.When = append(track.When, formatTime(point.Time))
+			track.Coords = append(track.Coords, fmt.Sprintf("%f %f 0", point.Longitude, point.Latitude))
+		}
+		doc.Document.Placemark.MultiTrack.Tracks = append(doc.Document.Placemark.MultiTrack.Tracks, track)
+	}
+	return marshalXML(doc)
+}
+
+func marshalXML(doc any) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// Coord is an entry of the coords.json files comma's Connect draws a route's path from
+type Coord struct {
+	// T is the number of seconds since the start of the route
+	T         float64 `json:"t"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+	Speed     float64 `json:"speed"`
+	// Dist is the distance since the start of the route, in meters
+	Dist float64 `json:"dist"`
+}
+
+// Coords converts a segment's points to coords.json entries for a route that started at start
+func Coords(start time.Time, points []Point) []Coord {
+	coords := make([]Coord, 0, len(points))
+	for _, point := range points {
+		var t float64
+		if !point.Time.IsZero() && !start.IsZero() {
+			t = point.Time.Sub(start).Seconds()
+		}
+		coords = append(coords, Coord{
+			T:         t,
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+			Speed:     point.Speed,
+			Dist:      point.Distance,
+		})
+	}
+	return coords
+}
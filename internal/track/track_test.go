@@ -0,0 +1,128 @@
+package track_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/track"
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	start    = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	segments = []track.Segment{
+		{Number: 0, Points: []track.Point{
+			{Time: start, Latitude: 40.0, Longitude: -75.0, Speed: 10, Distance: 0},
+			{Time: start.Add(time.Second), Latitude: 40.0001, Longitude: -75.0, Speed: 11, Distance: 11},
+		}},
+		{Number: 2, Points: []track.Point{
+			{Time: start.Add(2 * time.Minute), Latitude: 40.01, Longitude: -75.01, Speed: 12, Distance: 1500},
+		}},
+	}
+)
+
+func TestGeoJSON(t *testing.T) {
+	t.Parallel()
+	body, err := track.GeoJSON("route", segments)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var feature struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string       `json:"type"`
+			Coordinates [][2]float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			CoordTimes []string `json:"coordTimes"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &feature); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if feature.Type != "Feature" || feature.Geometry.Type != "LineString" {
+		t.Errorf("expected a LineString feature, got %s of %s", feature.Type, feature.Geometry.Type)
+	}
+	if len(feature.Geometry.Coordinates) != 3 {
+		t.Fatalf("expected 3 coordinates, got %d", len(feature.Geometry.Coordinates))
+	}
+	// GeoJSON positions are longitude first
+	if feature.Geometry.Coordinates[2] != [2]float64{-75.01, 40.01} {
+		t.Errorf("unexpected coordinate %v", feature.Geometry.Coordinates[2])
+	}
+	if len(feature.Properties.CoordTimes) != 3 || feature.Properties.CoordTimes[1] != "2024-06-01T12:00:01Z" {
+		t.Errorf("unexpected coordinate times %v", feature.Properties.CoordTimes)
+	}
+}
+
+func TestGPX(t *testing.T) {
+	t.Parallel()
+	body, err := track.GPX("route", segments)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var gpx struct {
+		Track struct {
+			Name     string `xml:"name"`
+			Segments []struct {
+				Points []struct {
+					Latitude float64 `xml:"lat,attr"`
+					Time     string  `xml:"time"`
+				} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}
+	if err := xml.Unmarshal(body, &gpx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gpx.Track.Name != "route" {
+		t.Errorf("expected track name %q, got %q", "route", gpx.Track.Name)
+	}
+	if len(gpx.Track.Segments) != 2 || len(gpx.Track.Segments[0].Points) != 2 || len(gpx.Track.Segments[1].Points) != 1 {
+		t.Fatalf("expected a track segment per route segment, got %+v", gpx.Track.Segments)
+	}
+	if gpx.Track.Segments[1].Points[0].Time != "2024-06-01T12:02:00Z" {
+		t.Errorf("unexpected time %q", gpx.Track.Segments[1].Points[0].Time)
+	}
+}
+
+func TestKML(t *testing.T) {
+	t.Parallel()
+	body, err := track.KML("route", segments)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kml := string(body)
+	if strings.Count(kml, "<gx:Track>") != 2 {
+		t.Errorf("expected a gx:Track per route segment, got %s", kml)
+	}
+	if strings.Count(kml, "<when>") != 3 || !strings.Contains(kml, "<when>2024-06-01T12:00:01Z</when>") {
+		t.Errorf("expected a timestamp per point, got %s", kml)
+	}
+	if !strings.Contains(kml, "<gx:coord>-75.010000 40.010000 0</gx:coord>") {
+		t.Errorf("expected coordinates longitude first, got %s", kml)
+	}
+}
+
+func TestCoords(t *testing.T) {
+	t.Parallel()
+	coords := track.Coords(start, segments[1].Points)
+	if len(coords) != 1 {
+		t.Fatalf("expected 1 coord, got %d", len(coords))
+	}
+	want := track.Coord{T: 120, Latitude: 40.01, Longitude: -75.01, Speed: 12, Dist: 1500}
+	if coords[0] != want {
+		t.Errorf("expected %+v, got %+v", want, coords[0])
+	}
+
+	// Points without a time are placed at the start of the route
+	coords = track.Coords(start, []track.Point{{Latitude: 1, Longitude: 2}})
+	if coords[0].T != 0 {
+		t.Errorf("expected t to be 0, got %f", coords[0].T)
+	}
+}
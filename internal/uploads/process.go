@@ -0,0 +1,181 @@
+package uploads
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
+	v1dot4 "github.com/USA-RedDragon/rtz-server/internal/server/apimodels/v1.4"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"gorm.io/gorm"
+)
+
+// maxLogMessageSegments is more segments than any log event is split into
+const maxLogMessageSegments = 512
+
+var (
+	ErrInvalidFile = errors.New("invalid file")
+
+	newRouteRegex = regexp.MustCompile(`^(?P<motonic>[0-9a-fA-F]+)--(?P<route>[0-9a-fA-F]+)--(?P<segment>\d+)`)
+	oldRouteRegex = regexp.MustCompile(`^(?P<date>\d{4}-\d{2}-\d{2})--(?P<time>\d{2}-\d{2}-\d{2})`)
+
+	segmentFiles = map[routefiles.Kind]models.SegmentFile{
+		routefiles.KindQLog:         models.SegmentFileQLog,
+		routefiles.KindLog:          models.SegmentFileRLog,
+		routefiles.KindQCamera:      models.SegmentFileQCamera,
+		routefiles.KindCamera:       models.SegmentFileFCamera,
+		routefiles.KindDriverCamera: models.SegmentFileDCamera,
+		routefiles.KindWideCamera:   models.SegmentFileECamera,
+	}
+)
+
+// Process runs the post-processing for a file a device has finished uploading.
+// base is the storage rooted at the device's upload directory.
+func Process(db *gorm.DB, base storage.Storage, logQueue *logparser.LogQueue, device models.Device, path string) error {
+	switch {
+	case strings.Contains(path, "boot/"):
+		// Boot log
+		err := db.Create(&models.BootLog{
+			DeviceID: device.ID,
+			FileName: filepath.Base(path),
+		}).Error
+		if err != nil {
+			slog.Error("Failed to create boot log", "error", err)
+			return err
+		}
+	case strings.Contains(path, "crash/"):
+		// Crash log
+		err := db.Create(&models.CrashLog{
+			DeviceID: device.ID,
+			FileName: filepath.Base(path),
+		}).Error
+		if err != nil {
+			slog.Error("Failed to create crash log", "error", err)
+			return err
+		}
+	case newRouteRegex.Match([]byte(path)):
+		slog.Warn("New route upload", "path", path)
+		match := newRouteRegex.FindStringSubmatch(path)
+		var result v1dot4.RouteInfo
+		for i, name := range newRouteRegex.SubexpNames() {
+			switch name {
+			case "motonic":
+				result.Motonic = match[i]
+			case "route":
+				result.Route = match[i]
+			case "segment":
+				result.Segment = match[i]
+			}
+		}
+
+		kind, _ := routefiles.KindOf(filepath.Base(path))
+		parse := kind == routefiles.KindQLog || kind == routefiles.KindLog
+		if parse {
+			err := verifyLog(base, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The upload has to be recorded before the log parser can mark it processed
+		err := recordSegmentUpload(db, base, device, path, result)
+		if err != nil {
+			slog.Error("Failed to record segment upload", "error", err)
+			return err
+		}
+		err = models.CompleteUploadRequests(db, device.ID, path)
+		if err != nil {
+			slog.Error("Failed to complete upload requests", "error", err)
+			return err
+		}
+		if parse {
+			err = logQueue.AddLog(device, path, result)
+			if err != nil {
+				slog.Error("Failed to queue log", "error", err)
+				return err
+			}
+		}
+	case oldRouteRegex.Match([]byte(path)):
+		slog.Warn("Old route upload", "path", path)
+	default:
+		slog.Warn("Got unknown upload path", "path", path)
+	}
+
+	return nil
+}
+
+// verifyLog checks that a log's contents match its compression
+func verifyLog(base storage.Storage, path string) error {
+	file, err := base.Open(path)
+	if err != nil {
+		slog.Error("Failed to open file", "error", err)
+		return err
+	}
+	defer file.Close()
+
+	switch filepath.Ext(path) {
+	case ".bz2":
+		header := make([]byte, 3)
+		read, err := file.Read(header)
+		if err != nil {
+			slog.Error("Failed to read header", "error", err)
+			return err
+		}
+		if read != 3 || string(header) != "BZh" {
+			slog.Error("Invalid header", "header", string(header))
+			return ErrInvalidFile
+		}
+	case ".zst":
+		var magic uint32
+		err = binary.Read(file, binary.LittleEndian, &magic)
+		if err != nil {
+			slog.Error("Failed to read magic", "error", err)
+			return err
+		}
+		// 0xFD2FB528 is the magic number for zstd
+		// https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#zstandard-frames
+		if magic != 0xFD2FB528 {
+			slog.Error("Invalid magic", "magic", magic)
+			return ErrInvalidFile
+		}
+	default:
+		// Uncompressed logs are a stream of capnp messages, each starting
+		// with its segment count minus one and the first segment's size
+		var header [2]uint32
+		err = binary.Read(file, binary.LittleEndian, &header)
+		if err != nil {
+			slog.Error("Failed to read message header", "error", err)
+			return err
+		}
+		if header[0] > maxLogMessageSegments || header[1] == 0 {
+			slog.Error("Invalid message header", "segments", header[0]+1, "size", header[1])
+			return ErrInvalidFile
+		}
+	}
+	return nil
+}
+
+// recordSegmentUpload tracks an uploaded segment file against its route
+func recordSegmentUpload(db *gorm.DB, base storage.Storage, device models.Device, path string, routeInfo v1dot4.RouteInfo) error {
+	kind, ok := routefiles.KindOf(filepath.Base(path))
+	if !ok {
+		slog.Warn("Got unknown segment file", "path", path)
+		return nil
+	}
+	segment, err := strconv.Atoi(routeInfo.Segment)
+	if err != nil {
+		return err
+	}
+	info, err := base.Stat(path)
+	if err != nil {
+		return err
+	}
+	return models.RecordSegmentUpload(db, device.ID, routeInfo.Route, segment, segmentFiles[kind], info.Size())
+}
@@ -0,0 +1,265 @@
+package uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/config"
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/routefiles"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RequestURLExpiry is how long the upload URLs sent with uploadFilesToUrls
+// stay valid. Devices wait for wifi unless allowed to use cellular, so it's
+// the longest S3 allows.
+const RequestURLExpiry = 7 * 24 * time.Hour
+
+var (
+	ErrDeviceError = errors.New("device returned an error")
+
+	// RequestFiles are the names devices are asked to upload each kind of file
+	// as. Logs are compressed by the device while they're uploaded.
+	//
+	//nolint:golint,gochecknoglobals
+	RequestFiles = map[routefiles.Kind]string{
+		routefiles.KindCamera:       "fcamera.hevc",
+		routefiles.KindDriverCamera: "dcamera.hevc",
+		routefiles.KindWideCamera:   "ecamera.hevc",
+		routefiles.KindLog:          "rlog.zst",
+		routefiles.KindQCamera:      "qcamera.ts",
+		routefiles.KindQLog:         "qlog.zst",
+	}
+)
+
+// CallFunc makes an RPC call to a device and waits for its response
+type CallFunc func(call apimodels.RPCCall) (apimodels.RPCResponse, error)
+
+// Presign returns a presigned URL for a device to upload path straight to
+// storage, and starts watching for the upload to land
+func Presign(db *gorm.DB, presigner storage.Presigner, device models.Device, path string, expiry time.Duration) (string, map[string]string, error) {
+	issuedAt := time.Now()
+	uploadURL, headers, err := presigner.PresignPut(filepath.Join(device.DongleID, path), expiry)
+	if err != nil {
+		return "", nil, err
+	}
+	err = models.UpsertPendingUpload(db, device.ID, path, issuedAt, issuedAt.Add(expiry))
+	if err != nil {
+		return "", nil, err
+	}
+	return uploadURL, headers, nil
+}
+
+// URL returns a URL and the headers to send with it that allow a device to
+// upload path without its own token
+func URL(cfg *config.Config, db *gorm.DB, store storage.Storage, device models.Device, path string, expiry time.Duration) (string, map[string]string, error) {
+	if presigner, ok := store.(storage.Presigner); ok {
+		return Presign(db, presigner, device, path, expiry)
+	}
+	token, err := utils.GenerateUploadJWT(cfg.JWT.Secret, device.DongleID+"/"+path, expiry)
+	if err != nil {
+		return "", nil, err
+	}
+	uploadURL := cfg.HTTP.BackendURL + "/v1.4/" + device.DongleID + "/upload?path=" + url.QueryEscape(path) + "&sig=" + url.QueryEscape(token)
+	return uploadURL, map[string]string{}, nil
+}
+
+// Dispatch sends a device's pending upload requests to it with uploadFilesToUrls
+func Dispatch(cfg *config.Config, db *gorm.DB, store storage.Storage, device models.Device, call CallFunc) error {
+	requests, err := models.ListUploadRequestsInState(db, device.ID, models.UploadRequestStatePending)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(RequestURLExpiry)
+	files := make([]apimodels.UploadFileData, 0, len(requests))
+	for _, request := range requests {
+		uploadURL, headers, err := URL(cfg, db, store, device, request.Path, RequestURLExpiry)
+		if err != nil {
+			return err
+		}
+		files = append(files, apimodels.UploadFileData{
+			FileName:      request.Path,
+			URL:           uploadURL,
+			Headers:       headers,
+			AllowCellular: request.AllowCellular,
+		})
+	}
+
+	var result apimodels.UploadFilesToURLsResult
+	err = callDevice(call, "uploadFilesToUrls", map[string]any{"files_data": files}, &result)
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]bool{}
+	for _, name := range result.Failed {
+		failed[name] = true
+	}
+	for _, request := range requests {
+		if failed[request.Path] {
+			// The device doesn't have the file
+			request.State = models.UploadRequestStateFailed
+		} else {
+			// Files already in the upload queue aren't listed in the items,
+			// their ID is picked up when the queue is next refreshed
+			request.State = models.UploadRequestStateQueued
+			request.ExpiresAt = expiresAt
+			if item, ok := findUploadItem(result.Items, request.Path); ok {
+				request.UploadID = item.ID
+			}
+		}
+		// Requests completed or cancelled while the device was called are left as they are
+		_, err := models.UpdateUploadRequest(db, &request, models.UploadRequestStatePending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh updates the progress of a device's queued upload requests from
+// its listUploadQueue, after expiring the ones whose URL has expired
+func Refresh(db *gorm.DB, device models.Device, call CallFunc) error {
+	err := models.ExpireUploadRequests(db, device.ID)
+	if err != nil {
+		return err
+	}
+	requests, err := models.ListUploadRequestsInState(db, device.ID, models.UploadRequestStateQueued)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	var items []apimodels.UploadItem
+	err = callDevice(call, "listUploadQueue", map[string]any{}, &items)
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		item, ok := findUploadItem(items, request.Path)
+		if !ok || (item.ID == request.UploadID && item.Progress == request.Progress) {
+			continue
+		}
+		request.UploadID = item.ID
+		request.Progress = item.Progress
+		_, err := models.UpdateUploadRequest(db, &request, models.UploadRequestStateQueued)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel marks the active requests in requests cancelled. If any of them were in
+// the device's upload queue, it returns the cancelUpload call that removes them
+// and when it stops mattering, once their URLs have expired.
+func Cancel(db *gorm.DB, requests []models.UploadRequest) (apimodels.RPCCall, time.Time, bool, error) {
+	var uploadIDs []string
+	var expiresAt time.Time
+	for _, request := range requests {
+		if !request.Active() {
+			continue
+		}
+		from, cancelled, err := cancelRequest(db, &request)
+		if err != nil {
+			return apimodels.RPCCall{}, time.Time{}, false, err
+		}
+		if !cancelled {
+			continue
+		}
+		if from == models.UploadRequestStateQueued && request.UploadID != "" {
+			uploadIDs = append(uploadIDs, request.UploadID)
+			if request.ExpiresAt.After(expiresAt) {
+				expiresAt = request.ExpiresAt
+			}
+		}
+	}
+	if len(uploadIDs) == 0 {
+		return apimodels.RPCCall{}, time.Time{}, false, nil
+	}
+
+	call, err := newCall("cancelUpload", map[string]any{"upload_id": uploadIDs})
+	if err != nil {
+		return apimodels.RPCCall{}, time.Time{}, false, err
+	}
+	return call, expiresAt, true, nil
+}
+
+// cancelRequest marks a request cancelled, following it if it's sent to the device
+// in the meantime. It returns the state it was cancelled from, and false if it
+// finished before it could be cancelled.
+func cancelRequest(db *gorm.DB, request *models.UploadRequest) (models.UploadRequestState, bool, error) {
+	for request.Active() {
+		from := request.State
+		request.State = models.UploadRequestStateCancelled
+		cancelled, err := models.UpdateUploadRequest(db, request, from)
+		if err != nil || cancelled {
+			return from, cancelled, err
+		}
+		*request, err = models.FindUploadRequestByID(db, request.ID)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+func newCall(method string, params any) (apimodels.RPCCall, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return apimodels.RPCCall{}, err
+	}
+	return apimodels.RPCCall{
+		ID:             id.String(),
+		Method:         method,
+		JSONRPCVersion: "2.0",
+		Params:         params,
+	}, nil
+}
+
+// callDevice makes an RPC call and decodes its result into result
+func callDevice(call CallFunc, method string, params any, result any) error {
+	rpcCall, err := newCall(method, params)
+	if err != nil {
+		return err
+	}
+	resp, err := call(rpcCall)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", ErrDeviceError, resp.Error)
+	}
+	// The result was decoded without knowing its type
+	data, err := json.Marshal(resp.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+// findUploadItem finds a file in an upload queue. The queue has the file's
+// absolute path on the device, which ends with the path it was requested by.
+func findUploadItem(items []apimodels.UploadItem, path string) (apimodels.UploadItem, bool) {
+	for _, item := range items {
+		if strings.HasSuffix(item.Path, "/"+path) {
+			return item, true
+		}
+	}
+	return apimodels.UploadItem{}, false
+}
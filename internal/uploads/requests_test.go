@@ -0,0 +1,162 @@
+package uploads_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/server/apimodels"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+	"github.com/USA-RedDragon/rtz-server/internal/uploads"
+)
+
+func TestUploadRequestLifecycle(t *testing.T) {
+	t.Parallel()
+	cfg := testutil.Config(t)
+	cfg.HTTP.BackendURL = "https://rtz.example"
+	cfg.JWT.Secret = "secret"
+	database := testutil.OpenDB(t, cfg)
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device := testutil.NewDevice(t, database)
+	route := models.Route{DeviceID: device.ID, RouteID: "0123456789"}
+	if err := database.Create(&route).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const camera = "0000001a--0123456789--0/fcamera.hevc"
+	const log = "0000001a--0123456789--0/rlog.zst"
+	requests := []models.UploadRequest{
+		{DeviceID: device.ID, RouteID: route.ID, Path: camera},
+		{DeviceID: device.ID, RouteID: route.ID, Path: log},
+	}
+	if _, err := models.CreateUploadRequests(database, requests); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Asking again doesn't duplicate the requests
+	created, err := models.CreateUploadRequests(database, requests[:1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(created) != 1 || created[0].Path != camera {
+		t.Fatalf("expected the existing request, got %+v", created)
+	}
+
+	var sent []apimodels.RPCCall
+	respond := func(result any) uploads.CallFunc {
+		return func(call apimodels.RPCCall) (apimodels.RPCResponse, error) {
+			sent = append(sent, call)
+			return apimodels.RPCResponse{ID: call.ID, JSONRPCVersion: "2.0", Result: result}, nil
+		}
+	}
+
+	err = uploads.Dispatch(cfg, database, store, device, respond(map[string]any{
+		"enqueued": 1,
+		"items":    []map[string]any{{"id": "upload-1", "path": "/data/media/0/realdata/" + camera}},
+		"failed":   []string{log},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != 1 || sent[0].Method != "uploadFilesToUrls" {
+		t.Fatalf("expected uploadFilesToUrls, got %+v", sent)
+	}
+	files, _ := sent[0].Params.(map[string]any)["files_data"].([]apimodels.UploadFileData)
+	if len(files) != 2 || files[0].FileName != camera {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if !strings.HasPrefix(files[0].URL, "https://rtz.example/v1.4/0000000000000001/upload?path=") || !strings.Contains(files[0].URL, "&sig=") {
+		t.Fatalf("expected a signed upload URL, got %s", files[0].URL)
+	}
+
+	requests, err = models.ListUploadRequests(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests[0].State != models.UploadRequestStateQueued || requests[0].UploadID != "upload-1" {
+		t.Fatalf("expected the camera to be queued, got %+v", requests[0])
+	}
+	if requests[1].State != models.UploadRequestStateFailed {
+		t.Fatalf("expected the missing log to fail, got %+v", requests[1])
+	}
+
+	err = uploads.Refresh(database, device, respond([]map[string]any{
+		{"id": "upload-1", "path": "/data/media/0/realdata/" + camera, "progress": 0.5},
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requests, err = models.ListUploadRequests(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests[0].Progress != 0.5 {
+		t.Fatalf("expected the progress to be refreshed, got %v", requests[0].Progress)
+	}
+
+	call, _, ok, err := uploads.Cancel(database, requests)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ids, _ := call.Params.(map[string]any)["upload_id"].([]string)
+	if !ok || call.Method != "cancelUpload" || len(ids) != 1 || ids[0] != "upload-1" {
+		t.Fatalf("expected the queued upload to be cancelled on the device, got %+v", call)
+	}
+
+	// An upload that lands after being cancelled doesn't revive the request
+	if err := models.CompleteUploadRequests(database, device.ID, camera); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requests, err = models.ListUploadRequests(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests[0].State != models.UploadRequestStateCancelled || requests[1].State != models.UploadRequestStateFailed {
+		t.Fatalf("unexpected states: %s, %s", requests[0].State, requests[1].State)
+	}
+}
+
+func TestUploadRequestCompletedDuringCallIsKept(t *testing.T) {
+	t.Parallel()
+	cfg := testutil.Config(t)
+	cfg.HTTP.BackendURL = "https://rtz.example"
+	cfg.JWT.Secret = "secret"
+	database := testutil.OpenDB(t, cfg)
+	store, err := storage.NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device := testutil.NewDevice(t, database)
+	route := models.Route{DeviceID: device.ID, RouteID: "0123456789"}
+	if err := database.Create(&route).Error; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const camera = "0000001a--0123456789--0/fcamera.hevc"
+	if _, err := models.CreateUploadRequests(database, []models.UploadRequest{{DeviceID: device.ID, RouteID: route.ID, Path: camera}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The file lands while the device is still responding
+	err = uploads.Dispatch(cfg, database, store, device, func(call apimodels.RPCCall) (apimodels.RPCResponse, error) {
+		if err := models.CompleteUploadRequests(database, device.ID, camera); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return apimodels.RPCResponse{ID: call.ID, JSONRPCVersion: "2.0", Result: map[string]any{
+			"enqueued": 1,
+			"items":    []map[string]any{{"id": "upload-1", "path": "/data/media/0/realdata/" + camera}},
+		}}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requests, err := models.ListUploadRequests(database, route.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 || requests[0].State != models.UploadRequestStateUploaded {
+		t.Fatalf("expected the completed upload not to be queued again, got %+v", requests)
+	}
+}
@@ -0,0 +1,127 @@
+package uploads
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/logparser"
+	"github.com/USA-RedDragon/rtz-server/internal/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	// PresignExpiry is how long a presigned upload URL stays valid
+	PresignExpiry = time.Hour
+	// WatchInterval is how often pending presigned uploads are checked for completion
+	WatchInterval = 15 * time.Second
+)
+
+// Watcher detects when devices finish uploading directly to storage through
+// presigned URLs and runs the same post-processing a proxied upload gets
+type Watcher struct {
+	db        *gorm.DB
+	storage   storage.Storage
+	logQueue  *logparser.LogQueue
+	interval  time.Duration
+	stopChan  chan any
+	closeChan chan any
+}
+
+// NewWatcher returns nil if the storage driver doesn't support presigned uploads
+func NewWatcher(db *gorm.DB, store storage.Storage, logQueue *logparser.LogQueue) *Watcher {
+	if _, ok := store.(storage.Presigner); !ok {
+		return nil
+	}
+	return &Watcher{
+		db:        db,
+		storage:   store,
+		logQueue:  logQueue,
+		interval:  WatchInterval,
+		stopChan:  make(chan any),
+		closeChan: make(chan any),
+	}
+}
+
+func (w *Watcher) Start() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopChan:
+			w.closeChan <- struct{}{}
+			return
+		case <-ticker.C:
+			w.Check()
+		}
+	}
+}
+
+func (w *Watcher) Stop() {
+	close(w.stopChan)
+	<-w.closeChan
+}
+
+// Check looks for pending uploads that have landed in storage and processes them
+func (w *Watcher) Check() {
+	pending, err := models.ListPendingUploads(w.db)
+	if err != nil {
+		slog.Error("Failed to list pending uploads", "error", err)
+		return
+	}
+
+	for _, upload := range pending {
+		info, err := w.storage.Stat(filepath.Join(upload.Device.DongleID, upload.Path))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if time.Now().After(upload.ExpiresAt) {
+				slog.Warn("Presigned upload expired", "dongle_id", upload.Device.DongleID, "path", upload.Path)
+				w.forget(upload)
+			}
+			continue
+		case err != nil:
+			slog.Error("Failed to check for upload", "dongle_id", upload.Device.DongleID, "path", upload.Path, "error", err)
+			continue
+		}
+
+		// S3 timestamps are truncated to the second
+		if info.ModTime().Before(upload.IssuedAt.Truncate(time.Second)) {
+			if time.Now().After(upload.ExpiresAt) {
+				w.forget(upload)
+			}
+			continue
+		}
+
+		claimed, err := models.ClaimPendingUpload(w.db, upload)
+		if err != nil {
+			slog.Error("Failed to claim pending upload", "dongle_id", upload.Device.DongleID, "path", upload.Path, "error", err)
+			continue
+		}
+		if !claimed {
+			// Another instance got to it first, or a new URL was issued for the path
+			continue
+		}
+		base, err := w.storage.Sub(upload.Device.DongleID)
+		if err != nil {
+			slog.Error("Failed to get base storage", "error", err)
+			continue
+		}
+		err = Process(w.db, base, w.logQueue, upload.Device, upload.Path)
+		if err != nil {
+			slog.Error("Failed to process upload", "dongle_id", upload.Device.DongleID, "path", upload.Path, "error", err)
+		}
+		if err := base.Close(); err != nil {
+			slog.Warn("Failed to close base storage", "error", err)
+		}
+	}
+}
+
+func (w *Watcher) forget(upload models.PendingUpload) {
+	err := models.DeletePendingUpload(w.db, upload.ID)
+	if err != nil {
+		slog.Error("Failed to delete pending upload", "error", err)
+	}
+}
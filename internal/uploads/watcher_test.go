@@ -0,0 +1,59 @@
+package uploads_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/db/models"
+	"github.com/USA-RedDragon/rtz-server/internal/testutil"
+)
+
+func TestClaimPendingUpload(t *testing.T) {
+	t.Parallel()
+	database := testutil.NewDB(t)
+	device := testutil.NewDevice(t, database)
+
+	const path = "0000001a--0123456789--0/rlog.zst"
+	issued := time.Now()
+	if err := models.UpsertPendingUpload(database, device.ID, path, issued, issued.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stale, err := models.ListPendingUploads(database)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stale) != 1 {
+		t.Fatalf("expected one pending upload, got %d", len(stale))
+	}
+
+	// A URL issued again after the upload was read can't be claimed with the old read
+	reissued := issued.Add(time.Minute)
+	if err := models.UpsertPendingUpload(database, device.ID, path, reissued, reissued.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claimed, err := models.ClaimPendingUpload(database, stale[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claimed {
+		t.Fatalf("expected a stale read not to claim the reissued upload")
+	}
+
+	// Of two instances that read the same upload, only one claims it
+	pending, err := models.ListPendingUploads(database)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected one pending upload, got %d", len(pending))
+	}
+	for i, want := range []bool{true, false} {
+		claimed, err := models.ClaimPendingUpload(database, pending[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if claimed != want {
+			t.Fatalf("claim %d: expected %v, got %v", i, want, claimed)
+		}
+	}
+}
@@ -137,3 +137,65 @@ func VerifyDeviceJWT(did string, signingKey string, tokenString string) error {
 	}
 	return nil
 }
+
+// fileAudience keeps file download tokens from being accepted anywhere else,
+// and uploadAudience upload tokens
+const (
+	fileAudience   = "file"
+	uploadAudience = "upload"
+)
+
+// GenerateFileJWT signs a token granting download access to a single stored file
+func GenerateFileJWT(signingKey string, path string, expiry time.Duration) (string, error) {
+	return generatePathJWT(signingKey, fileAudience, path, expiry)
+}
+
+// VerifyFileJWT returns the path of the file a download token grants access to
+func VerifyFileJWT(signingKey string, tokenString string) (string, error) {
+	return verifyPathJWT(signingKey, fileAudience, tokenString)
+}
+
+// GenerateUploadJWT signs a token granting a single file to be uploaded
+// without the device's own token, for upload URLs the server hands out
+func GenerateUploadJWT(signingKey string, path string, expiry time.Duration) (string, error) {
+	return generatePathJWT(signingKey, uploadAudience, path, expiry)
+}
+
+// VerifyUploadJWT returns the path of the file an upload token grants to be uploaded
+func VerifyUploadJWT(signingKey string, tokenString string) (string, error) {
+	return verifyPathJWT(signingKey, uploadAudience, tokenString)
+}
+
+func generatePathJWT(signingKey string, audience string, path string, expiry time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   path,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now.Add(-30 * time.Second)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(signingKey))
+}
+
+func verifyPathJWT(signingKey string, audience string, tokenString string) (string, error) {
+	claims := new(jwt.RegisteredClaims)
+	token, err := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired()).
+		ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("invalid signing method: %s", token.Header["alg"])
+			}
+			return []byte(signingKey), nil
+		})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+	return claims.Subject, nil
+}
@@ -218,3 +218,77 @@ func TestGenerateVerify(t *testing.T) {
 		t.Errorf("expected %d, got %d", uid, gotUID)
 	}
 }
+
+func TestFileJWT(t *testing.T) {
+	t.Parallel()
+
+	secret := "changeme"
+	path := "1d3dc3e03047b0c7/000000dd--455f14369d--0/qlog.zst"
+	token, err := utils.GenerateFileJWT(secret, path, time.Hour)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	gotPath, err := utils.VerifyFileJWT(secret, token)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if gotPath != path {
+		t.Errorf("expected %s, got %s", path, gotPath)
+	}
+
+	_, err = utils.VerifyFileJWT("wrong", token)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	expired, err := utils.GenerateFileJWT(secret, path, -time.Minute)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = utils.VerifyFileJWT(secret, expired)
+	if err == nil {
+		t.Error("expected error for expired token, got nil")
+	}
+
+	// User tokens must not grant file access
+	userToken, err := utils.GenerateJWT(secret, 1)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = utils.VerifyFileJWT(secret, userToken)
+	if err == nil {
+		t.Error("expected error for user token, got nil")
+	}
+}
+
+func TestUploadJWT(t *testing.T) {
+	t.Parallel()
+
+	secret := "changeme"
+	path := "1d3dc3e03047b0c7/000000dd--455f14369d--0/fcamera.hevc"
+	token, err := utils.GenerateUploadJWT(secret, path, time.Hour)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	gotPath, err := utils.VerifyUploadJWT(secret, token)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if gotPath != path {
+		t.Errorf("expected %s, got %s", path, gotPath)
+	}
+
+	// Upload and download tokens must not be interchangeable
+	_, err = utils.VerifyFileJWT(secret, token)
+	if err == nil {
+		t.Error("expected error for upload token used as file token, got nil")
+	}
+	fileToken, err := utils.GenerateFileJWT(secret, path, time.Hour)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = utils.VerifyUploadJWT(secret, fileToken)
+	if err == nil {
+		t.Error("expected error for file token used as upload token, got nil")
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// RouteShareSignature signs a route's fullname so it can be viewed without logging in
+// until exp, a unix timestamp in seconds. This is the share_sig of the routes API.
+func RouteShareSignature(signingKey string, route string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte("route_share:" + route + ":" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyRouteShareSignature(signingKey string, route string, exp int64, sig string) bool {
+	if time.Now().Unix() > exp {
+		return false
+	}
+	expected := RouteShareSignature(signingKey, route, exp)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
@@ -0,0 +1,32 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/USA-RedDragon/rtz-server/internal/utils"
+)
+
+func TestRouteShareSignature(t *testing.T) {
+	t.Parallel()
+
+	secret := "changeme"
+	route := "1d3dc3e03047b0c7|455f14369d:1"
+	exp := time.Now().Add(time.Hour).Unix()
+	sig := utils.RouteShareSignature(secret, route, exp)
+
+	if !utils.VerifyRouteShareSignature(secret, route, exp, sig) {
+		t.Error("expected signature to verify")
+	}
+	if utils.VerifyRouteShareSignature(secret, "1d3dc3e03047b0c7|455f14369d:2", exp, sig) {
+		t.Error("expected signature for another route to fail")
+	}
+	if utils.VerifyRouteShareSignature(secret, route, exp+1, sig) {
+		t.Error("expected signature with a changed expiry to fail")
+	}
+
+	past := time.Now().Add(-time.Hour).Unix()
+	if utils.VerifyRouteShareSignature(secret, route, past, utils.RouteShareSignature(secret, route, past)) {
+		t.Error("expected expired signature to fail")
+	}
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeGracePeriod is how long the other end has to receive a close message
+const closeGracePeriod = time.Second
+
+// Bridge copies messages between two websockets until either side closes.
+// It returns how many bytes were copied from a to b and from b to a.
+func Bridge(a, b *websocket.Conn) (int64, int64) {
+	var aToB, bToA int64
+	bridge(a, b,
+		func() error { return copyMessages(b, a, &aToB) },
+		func() error { return copyMessages(a, b, &bToA) },
+	)
+	return aToB, bToA
+}
+
+// BridgeTCP copies between a websocket and a TCP connection until either side
+// closes, sending what's read from the connection as binary messages.
+// It returns how many bytes were copied from ws to conn and from conn to ws.
+func BridgeTCP(ws *websocket.Conn, conn net.Conn) (int64, int64) {
+	var toConn, fromConn int64
+	bridge(ws, conn,
+		func() error {
+			for {
+				_, msg, err := ws.ReadMessage()
+				if err != nil {
+					return err
+				}
+				_, err = conn.Write(msg)
+				if err != nil {
+					return err
+				}
+				toConn += int64(len(msg))
+			}
+		},
+		func() error {
+			buf := make([]byte, 32*1024)
+			for {
+				read, err := conn.Read(buf)
+				if err != nil {
+					return err
+				}
+				err = ws.WriteMessage(websocket.BinaryMessage, buf[:read])
+				if err != nil {
+					return err
+				}
+				fromConn += int64(read)
+			}
+		},
+	)
+	return toConn, fromConn
+}
+
+type closer interface {
+	Close() error
+}
+
+// bridge runs both copies until one stops, then closes both sides so the
+// other stops too
+func bridge(a, b closer, copies ...func() error) {
+	done := make(chan struct{}, len(copies))
+	for _, run := range copies {
+		go func() {
+			_ = run()
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	for _, side := range []closer{a, b} {
+		if ws, ok := side.(*websocket.Conn); ok {
+			_ = ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(closeGracePeriod))
+		}
+		_ = side.Close()
+	}
+	for range len(copies) - 1 {
+		<-done
+	}
+}
+
+func copyMessages(dst, src *websocket.Conn, copied *int64) error {
+	for {
+		messageType, msg, err := src.ReadMessage()
+		if err != nil {
+			return err
+		}
+		err = dst.WriteMessage(messageType, msg)
+		if err != nil {
+			return err
+		}
+		*copied += int64(len(msg))
+	}
+}
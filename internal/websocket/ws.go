@@ -30,34 +30,40 @@ type WSHandler struct {
 	conn       *websocket.Conn
 }
 
+// CheckOrigin allows websocket connections from the configured CORS hosts
+// and from clients that don't send an Origin, like devices
+func CheckOrigin(config *config.Config) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		origin = strings.ToLower(origin)
+		for _, host := range config.HTTP.CORSHosts {
+			host = strings.ToLower(host)
+			if strings.HasSuffix(host, ":443") && strings.HasPrefix(origin, "https://") {
+				host = strings.TrimSuffix(host, ":443")
+			}
+			if strings.HasSuffix(host, ":80") && strings.HasPrefix(origin, "http://") {
+				host = strings.TrimSuffix(host, ":80")
+			}
+			if strings.Contains(origin, host) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 func CreateHandler(ws Websocket, config *config.Config) func(*gin.Context) {
 	handler := &WSHandler{
 		wsUpgrader: websocket.Upgrader{
-			HandshakeTimeout: 0,
-			ReadBufferSize:   bufferSize,
-			WriteBufferSize:  bufferSize,
-			WriteBufferPool:  nil,
-			Subprotocols:     []string{},
-			CheckOrigin: func(r *http.Request) bool {
-				origin := r.Header.Get("Origin")
-				if origin == "" {
-					return true
-				}
-				origin = strings.ToLower(origin)
-				for _, host := range config.HTTP.CORSHosts {
-					host = strings.ToLower(host)
-					if strings.HasSuffix(host, ":443") && strings.HasPrefix(origin, "https://") {
-						host = strings.TrimSuffix(host, ":443")
-					}
-					if strings.HasSuffix(host, ":80") && strings.HasPrefix(origin, "http://") {
-						host = strings.TrimSuffix(host, ":80")
-					}
-					if strings.Contains(origin, host) {
-						return true
-					}
-				}
-				return false
-			},
+			HandshakeTimeout:  0,
+			ReadBufferSize:    bufferSize,
+			WriteBufferSize:   bufferSize,
+			WriteBufferPool:   nil,
+			Subprotocols:      []string{},
+			CheckOrigin:       CheckOrigin(config),
 			EnableCompression: true,
 		},
 		handler: ws,